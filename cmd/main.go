@@ -1,18 +1,38 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/big"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
 	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/confirmation"
+	"github.com/Luganodes/Pectra-CLI/internal/conflict"
+	"github.com/Luganodes/Pectra-CLI/internal/cost"
+	"github.com/Luganodes/Pectra-CLI/internal/estimate"
+	"github.com/Luganodes/Pectra-CLI/internal/group"
+	"github.com/Luganodes/Pectra-CLI/internal/journal"
 	"github.com/Luganodes/Pectra-CLI/internal/operations"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/plan"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/internal/safe"
 	"github.com/Luganodes/Pectra-CLI/internal/transaction"
 	"github.com/Luganodes/Pectra-CLI/internal/utils"
+	"github.com/Luganodes/Pectra-CLI/internal/watch"
+	"github.com/Luganodes/Pectra-CLI/internal/workflow"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
 )
@@ -21,6 +41,240 @@ const (
 	version = "1.0.0"
 )
 
+// confirmMainnetFlag must be passed to acknowledge operations on Ethereum mainnet
+var confirmMainnetFlag = &cli.BoolFlag{
+	Name:  "confirm-mainnet",
+	Usage: "Acknowledge that the operation is executed on Ethereum mainnet",
+}
+
+// skipCodeVerificationFlag disables the check of the batch contract code against the audited code hashes
+var skipCodeVerificationFlag = &cli.BoolFlag{
+	Name:  "skip-code-verification",
+	Usage: "Delegate to the configured batch contract even if its code does not match an audited release (dangerous)",
+}
+
+// gasHeadroomFlag is the percentage added to the gas price at plan time for the planned max fee
+var gasHeadroomFlag = &cli.Uint64Flag{
+	Name:  "gas-headroom",
+	Usage: "Percentage added to the gas price at plan time for the planned max fee, apply refuses a plan whose max fee exceeds it and refuses once the gas price exceeds the planned max fee",
+	Value: 20,
+}
+
+// sponsorFlag makes a separate sponsor account pay for and send the transaction. Only unset-code
+// has it: the batch contract reverts with Unauthorized() for any caller but the EOA itself.
+var sponsorFlag = &cli.BoolFlag{
+	Name:  "sponsor",
+	Usage: "Have a separate sponsor account pay for the transaction, the withdrawal key only signs the EIP-7702 authorization",
+}
+
+// authorizationOnlyFlag makes airgapped mode produce only the EIP-7702 authorization for offline signing
+var authorizationOnlyFlag = &cli.BoolFlag{
+	Name:  "authorization-only",
+	Usage: "In airgapped mode, only write the EIP-7702 authorization for offline signing, the transaction is assembled later with fresh fees",
+}
+
+// groupFlag splits the validators of an operation by withdrawal address
+var groupFlag = &cli.BoolFlag{
+	Name:  "group-by-withdrawal-address",
+	Usage: "Resolve the withdrawal address of each validator from the beacon state and run one batch per address",
+}
+
+// dryRunFlags simulate an operation instead of executing it
+var dryRunFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Validate, estimate and simulate the operation and print the transaction without asking for a key, sending or writing anything",
+	},
+	&cli.StringFlag{
+		Name:  "from",
+		Usage: "Withdrawal address used in dry-run and airgapped mode, prompted for if not set",
+	},
+}
+
+// offlineFlags supply the chain data that is otherwise read from the RPC endpoint
+var offlineFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "offline",
+		Usage: "Prepare unsigned transactions without any RPC access, implies --airgapped",
+	},
+	&cli.StringFlag{
+		Name:  "snapshot",
+		Usage: "Path to a network snapshot exported with the snapshot command (offline mode)",
+	},
+	&cli.Uint64Flag{
+		Name:  "chain-id",
+		Usage: "Chain ID (offline mode)",
+	},
+	&cli.Uint64Flag{
+		Name:  "nonce",
+		Usage: "Current nonce of the withdrawal address (offline mode)",
+	},
+	&cli.StringFlag{
+		Name:  "max-fee-per-gas",
+		Usage: "Max fee per gas in wei (offline mode)",
+	},
+	&cli.StringFlag{
+		Name:  "max-priority-fee-per-gas",
+		Usage: "Max priority fee per gas in wei (offline mode)",
+	},
+	&cli.StringFlag{
+		Name:  "request-fee",
+		Usage: "Fee per validator request in wei (offline mode)",
+	},
+}
+
+// runOptions holds the command line options shared by all operations
+type runOptions struct {
+	ConfigPath           string
+	Airgapped            bool
+	ConfirmMainnet       bool
+	SkipCodeVerification bool
+	Sponsor              bool
+	AuthorizationOnly    bool
+	// SkipConfirmed drops validators whose requests are already confirmed in the journal
+	SkipConfirmed bool
+	// DryRun simulates the operation as sent by From instead of executing it
+	DryRun bool
+	From   string
+	// Plan writes a plan of the operation as sent by From instead of executing it
+	Plan *planOptions
+	// GroupByWithdrawalAddress runs one batch per withdrawal address of the validators
+	GroupByWithdrawalAddress bool
+	// Config and PrivateKey are used instead of loading the config file and prompting for the key,
+	// when a workflow runs several operations
+	Config     *config.Config
+	PrivateKey *ecdsa.PrivateKey
+	// Journal records the transactions sent, OutputDir receives the files written in airgapped and
+	// offline mode, the working directory if it is not set
+	Journal   *journal.Journal
+	OutputDir string
+
+	// Offline mode options
+	Offline              bool
+	SnapshotPath         string
+	ChainID              uint64
+	Nonce                *uint64
+	MaxFeePerGas         string
+	MaxPriorityFeePerGas string
+	RequestFee           string
+}
+
+// newRunOptions reads the shared operation options from the command line context
+func newRunOptions(c *cli.Context) runOptions {
+	opts := runOptions{
+		ConfigPath:               c.String("config"),
+		Airgapped:                c.Bool("airgapped"),
+		ConfirmMainnet:           c.Bool("confirm-mainnet"),
+		SkipCodeVerification:     c.Bool("skip-code-verification"),
+		Sponsor:                  c.Bool("sponsor"),
+		AuthorizationOnly:        c.Bool("authorization-only"),
+		DryRun:                   c.Bool("dry-run"),
+		GroupByWithdrawalAddress: c.Bool("group-by-withdrawal-address"),
+		From:                     c.String("from"),
+		Offline:                  c.Bool("offline"),
+		SnapshotPath:             c.String("snapshot"),
+		ChainID:                  c.Uint64("chain-id"),
+		MaxFeePerGas:             c.String("max-fee-per-gas"),
+		MaxPriorityFeePerGas:     c.String("max-priority-fee-per-gas"),
+		RequestFee:               c.String("request-fee"),
+		Journal:                  journal.Open(c.String("journal")),
+	}
+
+	if c.IsSet("nonce") {
+		nonce := c.Uint64("nonce")
+		opts.Nonce = &nonce
+	}
+
+	return opts
+}
+
+// planOptions are the options of the plan command
+type planOptions struct {
+	FileName           string
+	GasHeadroomPercent uint64
+}
+
+// config returns the configuration of the operation, loading it from the config file if needed
+func (opts runOptions) config() (*config.Config, error) {
+	if opts.Config != nil {
+		return opts.Config, nil
+	}
+	return config.LoadConfig(opts.ConfigPath)
+}
+
+// transactionOptions returns the options of the transaction functions run for the configuration
+func (opts runOptions) transactionOptions(cfg *config.Config) transaction.Options {
+	return transaction.Options{
+		ExplorerURL: cfg.BlockExplorerUrl,
+		Journal:     opts.Journal,
+		OutputDir:   opts.OutputDir,
+	}
+}
+
+// keyless reports whether the operation is only simulated or planned, so no key is needed
+func (opts runOptions) keyless() bool {
+	return opts.DryRun || opts.Plan != nil
+}
+
+// offlineSnapshot builds the network snapshot used in offline mode from the snapshot file and
+// the offline flags, flags taking precedence over the file
+func (opts runOptions) offlineSnapshot() (*transaction.NetworkSnapshot, error) {
+	snapshot := &transaction.NetworkSnapshot{}
+	if opts.SnapshotPath != "" {
+		var err error
+		snapshot, err = transaction.ReadSnapshot(opts.SnapshotPath)
+		if err != nil {
+			return nil, err
+		}
+	} else if opts.Nonce == nil {
+		return nil, fmt.Errorf("offline mode requires --snapshot or --nonce")
+	}
+
+	if opts.ChainID != 0 {
+		snapshot.ChainID = new(big.Int).SetUint64(opts.ChainID)
+	}
+	// The nonce and delegation of the snapshot belong to its address, so --from cannot replace it
+	if opts.From != "" {
+		if !common.IsHexAddress(opts.From) {
+			return nil, fmt.Errorf("invalid --from address %s", opts.From)
+		}
+		from := common.HexToAddress(opts.From)
+		if snapshot.Address != (common.Address{}) && snapshot.Address != from {
+			return nil, fmt.Errorf("--from %s differs from the snapshot address %s, whose nonce and delegation the snapshot holds", from.Hex(), snapshot.Address.Hex())
+		}
+		snapshot.Address = from
+	}
+	if opts.Nonce != nil {
+		snapshot.Nonce = *opts.Nonce
+	}
+
+	weiFlags := []struct {
+		name  string
+		value string
+		dest  **big.Int
+	}{
+		{"max-fee-per-gas", opts.MaxFeePerGas, &snapshot.MaxFeePerGas},
+		{"max-priority-fee-per-gas", opts.MaxPriorityFeePerGas, &snapshot.MaxPriorityFeePerGas},
+		{"request-fee", opts.RequestFee, &snapshot.ConsolidationFee},
+		{"request-fee", opts.RequestFee, &snapshot.ExitFee},
+	}
+	for _, flag := range weiFlags {
+		if flag.value == "" {
+			continue
+		}
+		value, ok := new(big.Int).SetString(flag.value, 10)
+		if !ok || value.Sign() < 0 {
+			return nil, fmt.Errorf("invalid --%s value %q, expected an amount in wei", flag.name, flag.value)
+		}
+		*flag.dest = value
+	}
+
+	if err := snapshot.Validate(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
 func main() {
 	app := &cli.App{
 		Name:     "pectra-cli",
@@ -34,12 +288,35 @@ func main() {
 			},
 		},
 		Description: "Pectra CLI is a tool for executing operations on Ethereum validators including switching, consolidation, and execution layer exits",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "journal",
+				Usage: "Path to the journal recording every transaction sent",
+				Value: journal.DefaultPath,
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output mode, text or json. In json mode a single result is written to stdout and everything else to stderr",
+				Value: output.ModeText,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			output.SetCommand(c.Args().First())
+			if err := output.Setup(c.String("output")); err != nil {
+				return err
+			}
+			if output.JSON() {
+				// Help and usage errors must not mix with the result on stdout
+				c.App.Writer = os.Stderr
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:        "switch",
 				Usage:       "Execute batch switch operation for validators",
 				Description: "Switch validators to a new setup based on configuration",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "config",
 						Aliases:  []string{"c"},
@@ -51,16 +328,20 @@ func main() {
 						Aliases: []string{"a"},
 						Usage:   "Run in airgapped mode",
 					},
-				},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+					authorizationOnlyFlag,
+					groupFlag,
+				}, append(dryRunFlags, offlineFlags...)...),
 				Action: func(c *cli.Context) error {
-					return runCommand("switch", c.String("config"), c.Bool("airgapped"))
+					return runOperation("switch", newRunOptions(c))
 				},
 			},
 			{
 				Name:        "consolidate",
 				Usage:       "Consolidate multiple validators into a target validator",
 				Description: "Consolidate funds from multiple source validators into a single target validator",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "config",
 						Aliases:  []string{"c"},
@@ -72,16 +353,20 @@ func main() {
 						Aliases: []string{"a"},
 						Usage:   "Run in airgapped mode",
 					},
-				},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+					authorizationOnlyFlag,
+					groupFlag,
+				}, append(dryRunFlags, offlineFlags...)...),
 				Action: func(c *cli.Context) error {
-					return runCommand("consolidate", c.String("config"), c.Bool("airgapped"))
+					return runOperation("consolidate", newRunOptions(c))
 				},
 			},
 			{
 				Name:        "el-exit",
 				Usage:       "Execute partial or full exits for validators",
 				Description: "Execute execution layer exits for validators, either partially or fully",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "config",
 						Aliases:  []string{"c"},
@@ -93,16 +378,20 @@ func main() {
 						Aliases: []string{"a"},
 						Usage:   "Run in airgapped mode",
 					},
-				},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+					authorizationOnlyFlag,
+					groupFlag,
+				}, append(dryRunFlags, offlineFlags...)...),
 				Action: func(c *cli.Context) error {
-					return runCommand("el-exit", c.String("config"), c.Bool("airgapped"))
+					return runOperation("el-exit", newRunOptions(c))
 				},
 			},
 			{
 				Name:        "unset-code",
 				Usage:       "Unset code for the contract",
 				Description: "Remove the contract code (for emergency situations only)",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:     "config",
 						Aliases:  []string{"c"},
@@ -114,9 +403,63 @@ func main() {
 						Aliases: []string{"a"},
 						Usage:   "Run in airgapped mode",
 					},
+					confirmMainnetFlag,
+					sponsorFlag,
+					authorizationOnlyFlag,
+				}, offlineFlags...),
+				Action: func(c *cli.Context) error {
+					return runCommand("unset-code", newRunOptions(c))
+				},
+			},
+			{
+				Name:        "assemble",
+				Aliases:     []string{"sponsor"},
+				Usage:       "Assemble the transaction for an authorization signed offline",
+				Description: "Assemble the transaction for the EIP-7702 authorization from a JSON file produced with --airgapped --authorization-only or --airgapped --sponsor and signed offline, using fresh fees. Sponsored authorizations are signed and sent with the sponsor key, otherwise the transaction is written to unsigned_txn.json for a second offline signature by the withdrawal address.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "file",
+						Aliases:  []string{"f"},
+						Usage:    "Path to JSON file containing the signed authorization (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+				},
+				Action: func(c *cli.Context) error {
+					return assembleAuthorization(c.String("file"), newRunOptions(c))
+				},
+			},
+			{
+				Name:        "snapshot",
+				Usage:       "Export chain data for offline transaction preparation",
+				Description: "Export the chain ID, nonce, fees, request fees, current delegation and batch contract code hash for a withdrawal address to a network snapshot file, to be used with --offline on the airgapped machine",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "address",
+						Usage: "Withdrawal address to take the snapshot for (prompted if not set)",
+					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "Path of the snapshot file to write",
+						Value:   "network_snapshot.json",
+					},
 				},
 				Action: func(c *cli.Context) error {
-					return runCommand("unset-code", c.String("config"), c.Bool("airgapped"))
+					return takeSnapshot(c.String("config"), c.String("address"), c.String("out"))
 				},
 			},
 			{
@@ -136,164 +479,1954 @@ func main() {
 						Usage:    "Path to config file (required)",
 						Required: true,
 					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Withdrawal address expected to have signed the transaction (prompted if not set)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "allow-delegation",
+						Usage: "Additional contract the authorization may delegate to, e.g. to restore a previous delegation",
+					},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
 				},
 				Action: func(c *cli.Context) error {
-					return broadcastTransaction(c.String("file"), c.String("config"))
+					opts := transaction.BroadcastOptions{
+						ConfirmMainnet:       c.Bool("confirm-mainnet"),
+						SkipCodeVerification: c.Bool("skip-code-verification"),
+						Journal:              journal.Open(c.String("journal")),
+					}
+					if from := c.String("from"); from != "" {
+						if !common.IsHexAddress(from) {
+							return fmt.Errorf("invalid --from address: %s", from)
+						}
+						opts.ExpectedFrom = common.HexToAddress(from)
+					}
+					for _, address := range c.StringSlice("allow-delegation") {
+						if !common.IsHexAddress(address) {
+							return fmt.Errorf("invalid --allow-delegation address: %s", address)
+						}
+						opts.AllowedDelegations = append(opts.AllowedDelegations, common.HexToAddress(address))
+					}
+					return broadcastTransaction(c.String("file"), c.String("config"), opts)
 				},
 			},
-		},
-		// Use the custom help template from utils.PrintUsage when showing app help
-		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
-			utils.PrintUsage()
-			return err
-		},
-		CommandNotFound: func(c *cli.Context, command string) {
-			color.Red("Unknown command: %s", command)
-			utils.PrintUsage()
-		},
-	}
-
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func runCommand(command, configPath string, airgapped bool) error {
-	color.Green("Airgapped: %v", airgapped)
-
-	// Load configuration
-	cfg, err := config.LoadConfig(configPath)
-	if err != nil {
-		color.Red("Error loading config: %v", err)
-		return err
-	}
-
-	// Connect to Ethereum client
-	client, err := ethclient.Dial(cfg.RPCUrl)
-	if err != nil {
-		color.Red("Failed to connect to the Ethereum client: %v", err)
-		return err
-	}
-	color.Green("Connected to the Ethereum client")
-
-	var privateKey *ecdsa.PrivateKey
-	if !airgapped {
-		// Get private key securely
-		privateKey, err = config.GetPrivateKey()
-		if err != nil {
-			color.Red("Failed to get the private key: %v", err)
-			return err
-		}
-	}
-
-	contractAddress := common.HexToAddress(cfg.PectraBatchContract)
-
-	// Load the ABI
-	parsedAbi, err := config.LoadABI()
-	if err != nil {
-		color.Red("%v", err)
-		return err
-	}
-
-	// Create base operation
-	baseOp := operations.BaseOperation{
-		Client:          client,
-		ContractAddress: contractAddress,
-		ABI:             parsedAbi,
-		ExplorerUrl:     cfg.BlockExplorerUrl,
-		Airgapped:       airgapped,
-	}
-
-	if !airgapped {
-		baseOp.PrivateKey = privateKey
-	}
-
-	var op operations.Operation
-
-	// Helper function to get fee for a contract
-	getFeeForContract := func(functionName string) (int64, error) {
-		fee, err := utils.GetFee(client, contractAddress, parsedAbi, functionName)
-		if err != nil {
-			return 0, err
-		}
-		color.Green("Fee Amount per Validator: %v wei", fee)
-		return fee.Int64(), nil
-	}
-
-	switch command {
-	case "switch":
-		feeAmount, err := getFeeForContract("getConsolidationFee")
-		if err != nil {
-			color.Red("Failed to get the fee: %v", err)
-			return err
-		}
-
-		op = &operations.SwitchOperation{
-			BaseOperation:      baseOp,
-			Validators:         cfg.Switch.Validators,
-			AmountPerValidator: big.NewInt(feeAmount),
-		}
-
-	case "consolidate":
-		feeAmount, err := getFeeForContract("getConsolidationFee")
-		if err != nil {
-			color.Red("Failed to get the fee: %v", err)
-			return err
-		}
-
-		op = &operations.ConsolidateOperation{
-			BaseOperation:      baseOp,
-			SourceValidators:   cfg.Consolidate.SourceValidators,
-			TargetValidator:    cfg.Consolidate.TargetValidator,
-			AmountPerValidator: big.NewInt(feeAmount),
-		}
-
-	case "el-exit":
-		feeAmount, err := getFeeForContract("getExitFee")
-		if err != nil {
-			color.Red("Failed to get the fee: %v", err)
-			return err
-		}
-
-		op = &operations.ELExitOperation{
-			BaseOperation:      baseOp,
-			Validators:         cfg.ELExit.Validators,
-			AmountPerValidator: big.NewInt(feeAmount),
-		}
-
-	case "unset-code":
-		if !airgapped && privateKey == nil {
-			color.Red("Private key is required for unset-code operation in non-airgapped mode")
-			return fmt.Errorf("private key required")
-		}
-		err = transaction.SendTransactionUsingAuthorization(client, privateKey, common.Address{}, nil, nil, baseOp.ExplorerUrl, airgapped)
-		if err != nil {
-			color.Red("Failed to execute unset-code: %v", err)
-			return err
-		}
-		return nil
-
-	default:
-		color.Red("Unknown command: %s", command)
-		return fmt.Errorf("unknown command: %s", command)
-	}
-
-	// Execute the operation
-	if err := op.Execute(); err != nil {
-		color.Red("Operation failed: %v", err)
-		return err
-	}
-
-	return nil
+			{
+				Name:        "track",
+				Usage:       "Track a sent transaction until it is mined",
+				Description: "Reattach to a transaction by hash, broadcasting it again from the journal if it was dropped, and record its outcome in the journal",
+				ArgsUsage:   "<hash>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					hash := c.Args().First()
+					if c.NArg() != 1 || len(common.FromHex(hash)) != common.HashLength {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected a single transaction hash argument"))
+					}
+					return trackTransaction(c.String("config"), common.HexToHash(hash), journal.Open(c.String("journal")))
+				},
+			},
+			{
+				Name:        "resume",
+				Usage:       "Resume an interrupted run from the journal",
+				Description: "Reattach to every pending transaction in the journal, broadcasting dropped ones again. If an operation is given, it is then run again from the configuration, skipping validators whose requests are already confirmed in the journal.",
+				ArgsUsage:   "[switch|consolidate|el-exit]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:    "airgapped",
+						Aliases: []string{"a"},
+						Usage:   "Run in airgapped mode",
+					},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+					authorizationOnlyFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() > 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected at most one operation argument"))
+					}
+					return resume(c.Args().First(), newRunOptions(c))
+				},
+			},
+			{
+				Name:        "watch",
+				Usage:       "Follow validator requests through the beacon chain queues",
+				Description: "Poll the beacon node for the validators of a mined transaction and report each request from requested to processed or dropped, with ETA estimates. The final progress is written as a JSON report.",
+				ArgsUsage:   "[hash]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "latest",
+						Usage: "Watch the latest confirmed batch transaction of the journal instead of a hash",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "Time between two beacon node polls",
+						Value: time.Minute,
+					},
+					&cli.BoolFlag{
+						Name:  "once",
+						Usage: "Report the current progress once instead of polling until every request is final",
+					},
+					&cli.StringFlag{
+						Name:  "report",
+						Usage: "Path of the JSON report to write",
+						Value: "watch_report.json",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					opts := watchOptions{
+						ConfigPath: c.String("config"),
+						Latest:     c.Bool("latest"),
+						Interval:   c.Duration("interval"),
+						Once:       c.Bool("once"),
+						ReportPath: c.String("report"),
+						Journal:    journal.Open(c.String("journal")),
+					}
+					if !opts.Latest {
+						hash := c.Args().First()
+						if c.NArg() != 1 || len(common.FromHex(hash)) != common.HashLength {
+							return output.WithCode(output.CodeUsage, fmt.Errorf("expected a single transaction hash argument or --latest"))
+						}
+						opts.Hash = common.HexToHash(hash)
+					}
+					return watchTransaction(opts)
+				},
+			},
+			{
+				Name:        "estimate",
+				Usage:       "Estimate when the requests of an operation will be processed",
+				Description: "Read the pending queues and active balance from the beacon node, apply the Electra churn limits and the per-block request dequeue limits, and print the expected processing epoch and time of each validator of the configured batch. Nothing is sent.",
+				ArgsUsage:   "<switch|consolidate|el-exit>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.Uint64Flag{
+						Name:  "total-active-balance",
+						Usage: "Total active balance in ETH, instead of reading the balances of all validators",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the operation to estimate: switch, consolidate or el-exit"))
+					}
+					return estimateOperation(c.Args().First(), c.String("config"), c.Uint64("total-active-balance")*beacon.EffectiveBalanceIncrement)
+				},
+			},
+			{
+				Name:        "plan",
+				Usage:       "Plan an operation for approval",
+				Description: "Validate and simulate the configured operation, snapshot the chain and beacon state it depends on (nonce, fees, delegation, contract code and validator states) and write a plan file with the exact transaction and the expected effect on each validator. No key is needed and nothing is sent. The plan is executed with apply.",
+				ArgsUsage:   "<switch|consolidate|el-exit>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Withdrawal address sending the planned transaction, prompted for if not set",
+					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "Path of the plan file",
+						Value:   "plan.json",
+					},
+					gasHeadroomFlag,
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the operation to plan: switch, consolidate or el-exit"))
+					}
+					command := c.Args().First()
+					if _, ok := journalOperations[command]; !ok {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("cannot plan %s, expected switch, consolidate or el-exit", command))
+					}
+					opts := newRunOptions(c)
+					opts.Plan = &planOptions{
+						FileName:           c.String("out"),
+						GasHeadroomPercent: c.Uint64("gas-headroom"),
+					}
+					return runCommand(command, opts)
+				},
+			},
+			{
+				Name:        "apply",
+				Usage:       "Execute a plan written by the plan command",
+				Description: "Check that the chain and beacon state still match the plan within its tolerances, then sign and send exactly the planned transaction, or write it to unsigned_txn.json in airgapped mode. Nothing is read from the operation sections of the config, only the network settings are used.",
+				ArgsUsage:   "<plan file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:    "airgapped",
+						Aliases: []string{"a"},
+						Usage:   "Write the planned transaction for offline signing instead of sending it",
+					},
+					&cli.StringFlag{
+						Name:     "hash",
+						Usage:    "Approved SHA-256 hash of the plan file, apply refuses a plan with a different hash (required)",
+						Required: true,
+					},
+					gasHeadroomFlag,
+					&cli.Uint64Flag{
+						Name:  "balance-tolerance",
+						Usage: "How far (in Gwei) validator balances may move from the plan before apply refuses",
+						Value: 100_000_000,
+					},
+					&cli.DurationFlag{
+						Name:  "valid-for",
+						Usage: "How long after it was created the plan can be applied",
+						Value: 24 * time.Hour,
+					},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the plan file to apply"))
+					}
+					tolerances := plan.Tolerances{
+						GasHeadroomPercent: c.Uint64("gas-headroom"),
+						BalanceGwei:        c.Uint64("balance-tolerance"),
+						ValidFor:           c.Duration("valid-for"),
+					}
+					return applyPlan(c.Args().First(), c.String("hash"), tolerances, newRunOptions(c))
+				},
+			},
+			{
+				Name:        "run-workflow",
+				Usage:       "Run a multi-step workflow of operations",
+				Description: "Run the steps of a workflow file in order, waiting before each step until its conditions hold on the beacon chain. The network settings come from the config, the validators of each step from the workflow. Completed steps are recorded in a progress file, so an interrupted workflow resumes where it stopped when run again.",
+				ArgsUsage:   "<workflow file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "progress",
+						Usage: "Path of the progress file (default: <workflow>.progress.json)",
+					},
+					&cli.DurationFlag{
+						Name:  "poll-interval",
+						Usage: "Interval between checks of the beacon state while waiting for conditions",
+						Value: time.Minute,
+					},
+					confirmMainnetFlag,
+					skipCodeVerificationFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the workflow file to run"))
+					}
+					return runWorkflow(c.Args().First(), c.String("progress"), c.Duration("poll-interval"), newRunOptions(c))
+				},
+			},
+			{
+				Name:        "safe",
+				Usage:       "Write an operation as a Safe Transaction Builder batch",
+				Description: "Validators whose withdrawal address is a Safe cannot use EIP-7702. Convert the configured operation into a Safe Transaction Builder file making one call per request directly to the EIP-7002 or EIP-7251 system contract with the current request fee, and print the hash of the Safe transaction executing the batch so co-signers can verify what they sign. No key is needed and nothing is sent.",
+				ArgsUsage:   "<switch|consolidate|el-exit>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "safe",
+						Usage:    "Address of the Safe, the withdrawal address of the validators (required)",
+						Required: true,
+					},
+					&cli.Uint64Flag{
+						Name:  "nonce",
+						Usage: "Nonce of the Safe transaction (default: the current nonce of the Safe)",
+					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "Path of the Transaction Builder file",
+						Value:   "safe_batch.json",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the operation: switch, consolidate or el-exit"))
+					}
+					opts := safeOptions{
+						ConfigPath: c.String("config"),
+						Safe:       c.String("safe"),
+						FileName:   c.String("out"),
+					}
+					if c.IsSet("nonce") {
+						nonce := c.Uint64("nonce")
+						opts.Nonce = &nonce
+					}
+					return safeBatch(c.Args().First(), opts)
+				},
+			},
+			{
+				Name:        "safe-hash",
+				Usage:       "Compute the Safe transaction hash of a Transaction Builder file",
+				Description: "Compute offline the hash of the Safe transaction executing the batch of a Transaction Builder file, for co-signers to compare with the hash shown by the Safe app and their wallet before signing. A single call is made directly, several calls through the MultiSendCallOnly contract of the Safe version. The chain and the Safe are read from the file.",
+				ArgsUsage:   "<Transaction Builder file>",
+				Flags: []cli.Flag{
+					&cli.Uint64Flag{
+						Name:     "nonce",
+						Usage:    "Nonce of the Safe transaction (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "safe-version",
+						Usage:    "Version of the Safe, 1.3.0 or 1.4.1 (required)",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the Transaction Builder file"))
+					}
+					return safeHash(c.Args().First(), c.Uint64("nonce"), c.String("safe-version"))
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Check a config file",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "check",
+						Usage:       "Find conflicting requests across the operations of a config",
+						Description: "Find contradictory or pointless combinations across the switch, consolidate and elExit sections, such as a validator that is both a consolidation source and a full exit, and requests that conflict with the beacon state, such as a validator that is already exiting. Conflicts are errors, which every operation refuses, or warnings. The same checks run before every operation for its own validators.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "config",
+								Aliases:  []string{"c"},
+								Usage:    "Path to config file (required)",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "offline",
+								Usage: "Only check the config, without the beacon state",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							output.SetCommand("config check")
+							return checkConfig(c.String("config"), c.Bool("offline"))
+						},
+					},
+					{
+						Name:        "validate",
+						Usage:       "Validate a config file against the config schema",
+						Description: "Decode the config strictly and report every problem with its JSON path, line and column: syntax errors, unknown or misspelled fields, duplicate keys, values of the wrong type and invalid public keys, then check the network settings. Every command loads the config the same way and refuses an invalid one.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "config",
+								Aliases:  []string{"c"},
+								Usage:    "Path to config file (required)",
+								Required: true,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							output.SetCommand("config validate")
+							return validateConfig(c.String("config"))
+						},
+					},
+					{
+						Name:        "schema",
+						Usage:       "Print the JSON Schema of the config file",
+						Description: "Print the JSON Schema the config is validated against, for editors and other tools. The same schema is published as internal/config/config.schema.json.",
+						Action: func(c *cli.Context) error {
+							output.SetCommand("config schema")
+							// In JSON mode the schema is the data of the single result document
+							if output.JSON() {
+								output.SetData(json.RawMessage(config.Schema()))
+								return nil
+							}
+							_, err := os.Stdout.Write(config.Schema())
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:        "cost",
+				Usage:       "Compute the total cost of an operation",
+				Description: "Read the request fee, gas prices and the gas estimate of the batch call from the RPC endpoints and print the total cost of the configured operation, including the worst case at the max fee and a comparison with sending each request directly to the system contracts. No key is needed and nothing is sent.",
+				ArgsUsage:   "<switch|consolidate|el-exit>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Aliases:  []string{"c"},
+						Usage:    "Path to config file (required)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Address of the EOA sending the requests, prompted for if not set",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format, table or json",
+						Value: cost.FormatTable,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return output.WithCode(output.CodeUsage, fmt.Errorf("expected the operation to price: switch, consolidate or el-exit"))
+					}
+					return costOperation(c.Args().First(), c.String("config"), c.String("from"), c.String("format"))
+				},
+			},
+		},
+		// Use the custom help template from utils.PrintUsage when showing app help
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			utils.PrintUsage()
+			return output.WithCode(output.CodeUsage, err)
+		},
+		CommandNotFound: func(c *cli.Context, command string) {
+			color.Red("Unknown command: %s", command)
+			utils.PrintUsage()
+			// The help action reporting unknown commands returns no error, so it is recorded here
+			unknownCommand = output.WithCode(output.CodeUsage, fmt.Errorf("unknown command %s", command))
+		},
+	}
+	acceptFlags(app.Commands)
+
+	err := app.Run(os.Args)
+	if err == nil {
+		err = unknownCommand
+	}
+	// Errors returned before the flags of a command were accepted, such as a missing required flag,
+	// are usage errors
+	if err != nil && !flagsAccepted {
+		err = output.WithCode(output.CodeUsage, err)
+	}
+	if output.JSON() {
+		if emitErr := output.Emit(err); emitErr != nil {
+			log.Fatal(emitErr)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var (
+	// unknownCommand is the error of a command that does not exist
+	unknownCommand error
+	// flagsAccepted is set once the flags of the command that runs were parsed and checked
+	flagsAccepted bool
+)
+
+// acceptFlags sets flagsAccepted before the commands run, the commands having subcommands are
+// left to their subcommands
+func acceptFlags(commands []*cli.Command) {
+	for _, command := range commands {
+		if len(command.Subcommands) > 0 {
+			acceptFlags(command.Subcommands)
+			continue
+		}
+		command.Before = func(c *cli.Context) error {
+			flagsAccepted = true
+			return nil
+		}
+	}
+}
+
+func runCommand(command string, opts runOptions) error {
+	// Offline mode never touches the network, it only prepares unsigned transactions
+	airgapped := opts.Airgapped || opts.Offline
+	color.Green("Airgapped: %v", airgapped)
+
+	// Load configuration
+	cfg, err := opts.config()
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	contractAddress := common.HexToAddress(cfg.PectraBatchContract)
+
+	var client rpc.Client
+	var snapshot *transaction.NetworkSnapshot
+	var chainID *big.Int
+
+	if opts.Offline {
+		snapshot, err = opts.offlineSnapshot()
+		if err != nil {
+			color.Red("Invalid offline parameters: %v", err)
+			return err
+		}
+		color.Yellow("Offline mode: using the supplied chain data, no RPC endpoint is contacted")
+
+		if err := cfg.VerifyChainID(snapshot.ChainID, opts.ConfirmMainnet); err != nil {
+			color.Red("%v", err)
+			return output.WithCode(output.CodeChainMismatch, err)
+		}
+		color.Green("Network: %s (chain ID %s)", cfg.Profile.Name, snapshot.ChainID)
+		chainID = snapshot.ChainID
+
+		// The code hash recorded in the snapshot stands in for the code fetched from the RPC endpoint
+		if command != "unset-code" {
+			if opts.SkipCodeVerification {
+				color.Red("Skipping verification of the batch contract code at %s", contractAddress.Hex())
+			} else if err := verifySnapshotContract(snapshot, contractAddress); err != nil {
+				color.Red("Refusing to delegate to the batch contract: %v", err)
+				return output.WithCode(output.CodeValidation, err)
+			}
+		}
+	} else {
+		// Connect to Ethereum client
+		client, err = rpc.Dial(cfg)
+		if err != nil {
+			color.Red("Failed to connect to the Ethereum client: %v", err)
+			return output.WithCode(output.CodeRPC, err)
+		}
+		color.Green("Connected to the Ethereum client")
+
+		// Make sure the RPC endpoint belongs to the selected network before doing anything
+		chainID, err = client.ChainID(context.Background())
+		if err != nil {
+			color.Red("Failed to get the chain ID: %v", err)
+			return err
+		}
+		if err := cfg.VerifyChainID(chainID, opts.ConfirmMainnet); err != nil {
+			color.Red("%v", err)
+			return output.WithCode(output.CodeChainMismatch, err)
+		}
+		color.Green("Network: %s (chain ID %s)", cfg.Profile.Name, chainID)
+
+		// Never delegate the EOA to code that is not an audited batch contract
+		if command != "unset-code" {
+			if opts.SkipCodeVerification {
+				color.Red("Skipping verification of the batch contract code at %s", contractAddress.Hex())
+			} else if err := utils.VerifyContractCode(client, contractAddress); err != nil {
+				color.Red("Refusing to delegate to the batch contract: %v", err)
+				return output.WithCode(output.CodeValidation, err)
+			}
+		}
+	}
+
+	output.SetOperation(command)
+	output.SetChain(chainID.Uint64())
+
+	if opts.SkipConfirmed {
+		remaining, err := skipConfirmedValidators(cfg, command, chainID.Uint64(), opts.Journal)
+		if err != nil {
+			color.Red("Failed to read the journal: %v", err)
+			return err
+		}
+		if remaining == 0 {
+			color.Green("All validators of the %s operation are already confirmed in the journal", command)
+			return nil
+		}
+	}
+
+	if command != "unset-code" {
+		var beaconClient *beacon.Client
+		if snapshot == nil && cfg.BeaconUrl != "" {
+			beaconClient = beacon.NewClient(cfg.BeaconUrl)
+		}
+		if err := checkConflicts(cfg, command, beaconClient); err != nil {
+			return err
+		}
+	}
+
+	// Dry runs and plans never ask for a key, they only need the address of the EOA
+	var from common.Address
+	if opts.keyless() {
+		if opts.Plan != nil && cfg.BeaconUrl == "" {
+			color.Red("beaconUrl must be set in the config to plan an operation")
+			return output.WithCode(output.CodeConfig, fmt.Errorf("beaconUrl is not set"))
+		}
+		if from, err = senderAddress(opts, snapshot); err != nil {
+			color.Red("%v", err)
+			return output.WithCode(output.CodeUsage, err)
+		}
+		if snapshot != nil {
+			snapshot.Address = from
+		}
+	}
+
+	privateKey := opts.PrivateKey
+	if privateKey == nil && !airgapped && !opts.keyless() {
+		// Get private key securely
+		privateKey, err = config.GetPrivateKey()
+		if err != nil {
+			color.Red("Failed to get the private key: %v", err)
+			return output.WithCode(output.CodeKey, err)
+		}
+	}
+
+	var sponsorKey *ecdsa.PrivateKey
+	if opts.Sponsor && !airgapped && !opts.keyless() {
+		sponsorKey, err = config.GetSponsorPrivateKey()
+		if err != nil {
+			color.Red("Failed to get the sponsor private key: %v", err)
+			return output.WithCode(output.CodeKey, err)
+		}
+	}
+
+	// Load the ABI
+	parsedAbi, err := config.LoadABI()
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	// Create base operation
+	baseOp := operations.BaseOperation{
+		Client:          client,
+		ContractAddress: contractAddress,
+		ABI:             parsedAbi,
+		ExplorerUrl:     cfg.BlockExplorerUrl,
+		Airgapped:       airgapped,
+		Journal:         opts.Journal,
+		OutputDir:       opts.OutputDir,
+	}
+
+	if !airgapped {
+		baseOp.PrivateKey = privateKey
+	}
+
+	if common.IsHexAddress(opts.From) {
+		baseOp.From = common.HexToAddress(opts.From)
+	}
+
+	if opts.Sponsor {
+		baseOp.Sponsored = true
+		baseOp.SponsorKey = sponsorKey
+	}
+
+	if opts.AuthorizationOnly {
+		if !airgapped {
+			color.Red("--authorization-only can only be used in airgapped mode")
+			return fmt.Errorf("--authorization-only requires --airgapped")
+		}
+		baseOp.AuthorizationOnly = true
+	}
+
+	if snapshot != nil {
+		baseOp.Offline = snapshot
+	} else if cfg.BeaconUrl != "" {
+		baseOp.Beacon = beacon.NewClient(cfg.BeaconUrl)
+	}
+
+	var op operations.Operation
+
+	// Helper function to get fee for a contract
+	getFeeForContract := func(functionName string) (int64, error) {
+		var fee *big.Int
+		if snapshot != nil {
+			fee, err = snapshot.RequestFee(functionName)
+		} else {
+			fee, err = pectra.GetFee(context.Background(), client, parsedAbi, contractAddress, functionName)
+		}
+		if err != nil {
+			return 0, err
+		}
+		color.Green("Fee Amount per Validator: %v wei", fee)
+		return fee.Int64(), nil
+	}
+
+	switch command {
+	case "switch":
+		feeAmount, err := getFeeForContract(pectra.ConsolidationFeeFunction)
+		if err != nil {
+			color.Red("Failed to get the fee: %v", err)
+			return err
+		}
+
+		op = &operations.SwitchOperation{
+			BaseOperation:      baseOp,
+			Validators:         cfg.Switch.Validators,
+			AmountPerValidator: big.NewInt(feeAmount),
+		}
+
+	case "consolidate":
+		feeAmount, err := getFeeForContract(pectra.ConsolidationFeeFunction)
+		if err != nil {
+			color.Red("Failed to get the fee: %v", err)
+			return err
+		}
+
+		op = &operations.ConsolidateOperation{
+			BaseOperation:      baseOp,
+			SourceValidators:   cfg.Consolidate.SourceValidators,
+			TargetValidator:    cfg.Consolidate.TargetValidator,
+			AmountPerValidator: big.NewInt(feeAmount),
+		}
+
+	case "el-exit":
+		feeAmount, err := getFeeForContract(pectra.ExitFeeFunction)
+		if err != nil {
+			color.Red("Failed to get the fee: %v", err)
+			return err
+		}
+
+		op = &operations.ELExitOperation{
+			BaseOperation:      baseOp,
+			Validators:         cfg.ELExit.Validators,
+			AmountPerValidator: big.NewInt(feeAmount),
+		}
+
+	case "unset-code":
+		if !airgapped && privateKey == nil {
+			color.Red("Private key is required for unset-code operation in non-airgapped mode")
+			return fmt.Errorf("private key required")
+		}
+		// Delegating to the zero address resets the code of the EOA
+		baseOp.ContractAddress = common.Address{}
+		err = baseOp.Send(nil, nil)
+		if err != nil {
+			color.Red("Failed to execute unset-code: %v", err)
+			return err
+		}
+		return nil
+
+	default:
+		color.Red("Unknown command: %s", command)
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	if opts.DryRun {
+		if err := operations.DryRun(op, from); err != nil {
+			color.Red("Dry run failed: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	if opts.Plan != nil {
+		if err := createPlan(op, command, cfg.Profile.Name, from, baseOp.Beacon, *opts.Plan); err != nil {
+			color.Red("Planning failed: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	// Execute the operation
+	if err := operations.Execute(op); err != nil {
+		color.Red("Operation failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// senderAddress returns the EOA of a dry run or a plan, the snapshot address in offline mode, which
+// --from must match
+func senderAddress(opts runOptions, snapshot *transaction.NetworkSnapshot) (common.Address, error) {
+	if snapshot != nil && snapshot.Address != (common.Address{}) {
+		return snapshot.Address, nil
+	}
+
+	from := opts.From
+	if from == "" {
+		var err error
+		if from, err = config.GetPublicKey(); err != nil {
+			return common.Address{}, fmt.Errorf("failed to get the public key: %w", err)
+		}
+	}
+	if !common.IsHexAddress(from) {
+		return common.Address{}, fmt.Errorf("invalid EOA address %s", from)
+	}
+	return common.HexToAddress(from), nil
+}
+
+// createPlan plans the operation and writes the plan file for approval
+func createPlan(op operations.Operation, command, network string, from common.Address, beaconClient *beacon.Client, opts planOptions) error {
+	p, err := plan.Create(op, command, network, from, beaconClient, opts.GasHeadroomPercent)
+	if err != nil {
+		return err
+	}
+	p.Print()
+	output.SetFrom(from)
+	output.SetData(p)
+
+	hash, err := plan.Write(p, opts.FileName)
+	if err != nil {
+		return err
+	}
+	color.Cyan("Plan hash: %s", hash)
+	color.Cyan("Review the plan, then run: pectra-cli apply -c <config> --hash %s %s", hash, opts.FileName)
+	return nil
+}
+
+// applyPlan checks a plan against the current state and sends exactly the planned transaction, or
+// writes it unsigned in airgapped mode. Nothing is signed or written unless the plan has the
+// approved hash.
+func applyPlan(planPath, expectedHash string, tolerances plan.Tolerances, opts runOptions) error {
+	if strings.TrimSpace(expectedHash) == "" {
+		err := fmt.Errorf("the approved plan hash is required")
+		color.Red("%v", err)
+		return output.WithCode(output.CodeUsage, err)
+	}
+
+	p, hash, err := plan.Read(planPath)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	color.Cyan("Plan hash: %s", hash)
+	if !strings.EqualFold(strings.TrimPrefix(expectedHash, "0x"), hash) {
+		err := fmt.Errorf("the plan hash %s does not match the approved hash %s", hash, expectedHash)
+		color.Red("%v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	if cfg.BeaconUrl == "" {
+		color.Red("beaconUrl must be set in the config to apply a plan")
+		return output.WithCode(output.CodeConfig, fmt.Errorf("beaconUrl is not set"))
+	}
+	if p.Network != cfg.Profile.Name {
+		err := fmt.Errorf("the plan is for the %s network, the config is for %s", p.Network, cfg.Profile.Name)
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		color.Red("Failed to get the chain ID: %v", err)
+		return err
+	}
+	if err := cfg.VerifyChainID(chainID, opts.ConfirmMainnet); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	if opts.SkipCodeVerification {
+		color.Red("Skipping verification of the batch contract code at %s", p.Transaction.Contract.Hex())
+	} else if err := utils.VerifyContractCode(client, p.Transaction.Contract); err != nil {
+		color.Red("Refusing to delegate to the batch contract: %v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+
+	output.SetOperation(p.Operation)
+	output.SetChain(chainID.Uint64())
+	output.SetFrom(p.Transaction.From)
+	p.Print()
+
+	parsedAbi, err := config.LoadABI()
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	if err := p.Verify(client, parsedAbi, beacon.NewClient(cfg.BeaconUrl), time.Now(), tolerances); err != nil {
+		color.Red("Refusing to apply the plan: %v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+
+	if opts.Airgapped {
+		return transaction.WritePlannedTransaction(&p.Transaction, opts.transactionOptions(cfg))
+	}
+
+	privateKey, err := config.GetPrivateKey()
+	if err != nil {
+		color.Red("Failed to get the private key: %v", err)
+		return output.WithCode(output.CodeKey, err)
+	}
+	return transaction.SendPlannedTransaction(client, privateKey, &p.Transaction, opts.transactionOptions(cfg))
+}
+
+// runWorkflow runs the steps of a workflow file that are not completed yet, with a single prompt
+// for the private key
+func runWorkflow(workflowPath, progressPath string, interval time.Duration, opts runOptions) error {
+	w, err := workflow.Load(workflowPath)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	if progressPath == "" {
+		progressPath = workflow.ProgressPath(workflowPath)
+	}
+	progress, err := workflow.LoadProgress(progressPath)
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	output.SetData(progress)
+	if progress.Done(w) {
+		color.Green("All steps of the workflow were already completed, see %s", progressPath)
+		return nil
+	}
+
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	var beaconClient *beacon.Client
+	if w.NeedsBeacon() {
+		if cfg.BeaconUrl == "" {
+			color.Red("beaconUrl must be set in the config to wait for workflow conditions")
+			return output.WithCode(output.CodeConfig, fmt.Errorf("beaconUrl is not set"))
+		}
+		beaconClient = beacon.NewClient(cfg.BeaconUrl)
+	}
+
+	privateKey, err := config.GetPrivateKey()
+	if err != nil {
+		color.Red("Failed to get the private key: %v", err)
+		return output.WithCode(output.CodeKey, err)
+	}
+
+	output.AddFile(progressPath)
+	return w.Run(beaconClient, progress, interval, func(step workflow.Step, run string, resuming bool) error {
+		stepOpts := opts
+		stepOpts.Config = step.Config(cfg)
+		stepOpts.PrivateKey = privateKey
+		stepOpts.Journal = opts.Journal.WithRun(run)
+		// A step interrupted after its transaction was sent only runs for the requests its run did not
+		// confirm yet
+		stepOpts.SkipConfirmed = resuming && step.Operation != workflow.OperationUnsetCode
+		return runCommand(step.Operation, stepOpts)
+	})
+}
+
+// runOperation runs the operation, once per withdrawal address if the validators are grouped
+func runOperation(command string, opts runOptions) error {
+	if opts.GroupByWithdrawalAddress {
+		return runGrouped(command, opts)
+	}
+	return runCommand(command, opts)
+}
+
+// runGrouped runs the operation once for the validators of each withdrawal address, asking for the
+// key of each address in turn, or writing the files of each address to a directory named after it
+// in airgapped mode
+func runGrouped(command string, opts runOptions) error {
+	if opts.Offline {
+		return output.WithCode(output.CodeUsage, fmt.Errorf("--group-by-withdrawal-address needs the beacon state and cannot be used offline"))
+	}
+
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	if cfg.BeaconUrl == "" {
+		color.Red("beaconUrl must be set in the config to group validators by withdrawal address")
+		return output.WithCode(output.CodeConfig, fmt.Errorf("beaconUrl is not set"))
+	}
+
+	groups, err := group.ByWithdrawalAddress(cfg, command, beacon.NewClient(cfg.BeaconUrl))
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+	group.Print(groups)
+
+	airgapped := opts.Airgapped || opts.AuthorizationOnly
+	keyed := !airgapped && !opts.keyless()
+
+	var results []group.Result
+	failed := 0
+	for i, g := range groups {
+		color.Cyan("Withdrawal address %d/%d: %s (%d validator(s))", i+1, len(groups), g.Address.Hex(), len(g.Validators))
+		result := group.Result{Address: g.Address, Validators: len(g.Validators), Status: group.StatusDone}
+
+		groupOpts := opts
+		groupOpts.Config = g.Config
+		groupOpts.From = g.Address.Hex()
+		err := func() error {
+			if keyed {
+				privateKey, err := config.GetPrivateKey()
+				if err != nil {
+					result.Status = group.StatusSkipped
+					return err
+				}
+				if address := crypto.PubkeyToAddress(privateKey.PublicKey); address != g.Address {
+					return fmt.Errorf("the private key belongs to %s, not to %s", address.Hex(), g.Address.Hex())
+				}
+				groupOpts.PrivateKey = privateKey
+			}
+			if airgapped {
+				// Each address gets its own directory, so the files of one address do not overwrite another's
+				if err := os.MkdirAll(g.Address.Hex(), 0755); err != nil {
+					return fmt.Errorf("failed to create the output directory: %w", err)
+				}
+				groupOpts.OutputDir = g.Address.Hex()
+			}
+			return runCommand(command, groupOpts)
+		}()
+		if err != nil {
+			if result.Status == group.StatusDone {
+				result.Status = group.StatusFailed
+			}
+			result.Error = err.Error()
+			failed++
+			color.Red("%s: %v", g.Address.Hex(), err)
+		}
+		results = append(results, result)
+	}
+
+	group.PrintResults(results)
+	output.SetData(results)
+	if failed > 0 {
+		return fmt.Errorf("the operation did not complete for %d of %d withdrawal address(es)", failed, len(groups))
+	}
+	return nil
+}
+
+// journalOperations maps the commands to the batch contract methods recorded in the journal
+var journalOperations = map[string]string{
+	"switch":      pectra.MethodSwitch,
+	"consolidate": pectra.MethodConsolidation,
+	"el-exit":     pectra.MethodELExit,
+}
+
+// skipConfirmedValidators removes the validators whose requests are confirmed in the run of the
+// journal from the configuration and returns the number of validators left. A withdrawal is only
+// skipped if the confirmed request withdrew the configured amount.
+func skipConfirmedValidators(cfg *config.Config, command string, chainID uint64, j *journal.Journal) (int, error) {
+	operation, ok := journalOperations[command]
+	if !ok {
+		return 0, fmt.Errorf("the %s command cannot be resumed", command)
+	}
+
+	target := ""
+	if command == "consolidate" {
+		target = cfg.Consolidate.TargetValidator
+	}
+
+	confirmed, err := j.Confirmed(chainID, j.Run(), operation, target)
+	if err != nil {
+		return 0, err
+	}
+
+	keep := func(validator string) bool {
+		request, done := journal.IsConfirmed(confirmed, validator)
+		if done && command == "el-exit" && request.AmountGwei != uint64(cfg.ELExit.Validators[validator].Amount) {
+			done = false
+		}
+		if done {
+			color.Yellow("Skipping %s, already confirmed in transaction %s", validator, request.TxHash.Hex())
+		}
+		return !done
+	}
+
+	switch command {
+	case "switch":
+		cfg.Switch.Validators = filterValidators(cfg.Switch.Validators, keep)
+		return len(cfg.Switch.Validators), nil
+	case "consolidate":
+		cfg.Consolidate.SourceValidators = filterValidators(cfg.Consolidate.SourceValidators, keep)
+		return len(cfg.Consolidate.SourceValidators), nil
+	default:
+		for validator := range cfg.ELExit.Validators {
+			if !keep(validator) {
+				delete(cfg.ELExit.Validators, validator)
+			}
+		}
+		return len(cfg.ELExit.Validators), nil
+	}
+}
+
+// filterValidators returns the validators for which keep returns true
+func filterValidators(validators []string, keep func(string) bool) []string {
+	var kept []string
+	for _, validator := range validators {
+		if keep(validator) {
+			kept = append(kept, validator)
+		}
+	}
+	return kept
+}
+
+// resume reattaches to the pending transactions of the journal and then runs the operation again,
+// if one is given, for the validators that are not confirmed yet
+func resume(command string, opts runOptions) error {
+	if command != "" {
+		if _, ok := journalOperations[command]; !ok {
+			return fmt.Errorf("cannot resume %s, expected switch, consolidate or el-exit", command)
+		}
+	}
+
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	color.Green("Connected to the Ethereum client")
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		color.Red("Failed to get the chain ID: %v", err)
+		return err
+	}
+	// Pending transactions were already acknowledged when they were signed
+	if err := cfg.VerifyChainID(chainID, true); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	// New transactions would conflict with the nonces of the pending ones
+	if err := transaction.ResumePending(client, chainID.Uint64(), opts.transactionOptions(cfg)); err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	if command == "" {
+		return nil
+	}
+
+	// The run continues the latest run of the operation, so that only its confirmed requests are skipped
+	run, err := opts.Journal.LatestRun(chainID.Uint64(), journalOperations[command])
+	if err != nil {
+		color.Red("Failed to read the journal: %v", err)
+		return err
+	}
+	if run != "" {
+		color.Cyan("Resuming the run started at %s", run)
+		opts.Journal = opts.Journal.WithRun(run)
+	}
+
+	opts.SkipConfirmed = true
+	return runCommand(command, opts)
+}
+
+// trackTransaction follows a sent transaction until it is mined
+func trackTransaction(configPath string, hash common.Hash, j *journal.Journal) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	color.Green("Connected to the Ethereum client")
+
+	if err := transaction.TrackHash(client, hash, transaction.Options{ExplorerURL: cfg.BlockExplorerUrl, Journal: j}); err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	return nil
+}
+
+// estimateOperation prints when each request of the configured operation would be processed
+func estimateOperation(command, configPath string, totalActiveBalance uint64) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	if cfg.BeaconUrl == "" {
+		color.Red("beaconUrl must be set in the configuration to estimate processing times")
+		return output.WithCode(output.CodeConfig, fmt.Errorf("beaconUrl is not set"))
+	}
+
+	requests, err := estimateRequests(cfg, command)
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	// The system contract backlog is only known with an RPC endpoint
+	var client rpc.Client
+	if pool, err := rpc.Dial(cfg); err != nil {
+		color.Yellow("Assuming empty system contract queues: %v", err)
+	} else {
+		client = pool
+	}
+
+	beaconClient := beacon.NewClient(cfg.BeaconUrl)
+	if err := checkConflicts(cfg, command, beaconClient); err != nil {
+		return err
+	}
+	state, err := estimate.LoadState(beaconClient, client, totalActiveBalance)
+	if err != nil {
+		color.Red("Failed to read the queues: %v", err)
+		return output.WithCode(output.CodeBeacon, err)
+	}
+
+	pubkeys := make([]string, 0, len(requests))
+	for _, request := range requests {
+		pubkeys = append(pubkeys, request.Pubkey)
+	}
+	found, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeBeacon, err)
+	}
+	validators := make(map[string]*beacon.Validator, len(found))
+	for i := range found {
+		validators[beacon.NormalizePubkey(found[i].Validator.Pubkey)] = &found[i]
+	}
+
+	estimates := state.Estimate(requests, validators)
+	output.SetOperation(command)
+	output.SetData(estimates)
+	state.Print(estimates)
+	return nil
+}
+
+// estimateRequests returns the requests of the configured operation, in the order they are sent
+func estimateRequests(cfg *config.Config, command string) ([]estimate.Request, error) {
+	call, err := configCall(cfg, command)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]estimate.Request, 0, len(call.Requests))
+	for _, request := range call.Requests {
+		requests = append(requests, estimate.Request{
+			Kind:     request.Kind,
+			Pubkey:   request.SourcePubkey,
+			Amount:   request.Amount,
+			FullExit: request.FullExit,
+		})
+	}
+	return requests, nil
+}
+
+// configCall returns the batch contract call of the configured operation, without sending it
+func configCall(cfg *config.Config, command string) (*calldata.Call, error) {
+	method, ok := journalOperations[command]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %s, expected switch, consolidate or el-exit", command)
+	}
+
+	call := &calldata.Call{Method: method}
+	switch command {
+	case "switch":
+		for _, validator := range cfg.Switch.Validators {
+			call.Requests = append(call.Requests, calldata.Request{Kind: calldata.KindSwitch, SourcePubkey: validator, TargetPubkey: validator})
+		}
+	case "consolidate":
+		for _, validator := range cfg.Consolidate.SourceValidators {
+			call.Requests = append(call.Requests, calldata.Request{Kind: calldata.KindConsolidation, SourcePubkey: validator, TargetPubkey: cfg.Consolidate.TargetValidator})
+		}
+	case "el-exit":
+		validators := make([]string, 0, len(cfg.ELExit.Validators))
+		for validator := range cfg.ELExit.Validators {
+			validators = append(validators, validator)
+		}
+		sort.Strings(validators)
+		for _, validator := range validators {
+			amount := uint64(cfg.ELExit.Validators[validator].Amount)
+			call.Requests = append(call.Requests, calldata.Request{Kind: calldata.KindWithdrawal, SourcePubkey: validator, Amount: amount, FullExit: amount == 0})
+		}
+	}
+
+	if len(call.Requests) == 0 {
+		return nil, fmt.Errorf("no validators configured for %s", command)
+	}
+	return call, nil
+}
+
+// costOperation prints the total cost of the configured operation. No key is needed, the EOA is
+// only used to simulate the delegated call.
+func costOperation(command, configPath, from, format string) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	call, err := configCall(cfg, command)
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	var beaconClient *beacon.Client
+	if cfg.BeaconUrl != "" {
+		beaconClient = beacon.NewClient(cfg.BeaconUrl)
+	}
+	if err := checkConflicts(cfg, command, beaconClient); err != nil {
+		return err
+	}
+
+	if from == "" {
+		if from, err = config.GetPublicKey(); err != nil {
+			color.Red("Failed to get the public key: %v", err)
+			return err
+		}
+	}
+	if !common.IsHexAddress(from) {
+		return fmt.Errorf("invalid EOA address %s", from)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		color.Red("Failed to get the chain ID: %v", err)
+		return err
+	}
+	// Nothing is sent, so mainnet needs no confirmation
+	if err := cfg.VerifyChainID(chainID, true); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	parsedAbi, err := config.LoadABI()
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	estimator := cost.Estimator{
+		Client:          client,
+		ABI:             parsedAbi,
+		ContractAddress: common.HexToAddress(cfg.PectraBatchContract),
+		From:            common.HexToAddress(from),
+	}
+	report, err := estimator.Estimate(call)
+	if err != nil {
+		color.Red("Failed to estimate the cost: %v", err)
+		return err
+	}
+	output.SetOperation(command)
+	output.SetChain(report.ChainID)
+	output.SetFrom(report.From)
+	output.SetData(report)
+	if output.JSON() {
+		// The report is the data of the JSON result
+		return nil
+	}
+	return cost.Print(report, format)
+}
+
+// checkConflicts refuses an operation contradicting another section of the config or the beacon
+// state, and warns about pointless combinations. The beacon state is skipped without a client.
+func checkConflicts(cfg *config.Config, command string, beaconClient *beacon.Client) error {
+	conflicts := conflict.Analyze(cfg, command)
+	if beaconClient != nil {
+		beaconConflicts, err := conflict.AnalyzeBeacon(cfg, command, beaconClient)
+		if err != nil {
+			color.Red("Failed to check the requests against the beacon state: %v", err)
+			return output.WithCode(output.CodeBeacon, err)
+		}
+		conflicts = append(conflicts, beaconConflicts...)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	color.Yellow("Conflicting requests in the configuration:")
+	conflict.Print(conflicts)
+	if refused := conflict.Errors(conflicts); refused > 0 {
+		err := fmt.Errorf("%d conflicting request(s), run `pectra-cli config check` for all sections", refused)
+		color.Red("%v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+	return nil
+}
+
+// validateConfig reports every problem of a config file with its JSON path, line and column
+func validateConfig(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		color.Red("Failed to read the config file: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	problems := config.ValidateSchema(data)
+	if problems == nil {
+		problems = []*config.SchemaError{}
+	}
+	output.SetData(problems)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			color.Red("  %s:%v", configPath, problem)
+		}
+		err := fmt.Errorf("%d problem(s) in %s", len(problems), configPath)
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	// The network settings are checked once the file matches the schema
+	if _, err := config.LoadConfig(configPath); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	color.Green("%s is a valid configuration", configPath)
+	return nil
+}
+
+// checkConfig reports the conflicts between all sections of the config, and against the beacon
+// state unless offline
+func checkConfig(configPath string, offline bool) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	conflicts := conflict.Analyze(cfg, "")
+	switch {
+	case offline:
+		color.Yellow("Offline: the requests are not checked against the beacon state")
+	case cfg.BeaconUrl == "":
+		color.Yellow("No beacon node available (beaconUrl not set), the requests are not checked against the beacon state")
+	default:
+		beaconConflicts, err := conflict.AnalyzeBeacon(cfg, "", beacon.NewClient(cfg.BeaconUrl))
+		if err != nil {
+			color.Red("Failed to check the requests against the beacon state: %v", err)
+			return output.WithCode(output.CodeBeacon, err)
+		}
+		conflicts = append(conflicts, beaconConflicts...)
+	}
+	output.SetData(conflicts)
+
+	if len(conflicts) == 0 {
+		color.Green("No conflicting requests in %s", configPath)
+		return nil
+	}
+	conflict.Print(conflicts)
+	refused := conflict.Errors(conflicts)
+	if refused > 0 {
+		err := fmt.Errorf("%d conflicting request(s) and %d warning(s)", refused, len(conflicts)-refused)
+		color.Red("%v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+	color.Yellow("%d warning(s), no conflicting requests", len(conflicts))
+	return nil
+}
+
+// safeOptions holds the options of the safe command
+type safeOptions struct {
+	ConfigPath string
+	Safe       string
+	Nonce      *uint64
+	FileName   string
+}
+
+// safeReport is the result of the safe command
+type safeReport struct {
+	File             string            `json:"file"`
+	Calls            int               `json:"calls"`
+	WithdrawalFee    *big.Int          `json:"withdrawalFeeWei"`
+	ConsolidationFee *big.Int          `json:"consolidationFeeWei"`
+	Transaction      *safe.Transaction `json:"transaction"`
+}
+
+// safeBatch writes the configured operation as a Safe Transaction Builder file of calls to the
+// system contracts and prints the hash of the Safe transaction executing it
+func safeBatch(command string, opts safeOptions) error {
+	if _, ok := journalOperations[command]; !ok {
+		return output.WithCode(output.CodeUsage, fmt.Errorf("unknown operation %s, expected switch, consolidate or el-exit", command))
+	}
+	if !common.IsHexAddress(opts.Safe) {
+		return output.WithCode(output.CodeUsage, fmt.Errorf("invalid Safe address %s", opts.Safe))
+	}
+	safeAddress := common.HexToAddress(opts.Safe)
+
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	// Positions are reported before duplicates are dropped, as they are in the config
+	list, pubkeys := "switch.validators", &cfg.Switch.Validators
+	if command == "consolidate" {
+		list, pubkeys = "consolidate.sourceValidators", &cfg.Consolidate.SourceValidators
+	}
+	if command != "el-exit" {
+		if err := pectra.ValidatePubkeys(*pubkeys); err != nil {
+			err = fmt.Errorf("invalid public key in %s: %w", list, err)
+			color.Red("%v", err)
+			return output.WithCode(output.CodeValidation, err)
+		}
+		for _, duplicate := range pectra.DuplicatePubkeys(*pubkeys) {
+			color.Yellow("%s #%d %s duplicates #%d, the validator is only requested once", list, duplicate.Position, duplicate.Pubkey, duplicate.FirstPosition)
+		}
+		*pubkeys = pectra.RemoveDuplicatePubkeys(*pubkeys)
+	}
+
+	call, err := configCall(cfg, command)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeUsage, err)
+	}
+	batch, err := call.Batch()
+	if err == nil {
+		err = batch.Validate()
+	}
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	ctx := context.Background()
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		color.Red("Failed to get the chain ID: %v", err)
+		return err
+	}
+	// Nothing is sent, the owners of the Safe confirm the batch
+	if err := cfg.VerifyChainID(chainID, true); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	var beaconClient *beacon.Client
+	if cfg.BeaconUrl == "" {
+		color.Yellow("No beacon node available (beaconUrl not set), skipping the check that the Safe is the withdrawal address of the validators")
+	} else {
+		beaconClient = beacon.NewClient(cfg.BeaconUrl)
+		if err := safe.CheckWithdrawalAddresses(beaconClient, safeAddress, call); err != nil {
+			color.Red("%v", err)
+			return output.WithCode(output.CodeValidation, err)
+		}
+	}
+	if err := checkConflicts(cfg, command, beaconClient); err != nil {
+		return err
+	}
+
+	version, err := safe.Version(ctx, client, safeAddress)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	multiSend, err := safe.MultiSendFor(version)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeValidation, err)
+	}
+	color.Cyan("Safe version %s, MultiSendCallOnly %s", version, multiSend.Hex())
+
+	nonce := opts.Nonce
+	if nonce == nil {
+		current, err := safe.Nonce(ctx, client, safeAddress)
+		if err != nil {
+			color.Red("%v", err)
+			return output.WithCode(output.CodeRPC, err)
+		}
+		nonce = &current
+	}
+
+	withdrawalFee, err := safe.PredeployFee(ctx, client, confirmation.WithdrawalRequestPredeploy)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	consolidationFee, err := safe.PredeployFee(ctx, client, confirmation.ConsolidationRequestPredeploy)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+
+	calls, err := safe.PredeployCalls(call, withdrawalFee, consolidationFee)
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	file := safe.NewBuilderFile(chainID, safeAddress, fmt.Sprintf("pectra-cli %s", command),
+		fmt.Sprintf("%s of %d validator(s) through the EIP-7002 and EIP-7251 system contracts", command, len(calls)), calls)
+	tx, err := safe.NewTransaction(chainID, safeAddress, multiSend, *nonce, calls)
+	if err != nil {
+		color.Red("Failed to encode the MultiSend call: %v", err)
+		return err
+	}
+
+	color.Cyan("%d call(s) to the system contracts, request fees: withdrawal %s wei, consolidation %s wei", len(calls), withdrawalFee, consolidationFee)
+	color.Yellow("The fees rise with the number of pending requests, execute the batch soon or rebuild it")
+	tx.Print()
+	if err := safe.WriteBuilderFile(file, opts.FileName); err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	output.SetOperation(command)
+	output.SetChain(chainID.Uint64())
+	output.SetFrom(safeAddress)
+	output.SetData(safeReport{
+		File:             opts.FileName,
+		Calls:            len(calls),
+		WithdrawalFee:    withdrawalFee,
+		ConsolidationFee: consolidationFee,
+		Transaction:      tx,
+	})
+	color.Cyan("Import %s in the Transaction Builder of the Safe, then check that the SafeTxHash shown matches", opts.FileName)
+	return nil
+}
+
+// safeHash prints the hash of the Safe transaction executing a Transaction Builder file
+func safeHash(filePath string, nonce uint64, version string) error {
+	multiSend, err := safe.MultiSendFor(version)
+	if err != nil {
+		return output.WithCode(output.CodeUsage, err)
+	}
+
+	file, err := safe.ReadBuilderFile(filePath)
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	chainID, ok := new(big.Int).SetString(file.ChainID, 10)
+	if !ok {
+		err := fmt.Errorf("invalid chain ID %q in %s", file.ChainID, filePath)
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	calls, err := file.Calls()
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	tx, err := safe.NewTransaction(chainID, file.Meta.CreatedFromSafeAddress, multiSend, nonce, calls)
+	if err != nil {
+		color.Red("Failed to encode the MultiSend call: %v", err)
+		return err
+	}
+	color.Cyan("%d call(s) in %s", len(calls), filePath)
+	tx.Print()
+	output.SetChain(chainID.Uint64())
+	output.SetFrom(tx.Safe)
+	output.SetData(tx)
+	return nil
+}
+
+// watchOptions holds the options of the watch command
+type watchOptions struct {
+	ConfigPath string
+	Hash       common.Hash
+	Latest     bool
+	Interval   time.Duration
+	Once       bool
+	ReportPath string
+	Journal    *journal.Journal
+}
+
+// watchTransaction follows the validator requests of a mined transaction on the beacon chain
+func watchTransaction(opts watchOptions) error {
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+	if cfg.BeaconUrl == "" {
+		color.Red("beaconUrl must be set in the configuration to watch validator requests")
+		return output.WithCode(output.CodeConfig, fmt.Errorf("beaconUrl is not set"))
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+
+	hash := opts.Hash
+	if opts.Latest {
+		chainID, err := client.ChainID(context.Background())
+		if err != nil {
+			color.Red("Failed to get the chain ID: %v", err)
+			return err
+		}
+		entry, err := latestBatchEntry(opts.Journal, chainID.Uint64())
+		if err != nil {
+			color.Red("%v", err)
+			return err
+		}
+		hash = entry.TxHash
+	}
+
+	results, header, err := transaction.MinedRequests(client, hash, transaction.Options{Journal: opts.Journal})
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	watcher, err := watch.New(beacon.NewClient(cfg.BeaconUrl), client, hash, results, time.Unix(int64(header.Time), 0))
+	if err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeBeacon, err)
+	}
+	color.Cyan("Watching %d validator request(s) of transaction %s", len(results), hash.Hex())
+
+	for {
+		if err := watcher.Poll(); err != nil {
+			// The beacon node may be briefly unavailable, the next poll catches up
+			color.Yellow("Failed to poll the beacon node: %v", err)
+		} else {
+			watcher.Print()
+		}
+
+		if opts.Once || watcher.Done() {
+			break
+		}
+		time.Sleep(opts.Interval)
+	}
+
+	output.SetData(watcher.Report())
+	return watch.WriteReport(watcher.Report(), opts.ReportPath)
+}
+
+// latestBatchEntry returns the latest confirmed transaction of the journal that made validator requests
+func latestBatchEntry(j *journal.Journal, chainID uint64) (*journal.Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.ChainID == chainID && entry.Status == journal.StatusConfirmed && len(entry.Validators) > 0 {
+			return &entry, nil
+		}
+	}
+	return nil, fmt.Errorf("no confirmed batch transaction in %s", j.Path())
+}
+
+// assembleAuthorization assembles the transaction for an authorization signed on an airgapped
+// machine. Sponsored transactions are sent with the sponsor key, the others are written out to be
+// signed offline by the withdrawal address.
+func assembleAuthorization(authorizationFilePath string, opts runOptions) error {
+	color.Green("Assembling transaction from file: %s", authorizationFilePath)
+
+	cfg, err := config.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	color.Green("Connected to the Ethereum client")
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		color.Red("Failed to get the chain ID: %v", err)
+		return err
+	}
+	if err := cfg.VerifyChainID(chainID, opts.ConfirmMainnet); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	request, err := transaction.ReadAuthorizationRequest(authorizationFilePath)
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+	if err := transaction.VerifySignedAuthorization(client, request, chainID); err != nil {
+		color.Red("Invalid authorization: %v", err)
+		return err
+	}
+	color.Green("Authorization signed by %s (nonce %d)", request.Authority.Hex(), request.SignedAuthorization.Nonce)
+
+	contractAddress := common.HexToAddress(cfg.PectraBatchContract)
+	switch target := request.SignedAuthorization.Address; target {
+	case contractAddress:
+		if opts.SkipCodeVerification {
+			color.Red("Skipping verification of the batch contract code at %s", contractAddress.Hex())
+		} else if err := utils.VerifyContractCode(client, contractAddress); err != nil {
+			color.Red("Refusing to delegate to the batch contract: %v", err)
+			return err
+		}
+	case common.Address{}:
+		color.Cyan("The authorization unsets the delegation of %s", request.Authority.Hex())
+	default:
+		color.Yellow("The authorization delegates %s to %s, which is not the configured batch contract", request.Authority.Hex(), target.Hex())
+		confirmed, err := config.Confirm("Continue?")
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	if !request.Sponsored {
+		if err := transaction.AssembleTransaction(client, request, opts.transactionOptions(cfg)); err != nil {
+			color.Red("Failed to assemble the transaction: %v", err)
+			return err
+		}
+		color.Green("Sign unsigned_txn.json with the withdrawal address and broadcast it")
+		return nil
+	}
+
+	if len(request.Data) > 0 {
+		color.Red("Refusing to assemble the sponsored transaction: %v", pectra.ErrSponsoredCall)
+		return output.WithCode(output.CodeValidation, pectra.ErrSponsoredCall)
+	}
+
+	value, err := request.ParseValue()
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	sponsorKey, err := config.GetSponsorPrivateKey()
+	if err != nil {
+		color.Red("Failed to get the sponsor private key: %v", err)
+		return output.WithCode(output.CodeKey, err)
+	}
+
+	err = transaction.SendWithSponsor(client, sponsorKey, *request.SignedAuthorization, request.Authority, request.Data, value, opts.transactionOptions(cfg))
+	if err != nil {
+		color.Red("Failed to send the sponsored transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// verifySnapshotContract checks the batch contract code hash recorded in a network snapshot
+func verifySnapshotContract(snapshot *transaction.NetworkSnapshot, contractAddress common.Address) error {
+	if err := config.CheckAuditedCodeHashes(); err != nil {
+		return err
+	}
+	if snapshot.ContractCodeHash == nil {
+		return fmt.Errorf("no contract code hash available offline, export a snapshot with the snapshot command or pass --skip-code-verification")
+	}
+	if snapshot.Contract != contractAddress {
+		return fmt.Errorf("network snapshot was taken for contract %s, but the configuration uses %s", snapshot.Contract.Hex(), contractAddress.Hex())
+	}
+	if !config.IsAuditedCodeHash(*snapshot.ContractCodeHash) {
+		return fmt.Errorf("code at %s (hash %s) does not match any audited Pectra batch contract", contractAddress.Hex(), snapshot.ContractCodeHash.Hex())
+	}
+
+	color.Green("Verified Pectra batch contract code hash %s from the network snapshot", snapshot.ContractCodeHash.Hex())
+	return nil
+}
+
+// takeSnapshot exports the chain data needed to prepare transactions for the address in offline mode
+func takeSnapshot(configPath, address, outputPath string) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		color.Red("Error loading config: %v", err)
+		return output.WithCode(output.CodeConfig, err)
+	}
+
+	client, err := rpc.Dial(cfg)
+	if err != nil {
+		color.Red("Failed to connect to the Ethereum client: %v", err)
+		return output.WithCode(output.CodeRPC, err)
+	}
+	color.Green("Connected to the Ethereum client")
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		color.Red("Failed to get the chain ID: %v", err)
+		return err
+	}
+	// Taking a snapshot only reads chain data, so mainnet does not need to be acknowledged here
+	if err := cfg.VerifyChainID(chainID, true); err != nil {
+		color.Red("%v", err)
+		return output.WithCode(output.CodeChainMismatch, err)
+	}
+
+	if address == "" {
+		address, err = config.GetPublicKey()
+		if err != nil {
+			color.Red("%v", err)
+			return err
+		}
+	} else if !common.IsHexAddress(address) {
+		color.Red("Invalid address: %s", address)
+		return fmt.Errorf("invalid address: %s", address)
+	}
+
+	contractAddress := common.HexToAddress(cfg.PectraBatchContract)
+	snapshot, err := transaction.TakeSnapshot(client, common.HexToAddress(address), contractAddress)
+	if err != nil {
+		color.Red("Failed to take the network snapshot: %v", err)
+		return err
+	}
+
+	parsedAbi, err := config.LoadABI()
+	if err != nil {
+		color.Red("%v", err)
+		return err
+	}
+
+	snapshot.ConsolidationFee, err = pectra.GetFee(context.Background(), client, parsedAbi, contractAddress, pectra.ConsolidationFeeFunction)
+	if err != nil {
+		color.Red("Failed to get the consolidation fee: %v", err)
+		return err
+	}
+	snapshot.ExitFee, err = pectra.GetFee(context.Background(), client, parsedAbi, contractAddress, pectra.ExitFeeFunction)
+	if err != nil {
+		color.Red("Failed to get the exit fee: %v", err)
+		return err
+	}
+
+	color.Cyan("Chain ID: %s, nonce of %s: %d", snapshot.ChainID, snapshot.Address.Hex(), snapshot.Nonce)
+	color.Cyan("Max fee per gas: %s wei, max priority fee per gas: %s wei", snapshot.MaxFeePerGas, snapshot.MaxPriorityFeePerGas)
+	color.Cyan("Consolidation fee: %s wei, exit fee: %s wei", snapshot.ConsolidationFee, snapshot.ExitFee)
+
+	return transaction.WriteSnapshot(snapshot, outputPath)
 }
 
 // Add this new function for broadcasting transactions
-func broadcastTransaction(txFilePath string, configPath string) error {
+func broadcastTransaction(txFilePath string, configPath string, opts transaction.BroadcastOptions) error {
 	color.Green("Broadcasting transaction from file: %s", txFilePath)
 
 	// Call the broadcast function directly with the file
-	err := transaction.BroadcastTransactionFromFile(txFilePath, configPath)
+	err := transaction.BroadcastTransactionFromFile(txFilePath, configPath, opts)
 	if err != nil {
 		color.Red("Failed to broadcast transaction: %v", err)
 		return err
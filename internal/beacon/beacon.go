@@ -0,0 +1,282 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// SlotsPerEpoch is the number of slots in an epoch
+	SlotsPerEpoch = 32
+	// SecondsPerSlot is the duration of a slot
+	SecondsPerSlot = 12
+	// FarFutureEpoch is the epoch used for exit and withdrawable epochs that are not set
+	FarFutureEpoch = math.MaxUint64
+	// ShardCommitteePeriod is the number of epochs a validator must be active before it can exit or consolidate
+	ShardCommitteePeriod = 256
+	// MaxEffectiveBalanceElectra is the maximum effective balance (in Gwei) of a compounding validator
+	MaxEffectiveBalanceElectra = 2048_000_000_000
+	// MinActivationBalance is the balance (in Gwei) required to activate a validator
+	MinActivationBalance = 32_000_000_000
+	// EffectiveBalanceIncrement is the granularity (in Gwei) of effective balances
+	EffectiveBalanceIncrement = 1_000_000_000
+
+	// CompoundingWithdrawalPrefix marks 0x02 withdrawal credentials
+	CompoundingWithdrawalPrefix = 0x02
+	// ETH1AddressWithdrawalPrefix marks 0x01 withdrawal credentials
+	ETH1AddressWithdrawalPrefix = 0x01
+)
+
+// Client is a minimal client for the standard Ethereum beacon node API
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// ValidatorDetails represents the validator record in the beacon state
+type ValidatorDetails struct {
+	Pubkey                     string `json:"pubkey"`
+	WithdrawalCredentials      string `json:"withdrawal_credentials"`
+	EffectiveBalance           uint64 `json:"effective_balance,string"`
+	Slashed                    bool   `json:"slashed"`
+	ActivationEligibilityEpoch uint64 `json:"activation_eligibility_epoch,string"`
+	ActivationEpoch            uint64 `json:"activation_epoch,string"`
+	ExitEpoch                  uint64 `json:"exit_epoch,string"`
+	WithdrawableEpoch          uint64 `json:"withdrawable_epoch,string"`
+}
+
+// Validator represents a validator as returned by the beacon node
+type Validator struct {
+	Index     uint64           `json:"index,string"`
+	Balance   uint64           `json:"balance,string"`
+	Status    string           `json:"status"`
+	Validator ValidatorDetails `json:"validator"`
+}
+
+// PendingPartialWithdrawal represents an entry of the pending partial withdrawals queue
+type PendingPartialWithdrawal struct {
+	ValidatorIndex    uint64 `json:"validator_index,string"`
+	Amount            uint64 `json:"amount,string"`
+	WithdrawableEpoch uint64 `json:"withdrawable_epoch,string"`
+}
+
+// PendingConsolidation represents an entry of the pending consolidations queue
+type PendingConsolidation struct {
+	SourceIndex uint64 `json:"source_index,string"`
+	TargetIndex uint64 `json:"target_index,string"`
+}
+
+// NewClient creates a beacon API client for the given base URL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetValidators returns the validators with the given public keys from the head state.
+// Validators that are unknown to the beacon node are not part of the result.
+func (c *Client) GetValidators(pubkeys []string) ([]Validator, error) {
+	ids := make([]string, 0, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		ids = append(ids, NormalizePubkey(pubkey))
+	}
+	return c.postValidators(map[string][]string{"ids": ids})
+}
+
+// GetValidatorsByIndex returns the validators with the given indices from the head state
+func (c *Client) GetValidatorsByIndex(indices []uint64) ([]Validator, error) {
+	ids := make([]string, 0, len(indices))
+	for _, index := range indices {
+		ids = append(ids, strconv.FormatUint(index, 10))
+	}
+	return c.postValidators(map[string][]string{"ids": ids})
+}
+
+// GetValidatorsByStatus returns the validators of the head state with one of the given statuses
+func (c *Client) GetValidatorsByStatus(statuses ...string) ([]Validator, error) {
+	return c.postValidators(map[string][]string{"statuses": statuses})
+}
+
+// postValidators queries the validators of the head state matching the filter
+func (c *Client) postValidators(filter map[string][]string) ([]Validator, error) {
+	body, err := json.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode validator filter: %w", err)
+	}
+
+	var validators []Validator
+	if err := c.do(http.MethodPost, "/eth/v1/beacon/states/head/validators", body, &validators); err != nil {
+		return nil, fmt.Errorf("failed to get validators: %w", err)
+	}
+	return validators, nil
+}
+
+// GetTotalActiveBalance returns the total active balance (in Gwei) of the head state, the sum of the
+// effective balances of the active validators as in the churn computation of the consensus specs.
+// The response covers every active validator, so it can be large on mainnet.
+func (c *Client) GetTotalActiveBalance() (uint64, error) {
+	// Downloading every active validator can take longer than the default timeout
+	slow := &Client{BaseURL: c.BaseURL, HTTPClient: &http.Client{Timeout: 5 * time.Minute}}
+	validators, err := slow.GetValidatorsByStatus("active_ongoing", "active_exiting", "active_slashed")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the active validators: %w", err)
+	}
+
+	var total uint64
+	for _, validator := range validators {
+		total += validator.Validator.EffectiveBalance
+	}
+	return max(total, EffectiveBalanceIncrement), nil
+}
+
+// GetHeadSlot returns the slot of the current head block
+func (c *Client) GetHeadSlot() (uint64, error) {
+	var header struct {
+		Header struct {
+			Message struct {
+				Slot uint64 `json:"slot,string"`
+			} `json:"message"`
+		} `json:"header"`
+	}
+	if err := c.do(http.MethodGet, "/eth/v1/beacon/headers/head", nil, &header); err != nil {
+		return 0, fmt.Errorf("failed to get head header: %w", err)
+	}
+	return header.Header.Message.Slot, nil
+}
+
+// GetCurrentEpoch returns the epoch of the current head block
+func (c *Client) GetCurrentEpoch() (uint64, error) {
+	slot, err := c.GetHeadSlot()
+	if err != nil {
+		return 0, err
+	}
+	return slot / SlotsPerEpoch, nil
+}
+
+// GetPendingPartialWithdrawals returns the pending partial withdrawals queue of the head state
+func (c *Client) GetPendingPartialWithdrawals() ([]PendingPartialWithdrawal, error) {
+	var withdrawals []PendingPartialWithdrawal
+	if err := c.do(http.MethodGet, "/eth/v1/beacon/states/head/pending_partial_withdrawals", nil, &withdrawals); err != nil {
+		return nil, fmt.Errorf("failed to get pending partial withdrawals: %w", err)
+	}
+	return withdrawals, nil
+}
+
+// GetPendingConsolidations returns the pending consolidations queue of the head state
+func (c *Client) GetPendingConsolidations() ([]PendingConsolidation, error) {
+	var consolidations []PendingConsolidation
+	if err := c.do(http.MethodGet, "/eth/v1/beacon/states/head/pending_consolidations", nil, &consolidations); err != nil {
+		return nil, fmt.Errorf("failed to get pending consolidations: %w", err)
+	}
+	return consolidations, nil
+}
+
+// GetGenesisTime returns the genesis time of the chain
+func (c *Client) GetGenesisTime() (time.Time, error) {
+	var genesis struct {
+		GenesisTime int64 `json:"genesis_time,string"`
+	}
+	if err := c.do(http.MethodGet, "/eth/v1/beacon/genesis", nil, &genesis); err != nil {
+		return time.Time{}, fmt.Errorf("failed to get genesis: %w", err)
+	}
+	return time.Unix(genesis.GenesisTime, 0), nil
+}
+
+// EpochTime returns the start time of the epoch
+func EpochTime(genesis time.Time, epoch uint64) time.Time {
+	return genesis.Add(time.Duration(epoch*SlotsPerEpoch*SecondsPerSlot) * time.Second)
+}
+
+// EpochAt returns the epoch at the given time
+func EpochAt(genesis time.Time, t time.Time) uint64 {
+	if t.Before(genesis) {
+		return 0
+	}
+	return uint64(t.Sub(genesis)/time.Second) / (SlotsPerEpoch * SecondsPerSlot)
+}
+
+// do performs a request against the beacon node and decodes the "data" field of the response into out
+func (c *Client) do(method, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("beacon node returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("failed to decode beacon node response: %w", err)
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// NormalizePubkey returns the pubkey in the lowercase 0x-prefixed form used by the beacon API
+func NormalizePubkey(pubkey string) string {
+	return pectra.NormalizePubkey(pubkey)
+}
+
+// WithdrawalPrefix returns the type byte of the validator's withdrawal credentials
+func (v *Validator) WithdrawalPrefix() byte {
+	credentials := common.FromHex(v.Validator.WithdrawalCredentials)
+	if len(credentials) != 32 {
+		return 0
+	}
+	return credentials[0]
+}
+
+// HasExecutionWithdrawalCredential reports whether the validator has 0x01 or 0x02 credentials
+func (v *Validator) HasExecutionWithdrawalCredential() bool {
+	prefix := v.WithdrawalPrefix()
+	return prefix == ETH1AddressWithdrawalPrefix || prefix == CompoundingWithdrawalPrefix
+}
+
+// WithdrawalAddress returns the execution address encoded in the withdrawal credentials
+func (v *Validator) WithdrawalAddress() common.Address {
+	credentials := common.FromHex(v.Validator.WithdrawalCredentials)
+	if len(credentials) != 32 {
+		return common.Address{}
+	}
+	return common.BytesToAddress(credentials[12:])
+}
+
+// IsActive reports whether the validator is active at the given epoch
+func (v *Validator) IsActive(epoch uint64) bool {
+	return v.Validator.ActivationEpoch <= epoch && epoch < v.Validator.ExitEpoch
+}
+
+// IsExiting reports whether the validator has already initiated an exit
+func (v *Validator) IsExiting() bool {
+	return v.Validator.ExitEpoch != FarFutureEpoch
+}
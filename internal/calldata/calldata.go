@@ -0,0 +1,146 @@
+package calldata
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Request kinds, matching the system contract each request is sent to
+const (
+	KindSwitch        = "switch"
+	KindConsolidation = "consolidation"
+	KindWithdrawal    = "withdrawal"
+)
+
+// Request is a single validator request contained in a batch contract call
+type Request struct {
+	Kind string
+	// SourcePubkey is the validator the request is made for
+	SourcePubkey string
+	// TargetPubkey is the consolidation target, equal to SourcePubkey for switches
+	TargetPubkey string
+	// Amount is the withdrawal amount in Gwei, 0 for full exits
+	Amount   uint64
+	FullExit bool
+}
+
+// Call is a decoded call to the Pectra batch contract
+type Call struct {
+	Method   string
+	Requests []Request
+}
+
+// Decode decodes the calldata of a Pectra batch contract call into the validator requests it makes
+func Decode(contractABI abi.ABI, data []byte) (*Call, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("calldata is too short to contain a method selector")
+	}
+
+	method, err := contractABI.MethodById(data[:4])
+	if err != nil {
+		return nil, fmt.Errorf("unknown method selector 0x%x: %w", data[:4], err)
+	}
+
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s arguments: %w", method.Name, err)
+	}
+
+	call := &Call{Method: method.Name}
+
+	switch method.Name {
+	case pectra.MethodSwitch:
+		for _, pubkey := range args[0].([][]byte) {
+			call.Requests = append(call.Requests, Request{
+				Kind:         KindSwitch,
+				SourcePubkey: hexPubkey(pubkey),
+				TargetPubkey: hexPubkey(pubkey),
+			})
+		}
+
+	case pectra.MethodConsolidation:
+		target := hexPubkey(args[1].([]byte))
+		for _, pubkey := range args[0].([][]byte) {
+			call.Requests = append(call.Requests, Request{
+				Kind:         KindConsolidation,
+				SourcePubkey: hexPubkey(pubkey),
+				TargetPubkey: target,
+			})
+		}
+
+	case pectra.MethodELExit:
+		// The tuple is decoded into an anonymous struct generated by the abi package
+		exits := reflect.ValueOf(args[0])
+		for i := 0; i < exits.Len(); i++ {
+			exit := exits.Index(i)
+			call.Requests = append(call.Requests, Request{
+				Kind:         KindWithdrawal,
+				SourcePubkey: hexPubkey(exit.FieldByName("Pubkey").Bytes()),
+				Amount:       exit.FieldByName("Amount").Uint(),
+				FullExit:     exit.FieldByName("IsFullExit").Bool(),
+			})
+		}
+
+	default:
+		return nil, fmt.Errorf("method %s does not make validator requests", method.Name)
+	}
+
+	return call, nil
+}
+
+// Encode packs the requests of the call into calldata for the Pectra batch contract
+func Encode(contractABI abi.ABI, call *Call) ([]byte, error) {
+	batch, err := call.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return batch.Pack(contractABI)
+}
+
+// Batch converts the requests of the call into the batch of the pectra package
+func (call *Call) Batch() (pectra.Batch, error) {
+	if len(call.Requests) == 0 {
+		return nil, fmt.Errorf("%s needs at least one request", call.Method)
+	}
+
+	switch call.Method {
+	case pectra.MethodSwitch:
+		batch := pectra.SwitchBatch{}
+		for _, request := range call.Requests {
+			batch.Validators = append(batch.Validators, request.SourcePubkey)
+		}
+		return batch, nil
+
+	case pectra.MethodConsolidation:
+		batch := pectra.ConsolidationBatch{Target: call.Requests[0].TargetPubkey}
+		for _, request := range call.Requests {
+			if request.TargetPubkey != batch.Target {
+				return nil, fmt.Errorf("all consolidations of a batch must share the target %s", batch.Target)
+			}
+			batch.Sources = append(batch.Sources, request.SourcePubkey)
+		}
+		return batch, nil
+
+	case pectra.MethodELExit:
+		batch := pectra.ELExitBatch{}
+		for _, request := range call.Requests {
+			batch.Exits = append(batch.Exits, pectra.Exit{
+				Pubkey:     request.SourcePubkey,
+				AmountGwei: request.Amount,
+				FullExit:   request.FullExit,
+			})
+		}
+		return batch, nil
+	}
+
+	return nil, fmt.Errorf("method %s does not make validator requests", call.Method)
+}
+
+// hexPubkey formats a validator public key the way it is written in the configuration
+func hexPubkey(pubkey []byte) string {
+	return common.Bytes2Hex(pubkey)
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TestAuditedCodeHashes makes sure the code of every built-in deployment can be verified
+func TestAuditedCodeHashes(t *testing.T) {
+	if err := CheckAuditedCodeHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, network := range networks {
+		if network.BatchContract == (common.Address{}) {
+			continue
+		}
+		hash, ok := auditedCodeHashes[network.BatchContract]
+		switch {
+		case !ok:
+			t.Errorf("%s: no audited code hash for the batch contract %s", name, network.BatchContract.Hex())
+		case hash == (common.Hash{}):
+			t.Errorf("%s: the audited code hash of %s is zero", name, network.BatchContract.Hex())
+		case !IsAuditedCodeHash(hash):
+			t.Errorf("%s: the code hash %s is not accepted", name, hash.Hex())
+		}
+	}
+}
@@ -1,15 +1,18 @@
 package config
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
-	_ "embed"
-
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -17,17 +20,24 @@ import (
 	"golang.org/x/term"
 )
 
-//go:embed abi.json
-var abiFile []byte
-
 // Config represents the JSON input file structure
 type Config struct {
+	// Schema is the optional JSON Schema reference of the file, ignored
+	Schema              string            `json:"$schema,omitempty"`
+	Network             string            `json:"network"`
+	ChainID             uint64            `json:"chainId"`
 	RPCUrl              string            `json:"rpcUrl"`
+	RPCUrls             []string          `json:"rpcUrls"`
+	PrivateRelayUrl     string            `json:"privateRelayUrl"`
+	BeaconUrl           string            `json:"beaconUrl"`
 	BlockExplorerUrl    string            `json:"blockExplorerUrl"`
 	PectraBatchContract string            `json:"pectraBatchContract"`
 	Switch              SwitchConfig      `json:"switch"`
 	Consolidate         ConsolidateConfig `json:"consolidate"`
 	ELExit              ELExitConfig      `json:"elExit"`
+
+	// Profile is the network profile resolved from Network
+	Profile Network `json:"-"`
 }
 
 // SwitchConfig represents the switch configuration
@@ -59,49 +69,82 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	// Typos in field names must not silently leave an operation empty
+	if problems := ValidateSchema(data); len(problems) > 0 {
+		return nil, &ValidationError{File: path, Problems: problems}
 	}
 
-	// Validate required fields
-	if config.RPCUrl == "" {
-		return nil, fmt.Errorf("rpcUrl is required in the configuration")
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	if config.PectraBatchContract == "" {
-		return nil, fmt.Errorf("pectraBatchContract is required in the configuration")
+	if err := config.resolveNetwork(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
 }
 
-// LoadABI loads the ABI from a file
-func LoadABI() (abi.ABI, error) {
-	contractABI, err := abi.JSON(strings.NewReader(string(abiFile)))
-	if err != nil {
-		return abi.ABI{}, fmt.Errorf("failed to parse ABI: %v", err)
+// Endpoints returns the RPC endpoints of the configuration, rpcUrl first, without duplicates
+func (c *Config) Endpoints() []string {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, endpoint := range append([]string{c.RPCUrl}, c.RPCUrls...) {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" || seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		endpoints = append(endpoints, endpoint)
 	}
-	return contractABI, nil
+	return endpoints
+}
+
+// LoadABI loads the ABI of the Pectra batch contract
+func LoadABI() (abi.ABI, error) {
+	return pectra.ABI()
 }
 
 // GetPrivateKey securely gets the private key from the config or prompts the user
 func GetPrivateKey() (*ecdsa.PrivateKey, error) {
+	return readPrivateKey("Please enter your private key (without 0x prefix):")
+}
+
+// GetSponsorPrivateKey securely prompts for the private key of the account paying for the transaction
+func GetSponsorPrivateKey() (*ecdsa.PrivateKey, error) {
+	return readPrivateKey("Please enter the sponsor private key that pays for the transaction (without 0x prefix):")
+}
+
+// readPrivateKey prompts for a private key without echoing it to the terminal
+func readPrivateKey(prompt string) (*ecdsa.PrivateKey, error) {
 	var privateKeyHex string
 
 	// If private key is not in config, prompt for it securely
-	color.Cyan("Please enter your private key (without 0x prefix):")
+	color.Cyan(prompt)
 	color.Yellow("Note: For security, the key will not be displayed when pasted. Just paste and press Enter.")
-	fmt.Print("> ")
-
-	// Read password without echoing to terminal
-	bytePassword, err := term.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key: %w", err)
+	fmt.Fprint(color.Output, "> ")
+
+	// Read password without echoing to terminal. Piped input has no echo to hide and is read
+	// through the shared reader, so no line meant for a later prompt is lost.
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		bytePassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		fmt.Fprintln(color.Output) // Add a newline after the password input
+		privateKeyHex = string(bytePassword)
+	} else {
+		input, err := readLine()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		privateKeyHex = input
 	}
-	fmt.Println() // Add a newline after the password input
 
-	privateKeyHex = strings.TrimSpace(string(bytePassword))
+	privateKeyHex = strings.TrimSpace(privateKeyHex)
 
 	// Validate private key format
 	privateKeyHex = strings.TrimPrefix(privateKeyHex, "0x")
@@ -120,10 +163,9 @@ func GetPublicKey() (string, error) {
 
 	// Prompt user for input
 	color.Cyan("Please enter the withdrawal address (0x... format):")
-	fmt.Print("> ")
+	fmt.Fprint(color.Output, "> ")
 
-	var input string
-	_, err := fmt.Scanln(&input)
+	input, err := readLine()
 	if err != nil {
 		return "", fmt.Errorf("failed to read address: %w", err)
 	}
@@ -154,3 +196,32 @@ func GetPublicKey() (string, error) {
 	// Return checksum address for consistency
 	return common.HexToAddress(publicKeyHex).Hex(), nil
 }
+
+// stdin reads the input of every prompt line by line. All prompts share it, so a line buffered
+// while reading one answer is still there for the next prompt when the input is piped.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads the next line of input without its line ending. The last line may end at the
+// end of the input instead.
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Confirm asks the user a yes/no question and returns true if they answered yes
+func Confirm(question string) (bool, error) {
+	color.Cyan("%s [y/N]", question)
+	fmt.Fprint(color.Output, "> ")
+
+	// A closed input without an answer is a no
+	input, err := readLine()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(input))
+	return answer == "y" || answer == "yes", nil
+}
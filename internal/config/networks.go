@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
+)
+
+// Network names accepted in the "network" field of the configuration
+const (
+	NetworkMainnet = "mainnet"
+	NetworkHoodi   = "hoodi"
+	NetworkSepolia = "sepolia"
+	NetworkCustom  = "custom"
+)
+
+// MainnetChainID is the chain ID of Ethereum mainnet
+const MainnetChainID = 1
+
+// Network describes a supported network and the audited Pectra batch contract deployed on it
+type Network struct {
+	Name    string
+	ChainID uint64
+	// BatchContract is the audited Pectra batch contract, zero if there is no known deployment
+	BatchContract common.Address
+	ExplorerUrl   string
+}
+
+// networks holds the built-in network profiles
+var networks = map[string]Network{
+	NetworkMainnet: {
+		Name:          NetworkMainnet,
+		ChainID:       MainnetChainID,
+		BatchContract: common.HexToAddress("0x17c11FDdADac2b341F2455aFe988fec4c3ba26e3"),
+		ExplorerUrl:   "https://etherscan.io",
+	},
+	NetworkHoodi: {
+		Name:          NetworkHoodi,
+		ChainID:       560048,
+		BatchContract: common.HexToAddress("0xe264B0F3e491Ab5aEd2C0A32956cb9e68707F457"),
+		ExplorerUrl:   "https://hoodi.etherscan.io",
+	},
+	NetworkSepolia: {
+		Name:        NetworkSepolia,
+		ChainID:     11155111,
+		ExplorerUrl: "https://sepolia.etherscan.io",
+	},
+}
+
+// GetNetwork returns the built-in profile with the given name
+func GetNetwork(name string) (Network, bool) {
+	network, ok := networks[name]
+	return network, ok
+}
+
+// resolveNetwork applies the selected network profile to the configuration, filling in
+// defaults and rejecting values that do not belong to the profile
+func (c *Config) resolveNetwork() error {
+	if c.Network == "" {
+		color.Yellow("No network set in the configuration, treating it as \"custom\". Set \"network\" to mainnet, hoodi or sepolia to verify the RPC and contract.")
+		c.Network = NetworkCustom
+	}
+
+	if c.Network == NetworkCustom {
+		if c.PectraBatchContract == "" {
+			return fmt.Errorf("pectraBatchContract is required for the custom network")
+		}
+		c.Profile = Network{Name: NetworkCustom, ChainID: c.ChainID, ExplorerUrl: c.BlockExplorerUrl}
+		return nil
+	}
+
+	profile, ok := GetNetwork(c.Network)
+	if !ok {
+		return fmt.Errorf("unknown network %q, expected one of mainnet, hoodi, sepolia or custom", c.Network)
+	}
+
+	if c.ChainID != 0 && c.ChainID != profile.ChainID {
+		return fmt.Errorf("chainId %d does not match the %s chain ID %d", c.ChainID, profile.Name, profile.ChainID)
+	}
+
+	if c.BlockExplorerUrl == "" {
+		c.BlockExplorerUrl = profile.ExplorerUrl
+	}
+
+	switch {
+	case c.PectraBatchContract == "" && profile.BatchContract == (common.Address{}):
+		return fmt.Errorf("there is no known Pectra batch contract on %s, pectraBatchContract is required", profile.Name)
+	case c.PectraBatchContract == "":
+		c.PectraBatchContract = profile.BatchContract.Hex()
+	case profile.BatchContract != (common.Address{}) && common.HexToAddress(c.PectraBatchContract) != profile.BatchContract:
+		return fmt.Errorf("pectraBatchContract %s is not the audited %s deployment %s, use the custom network to override",
+			c.PectraBatchContract, profile.Name, profile.BatchContract.Hex())
+	}
+
+	c.Profile = profile
+	return nil
+}
+
+// VerifyChainID checks that the chain ID reported by the RPC endpoint matches the selected
+// network profile, and that operations on mainnet have been explicitly acknowledged
+func (c *Config) VerifyChainID(chainID *big.Int, confirmMainnet bool) error {
+	if c.Profile.ChainID != 0 && chainID.Uint64() != c.Profile.ChainID {
+		return fmt.Errorf("RPC endpoint is on chain ID %s, but the %s network expects chain ID %d", chainID, c.Profile.Name, c.Profile.ChainID)
+	}
+	if c.Profile.ChainID == 0 {
+		color.Yellow("No chain ID configured for the custom network, RPC endpoint reports chain ID %s", chainID)
+	}
+
+	if chainID.Uint64() == MainnetChainID && !confirmMainnet {
+		return fmt.Errorf("this operation targets Ethereum mainnet, re-run with --confirm-mainnet to proceed")
+	}
+
+	return nil
+}
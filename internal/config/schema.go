@@ -0,0 +1,497 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+)
+
+// schemaJSON is the JSON Schema of the configuration file, published with the repository
+//
+//go:embed config.schema.json
+var schemaJSON []byte
+
+// configSchema is the parsed schema the configuration is validated against
+var configSchema = mustParseSchema(schemaJSON)
+
+// pubkeyFormat is the format of validator public keys, checked as BLS12-381 G1 points
+const pubkeyFormat = "bls12-381-pubkey"
+
+// identifierRegex matches the keys written as .key in a JSON path
+var identifierRegex = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// SchemaError is a problem of a configuration file, at a JSON path and a line and column of the file
+type SchemaError struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// Error implements error
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%d:%d %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// ValidationError lists every problem found in a configuration or workflow file
+type ValidationError struct {
+	File     string
+	Problems []*SchemaError
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		lines = append(lines, fmt.Sprintf("%s:%v", e.File, problem))
+	}
+	return fmt.Sprintf("%d problem(s) in %s:\n%s", len(e.Problems), e.File, strings.Join(lines, "\n"))
+}
+
+// Schema returns the JSON Schema of the configuration file
+func Schema() []byte {
+	return schemaJSON
+}
+
+// schema is the subset of JSON Schema used by the configuration schema
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Enum                 []string           `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	MinLength            *int               `json:"minLength"`
+	Pattern              string             `json:"pattern"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	PropertyNames        *schema            `json:"propertyNames"`
+	Required             []string           `json:"required"`
+	MaxProperties        *int               `json:"maxProperties"`
+	Items                *schema            `json:"items"`
+	MaxItems             *int               `json:"maxItems"`
+	Defs                 map[string]*schema `json:"$defs"`
+
+	pattern    *regexp.Regexp
+	closed     bool
+	additional *schema
+}
+
+// mustParseSchema parses the embedded schema, which is part of the build
+func mustParseSchema(data []byte) *schema {
+	var root schema
+	if err := json.Unmarshal(data, &root); err != nil {
+		panic(fmt.Sprintf("invalid configuration schema: %v", err))
+	}
+	root.compile()
+	return &root
+}
+
+// compile prepares the patterns and additional properties of the schema and its subschemas
+func (s *schema) compile() {
+	if s == nil {
+		return
+	}
+	if s.Pattern != "" {
+		s.pattern = regexp.MustCompile(s.Pattern)
+	}
+	switch raw := strings.TrimSpace(string(s.AdditionalProperties)); {
+	case raw == "false":
+		s.closed = true
+	case strings.HasPrefix(raw, "{"):
+		s.additional = new(schema)
+		if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+			panic(fmt.Sprintf("invalid configuration schema: %v", err))
+		}
+	}
+	for _, sub := range s.Properties {
+		sub.compile()
+	}
+	for _, sub := range s.Defs {
+		sub.compile()
+	}
+	s.PropertyNames.compile()
+	s.Items.compile()
+	s.additional.compile()
+}
+
+// node is a JSON value with the offset it starts at in the file
+type node struct {
+	offset  int
+	kind    string
+	value   interface{}
+	members []member
+	items   []*node
+}
+
+// member is a key of a JSON object with its value
+type member struct {
+	key    string
+	offset int
+	value  *node
+}
+
+// ValidateSchema checks configuration data against the schema and returns every problem found,
+// including JSON syntax errors, unknown fields and duplicate keys
+func ValidateSchema(data []byte) []*SchemaError {
+	root, problems := parseDocument(data)
+	if problems != nil {
+		return problems
+	}
+
+	v := &validator{data: data, root: configSchema}
+	v.validate(root, configSchema, "$")
+	return v.problems
+}
+
+// operationSections are the config sections of the operations, defined in the $defs of the schema
+var operationSections = []string{"switch", "consolidate", "elExit"}
+
+// ValidateOperationSections checks the operation sections (switch, consolidate and elExit) of every
+// object of the top-level array listKey of a document, such as the steps of a workflow, against
+// the same definitions as the configuration file. Other fields of the document are not checked.
+func ValidateOperationSections(data []byte, listKey string) []*SchemaError {
+	root, problems := parseDocument(data)
+	if problems != nil {
+		return problems
+	}
+
+	v := &validator{data: data, root: configSchema}
+	if root.kind != "object" {
+		v.report(root.offset, "$", "expected an object, got %s", article(root.kind))
+		return v.problems
+	}
+	for _, list := range root.members {
+		if list.key != listKey || list.value.kind != "array" {
+			continue
+		}
+		for i, item := range list.value.items {
+			for _, m := range item.members {
+				if contains(operationSections, m.key) {
+					path := childPath(fmt.Sprintf("%s[%d]", childPath("$", listKey), i), m.key)
+					v.validate(m.value, configSchema.Defs[m.key], path)
+				}
+			}
+		}
+	}
+	return v.problems
+}
+
+// parseDocument reads a JSON document with the offsets of its values, or returns its syntax error
+func parseDocument(data []byte) (*node, []*SchemaError) {
+	p := &parser{data: data, decoder: json.NewDecoder(bytes.NewReader(data))}
+	p.decoder.UseNumber()
+
+	root, err := p.value()
+	if err == nil {
+		if _, extra := p.decoder.Token(); extra != io.EOF {
+			err = &syntaxError{offset: p.start(), message: "unexpected data after the configuration"}
+		}
+	}
+	if err != nil {
+		problem := &syntaxError{offset: len(data), message: err.Error()}
+		var jsonErr *json.SyntaxError
+		if errors.As(err, &jsonErr) && jsonErr.Offset > 0 {
+			// The offset follows the character in error
+			problem.offset = int(jsonErr.Offset) - 1
+		}
+		errors.As(err, &problem)
+		line, column := position(data, problem.offset)
+		return nil, []*SchemaError{{Path: "$", Line: line, Column: column, Message: "invalid JSON: " + problem.message}}
+	}
+	return root, nil
+}
+
+// syntaxError is a JSON syntax error at an offset of the file
+type syntaxError struct {
+	offset  int
+	message string
+}
+
+// Error implements error
+func (e *syntaxError) Error() string {
+	return e.message
+}
+
+// parser reads JSON values and their offsets from a decoder
+type parser struct {
+	data    []byte
+	decoder *json.Decoder
+}
+
+// start returns the offset of the next value, after the separators the decoder has not consumed
+func (p *parser) start() int {
+	offset := int(p.decoder.InputOffset())
+	for offset < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// value reads the next JSON value
+func (p *parser) value() (*node, error) {
+	offset := p.start()
+	token, err := p.decoder.Token()
+	if err == io.EOF {
+		return nil, &syntaxError{offset: offset, message: "unexpected end of file"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{offset: offset, value: token}
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			n.kind = "object"
+			for p.decoder.More() {
+				keyOffset := p.start()
+				key, err := p.decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				n.members = append(n.members, member{key: key.(string), offset: keyOffset, value: value})
+			}
+		case '[':
+			n.kind = "array"
+			for p.decoder.More() {
+				item, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, item)
+			}
+		}
+		// The closing delimiter
+		if _, err := p.decoder.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.kind = "string"
+	case json.Number:
+		n.kind = "number"
+	case bool:
+		n.kind = "boolean"
+	case nil:
+		n.kind = "null"
+	}
+	return n, nil
+}
+
+// validator collects the problems of a document
+type validator struct {
+	data     []byte
+	root     *schema
+	problems []*SchemaError
+}
+
+// report records a problem at an offset of the file
+func (v *validator) report(offset int, path string, format string, args ...interface{}) {
+	line, column := position(v.data, offset)
+	v.problems = append(v.problems, &SchemaError{Path: path, Line: line, Column: column, Message: fmt.Sprintf(format, args...)})
+}
+
+// validate checks a value against a schema
+func (v *validator) validate(n *node, s *schema, path string) {
+	if s.Ref != "" {
+		s = v.root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+
+	if !hasType(n, s.Type) {
+		if s.Type == "integer" && n.kind == "number" {
+			v.report(n.offset, path, "must be a whole number, got %v", n.value)
+			return
+		}
+		v.report(n.offset, path, "expected %s, got %s", article(s.Type), article(n.kind))
+		return
+	}
+
+	switch n.kind {
+	case "string":
+		v.validateString(n.offset, n.value.(string), s, path)
+	case "number":
+		number, _ := n.value.(json.Number).Float64()
+		if s.Minimum != nil && number < *s.Minimum {
+			v.report(n.offset, path, "must be at least %v, got %v", *s.Minimum, n.value)
+		}
+	case "array":
+		if s.MaxItems != nil && len(n.items) > *s.MaxItems {
+			v.report(n.offset, path, "has %d items, the maximum is %d", len(n.items), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range n.items {
+				v.validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case "object":
+		v.validateObject(n, s, path)
+	}
+}
+
+// validateString checks a string value, or an object key, against a schema
+func (v *validator) validateString(offset int, value string, s *schema, path string) {
+	if s.Ref != "" {
+		s = v.root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+	if len(s.Enum) > 0 && !contains(s.Enum, value) {
+		v.report(offset, path, "invalid value %q, expected one of %s", value, strings.Join(s.Enum, ", "))
+	}
+	if s.MinLength != nil && len(value) < *s.MinLength {
+		v.report(offset, path, "must not be empty")
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		v.report(offset, path, "invalid value %q, expected %s", value, s.Pattern)
+		return
+	}
+	if s.Format == pubkeyFormat {
+		if err := pectra.ValidatePubkey(value); err != nil {
+			v.report(offset, path, "invalid public key: %v", err)
+		}
+	}
+}
+
+// validateObject checks the keys and values of an object
+func (v *validator) validateObject(n *node, s *schema, path string) {
+	if s.MaxProperties != nil && len(n.members) > *s.MaxProperties {
+		v.report(n.offset, path, "has %d entries, the maximum is %d", len(n.members), *s.MaxProperties)
+	}
+
+	seen := make(map[string]bool, len(n.members))
+	for _, m := range n.members {
+		memberPath := childPath(path, m.key)
+		if seen[m.key] {
+			v.report(m.offset, memberPath, "duplicate key, only the last value would be used")
+		}
+		seen[m.key] = true
+
+		if s.PropertyNames != nil {
+			v.validateString(m.offset, m.key, s.PropertyNames, memberPath)
+		}
+		switch sub, ok := s.Properties[m.key]; {
+		case ok:
+			v.validate(m.value, sub, memberPath)
+		case s.additional != nil:
+			v.validate(m.value, s.additional, memberPath)
+		case s.closed:
+			if suggestion := suggest(m.key, s.Properties); suggestion != "" {
+				v.report(m.offset, memberPath, "unknown field %q, did you mean %q?", m.key, suggestion)
+			} else {
+				v.report(m.offset, memberPath, "unknown field %q", m.key)
+			}
+		}
+	}
+
+	for _, required := range s.Required {
+		if !seen[required] {
+			v.report(n.offset, path, "missing required field %q", required)
+		}
+	}
+}
+
+// hasType reports whether the value has the schema type, integers being numbers without fraction
+func hasType(n *node, schemaType string) bool {
+	switch schemaType {
+	case "":
+		return true
+	case "integer":
+		if n.kind != "number" {
+			return false
+		}
+		number, err := n.value.(json.Number).Float64()
+		return err == nil && number == math.Trunc(number)
+	default:
+		return n.kind == schemaType
+	}
+}
+
+// article prefixes a JSON type with its indefinite article
+func article(kind string) string {
+	if strings.IndexByte("aeiou", kind[0]) >= 0 {
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+// childPath returns the JSON path of a key of an object
+func childPath(path, key string) string {
+	if identifierRegex.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+// suggest returns the known field closest to a misspelled key, or nothing if none is close
+func suggest(key string, properties map[string]*schema) string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if strings.EqualFold(name, key) || strings.HasPrefix(strings.ToLower(name), strings.ToLower(key)) {
+			return name
+		}
+		if distance := editDistance(strings.ToLower(key), strings.ToLower(name)); distance < bestDistance {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// position returns the line and column, both counted from 1, of an offset of the data
+func position(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// contains reports whether the values include the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
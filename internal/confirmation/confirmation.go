@@ -0,0 +1,254 @@
+package confirmation
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/fatih/color"
+)
+
+// System contracts receiving the execution layer requests
+var (
+	// WithdrawalRequestPredeploy is the EIP-7002 withdrawal request contract
+	WithdrawalRequestPredeploy = common.HexToAddress("0x00000961Ef480Eb55e80D19ad83579A64c007002")
+	// ConsolidationRequestPredeploy is the EIP-7251 consolidation request contract, also used for switches
+	ConsolidationRequestPredeploy = common.HexToAddress("0x0000BBdDc7CE488642fb579F8B00f3a590007251")
+)
+
+// Lengths of the request data logged by the system contracts: the source address followed by the
+// request calldata
+const (
+	pubkeyLength               = 48
+	withdrawalRequestLength    = common.AddressLength + pubkeyLength + 8
+	consolidationRequestLength = common.AddressLength + 2*pubkeyLength
+)
+
+// Request statuses
+const (
+	StatusConfirmed = "confirmed"
+	StatusFailed    = "failed"
+	StatusMissing   = "missing"
+)
+
+// Result is the outcome of a single validator request of a batch
+type Result struct {
+	Request calldata.Request
+	Status  string
+	// ReasonCode is the code of the failure event emitted by the batch contract, if any
+	ReasonCode *uint8
+}
+
+// systemRequest is a request logged by one of the system contracts
+type systemRequest struct {
+	kind         string
+	source       common.Address
+	sourcePubkey string
+	targetPubkey string
+	amount       uint64
+	matched      bool
+}
+
+// failure is a failure event emitted by the batch contract
+type failure struct {
+	sourcePubkey string
+	targetPubkey string
+	amount       uint64
+	reasonCode   uint8
+	matched      bool
+}
+
+// Check maps the logs of the receipt back to each request of the batch call made by the authority.
+// A request is confirmed when the matching system contract logged it, failed when the batch
+// contract emitted a failure event for it, and missing otherwise.
+func Check(contractABI abi.ABI, call *calldata.Call, receipt *types.Receipt, authority common.Address) ([]Result, error) {
+	var requests []*systemRequest
+	var failures []*failure
+
+	for _, log := range receipt.Logs {
+		switch log.Address {
+		case WithdrawalRequestPredeploy:
+			if len(log.Data) != withdrawalRequestLength {
+				return nil, fmt.Errorf("unexpected withdrawal request log of %d bytes", len(log.Data))
+			}
+			requests = append(requests, &systemRequest{
+				kind:         calldata.KindWithdrawal,
+				source:       common.BytesToAddress(log.Data[:common.AddressLength]),
+				sourcePubkey: common.Bytes2Hex(log.Data[common.AddressLength : common.AddressLength+pubkeyLength]),
+				amount:       binary.BigEndian.Uint64(log.Data[common.AddressLength+pubkeyLength:]),
+			})
+
+		case ConsolidationRequestPredeploy:
+			if len(log.Data) != consolidationRequestLength {
+				return nil, fmt.Errorf("unexpected consolidation request log of %d bytes", len(log.Data))
+			}
+			requests = append(requests, &systemRequest{
+				kind:         calldata.KindConsolidation,
+				source:       common.BytesToAddress(log.Data[:common.AddressLength]),
+				sourcePubkey: common.Bytes2Hex(log.Data[common.AddressLength : common.AddressLength+pubkeyLength]),
+				targetPubkey: common.Bytes2Hex(log.Data[common.AddressLength+pubkeyLength:]),
+			})
+
+		case authority:
+			// The batch contract code runs at the address of the delegated EOA
+			if len(log.Topics) == 0 {
+				continue
+			}
+			event, err := contractABI.EventByID(log.Topics[0])
+			if err != nil {
+				continue
+			}
+			f, err := decodeFailure(event, log.Data)
+			if err != nil {
+				return nil, err
+			}
+			if f != nil {
+				failures = append(failures, f)
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(call.Requests))
+	for _, request := range call.Requests {
+		result := Result{Request: request, Status: StatusMissing}
+
+		if matchRequest(requests, request, authority) {
+			result.Status = StatusConfirmed
+		} else if f := matchFailure(failures, request); f != nil {
+			result.Status = StatusFailed
+			reasonCode := f.reasonCode
+			result.ReasonCode = &reasonCode
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// decodeFailure decodes a failure event of the batch contract, returning nil for other events
+func decodeFailure(event *abi.Event, data []byte) (*failure, error) {
+	args, err := event.Inputs.Unpack(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", event.Name, err)
+	}
+
+	switch event.Name {
+	case "SwitchFailed":
+		pubkey := common.Bytes2Hex(args[1].([]byte))
+		return &failure{reasonCode: args[0].(uint8), sourcePubkey: pubkey, targetPubkey: pubkey}, nil
+	case "ConsolidationFailed":
+		return &failure{
+			reasonCode:   args[0].(uint8),
+			sourcePubkey: common.Bytes2Hex(args[1].([]byte)),
+			targetPubkey: common.Bytes2Hex(args[2].([]byte)),
+		}, nil
+	case "ExecutionLayerExitFailed":
+		return &failure{
+			reasonCode:   args[0].(uint8),
+			sourcePubkey: common.Bytes2Hex(args[1].([]byte)),
+			amount:       args[2].(uint64),
+		}, nil
+	}
+	return nil, nil
+}
+
+// matchRequest marks the first unmatched system contract request equal to the batch request
+func matchRequest(requests []*systemRequest, request calldata.Request, authority common.Address) bool {
+	kind := request.Kind
+	if kind == calldata.KindSwitch {
+		// A switch is a consolidation request of a validator to itself
+		kind = calldata.KindConsolidation
+	}
+
+	for _, logged := range requests {
+		if logged.matched || logged.kind != kind || logged.source != authority || logged.sourcePubkey != request.SourcePubkey {
+			continue
+		}
+		if kind == calldata.KindConsolidation && logged.targetPubkey != request.TargetPubkey {
+			continue
+		}
+		if kind == calldata.KindWithdrawal && logged.amount != request.Amount {
+			continue
+		}
+		logged.matched = true
+		return true
+	}
+	return false
+}
+
+// matchFailure returns the first unmatched failure event of the batch request
+func matchFailure(failures []*failure, request calldata.Request) *failure {
+	for _, f := range failures {
+		if f.matched || f.sourcePubkey != request.SourcePubkey {
+			continue
+		}
+		if request.Kind == calldata.KindWithdrawal && f.amount != request.Amount {
+			continue
+		}
+		if request.Kind != calldata.KindWithdrawal && f.targetPubkey != request.TargetPubkey {
+			continue
+		}
+		f.matched = true
+		return f
+	}
+	return nil
+}
+
+// Unconfirmed returns the source pubkeys of the requests that were not confirmed
+func Unconfirmed(results []Result) []string {
+	var pubkeys []string
+	for _, result := range results {
+		if result.Status != StatusConfirmed {
+			pubkeys = append(pubkeys, result.Request.SourcePubkey)
+		}
+	}
+	return pubkeys
+}
+
+// PrintTable prints the outcome of every validator request
+func PrintTable(results []Result) {
+	color.Cyan("Validator request confirmations:")
+	color.White("  %-4s %-13s %-98s %-10s %s", "#", "REQUEST", "VALIDATOR", "STATUS", "DETAILS")
+
+	for i, result := range results {
+		request := result.Request
+
+		details := ""
+		switch request.Kind {
+		case calldata.KindConsolidation:
+			details = "target " + shortPubkey(request.TargetPubkey)
+		case calldata.KindWithdrawal:
+			if request.FullExit {
+				details = "full exit"
+			} else {
+				details = fmt.Sprintf("%d Gwei", request.Amount)
+			}
+		}
+		if result.ReasonCode != nil {
+			details += fmt.Sprintf(" (reason code %d)", *result.ReasonCode)
+		}
+
+		line := fmt.Sprintf("  %-4d %-13s %-98s %-10s %s", i+1, request.Kind, "0x"+request.SourcePubkey, result.Status, strings.TrimSpace(details))
+		switch result.Status {
+		case StatusConfirmed:
+			color.Green("%s", line)
+		case StatusFailed:
+			color.Red("%s", line)
+		default:
+			color.Yellow("%s", line)
+		}
+	}
+}
+
+// shortPubkey abbreviates a validator public key for display
+func shortPubkey(pubkey string) string {
+	if len(pubkey) <= 16 {
+		return "0x" + pubkey
+	}
+	return "0x" + pubkey[:8] + "..." + pubkey[len(pubkey)-8:]
+}
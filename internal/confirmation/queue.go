@@ -0,0 +1,43 @@
+package confirmation
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Storage slots of the head and tail indices of the request queues of the system contracts
+var (
+	queueHeadSlot = common.BigToHash(big.NewInt(2))
+	queueTailSlot = common.BigToHash(big.NewInt(3))
+)
+
+// Predeploy returns the system contract receiving the requests of the kind
+func Predeploy(kind string) common.Address {
+	if kind == calldata.KindWithdrawal {
+		return WithdrawalRequestPredeploy
+	}
+	return ConsolidationRequestPredeploy
+}
+
+// QueueLength returns the number of requests waiting in the queue of a system contract
+func QueueLength(client rpc.Client, contract common.Address) (uint64, error) {
+	head, err := client.StorageAt(context.Background(), contract, queueHeadSlot, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the queue head of %s: %w", contract.Hex(), err)
+	}
+	tail, err := client.StorageAt(context.Background(), contract, queueTailSlot, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the queue tail of %s: %w", contract.Hex(), err)
+	}
+
+	headIndex, tailIndex := new(big.Int).SetBytes(head), new(big.Int).SetBytes(tail)
+	if tailIndex.Cmp(headIndex) <= 0 {
+		return 0, nil
+	}
+	return new(big.Int).Sub(tailIndex, headIndex).Uint64(), nil
+}
@@ -0,0 +1,274 @@
+package conflict
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/fatih/color"
+)
+
+// Severities of a conflict. Errors make the operation fail or waste its fees and are refused,
+// warnings are pointless but harmless combinations.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Sections of the config holding the validators of each operation
+const (
+	SectionSwitch      = "switch"
+	SectionConsolidate = "consolidate"
+	SectionELExit      = "elExit"
+)
+
+// Conflict is a contradictory or pointless request for a validator
+type Conflict struct {
+	Severity  string   `json:"severity"`
+	Validator string   `json:"validator"`
+	Sections  []string `json:"sections"`
+	Message   string   `json:"message"`
+}
+
+// Section returns the config section of the validators of a command, empty for commands without
+// one
+func Section(command string) string {
+	switch command {
+	case "switch":
+		return SectionSwitch
+	case "consolidate":
+		return SectionConsolidate
+	case "el-exit":
+		return SectionELExit
+	}
+	return ""
+}
+
+// roles is what the config requests for a validator
+type roles struct {
+	pubkey   string
+	switched bool
+	source   bool
+	target   bool
+	exit     bool
+	fullExit bool
+}
+
+// sections returns the sections listing the validator
+func (r *roles) sections() []string {
+	var sections []string
+	if r.switched {
+		sections = append(sections, SectionSwitch)
+	}
+	if r.source || r.target {
+		sections = append(sections, SectionConsolidate)
+	}
+	if r.exit {
+		sections = append(sections, SectionELExit)
+	}
+	return sections
+}
+
+// requests indexes the validators of the config by normalized public key, in the order they first
+// appear in the config
+func requests(cfg *config.Config) ([]string, map[string]*roles) {
+	var order []string
+	byPubkey := make(map[string]*roles)
+	get := func(pubkey string) *roles {
+		key := pectra.NormalizePubkey(pubkey)
+		r, ok := byPubkey[key]
+		if !ok {
+			r = &roles{pubkey: pubkey}
+			byPubkey[key] = r
+			order = append(order, key)
+		}
+		return r
+	}
+
+	for _, pubkey := range cfg.Switch.Validators {
+		get(pubkey).switched = true
+	}
+	if cfg.Consolidate.TargetValidator != "" {
+		get(cfg.Consolidate.TargetValidator).target = true
+	}
+	for _, pubkey := range cfg.Consolidate.SourceValidators {
+		get(pubkey).source = true
+	}
+	exits := make([]string, 0, len(cfg.ELExit.Validators))
+	for pubkey := range cfg.ELExit.Validators {
+		exits = append(exits, pubkey)
+	}
+	sort.Strings(exits)
+	for _, pubkey := range exits {
+		r := get(pubkey)
+		r.exit = true
+		r.fullExit = cfg.ELExit.Validators[pubkey].Amount == 0
+	}
+	return order, byPubkey
+}
+
+// relevant returns the conflicts concerning the section, all of them for an empty section
+func relevant(conflicts []Conflict, section string) []Conflict {
+	result := []Conflict{}
+	for _, c := range conflicts {
+		if section == "" || contains(c.Sections, section) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Analyze returns the conflicts between the switch, consolidate and elExit sections of the config
+// that concern the validators of the command, or all of them for an empty command
+func Analyze(cfg *config.Config, command string) []Conflict {
+	order, byPubkey := requests(cfg)
+
+	var conflicts []Conflict
+	add := func(r *roles, severity string, sections []string, format string, args ...interface{}) {
+		conflicts = append(conflicts, Conflict{Severity: severity, Validator: r.pubkey, Sections: sections, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, key := range order {
+		r := byPubkey[key]
+		switch {
+		case r.source && r.target:
+			add(r, SeverityError, []string{SectionConsolidate}, "is both a consolidation source and the target")
+		case r.source && r.fullExit:
+			add(r, SeverityError, []string{SectionConsolidate, SectionELExit}, "is a consolidation source and a full exit, the consolidation already exits it and the second request fails")
+		case r.target && r.fullExit:
+			add(r, SeverityError, []string{SectionConsolidate, SectionELExit}, "is the consolidation target and a full exit, an exiting validator cannot receive consolidations")
+		case r.source && r.exit:
+			add(r, SeverityError, []string{SectionConsolidate, SectionELExit}, "is a consolidation source and a partial withdrawal, a source with pending partial withdrawals cannot be consolidated")
+		case r.target && r.exit:
+			add(r, SeverityWarning, []string{SectionConsolidate, SectionELExit}, "is the consolidation target and a partial withdrawal, check the amount against the balance after the consolidation")
+		}
+
+		switch {
+		case r.switched && r.source:
+			add(r, SeverityWarning, []string{SectionSwitch, SectionConsolidate}, "is switched and a consolidation source, switching it is pointless as the consolidation exits it")
+		case r.switched && r.fullExit:
+			add(r, SeverityWarning, []string{SectionSwitch, SectionELExit}, "is switched and a full exit, switching it is pointless")
+		}
+	}
+
+	return relevant(conflicts, Section(command))
+}
+
+// AnalyzeBeacon returns the conflicts between the requests of the config and the beacon state,
+// for the validators of the command or of every section for an empty command
+func AnalyzeBeacon(cfg *config.Config, command string, beaconClient *beacon.Client) ([]Conflict, error) {
+	order, byPubkey := requests(cfg)
+	section := Section(command)
+
+	var pubkeys []string
+	for _, key := range order {
+		r := byPubkey[key]
+		if section == "" || contains(r.sections(), section) {
+			pubkeys = append(pubkeys, r.pubkey)
+		}
+	}
+	if len(pubkeys) == 0 {
+		return []Conflict{}, nil
+	}
+
+	validators, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the validators from the beacon node: %w", err)
+	}
+	found := make(map[string]beacon.Validator, len(validators))
+	for _, validator := range validators {
+		found[beacon.NormalizePubkey(validator.Validator.Pubkey)] = validator
+	}
+
+	pendingWithdrawals, err := beaconClient.GetPendingPartialWithdrawals()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the pending partial withdrawals: %w", err)
+	}
+	pendingByIndex := make(map[uint64]uint64)
+	for _, withdrawal := range pendingWithdrawals {
+		pendingByIndex[withdrawal.ValidatorIndex] += withdrawal.Amount
+	}
+	pendingConsolidations, err := beaconClient.GetPendingConsolidations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the pending consolidations: %w", err)
+	}
+	consolidationTargets := make(map[uint64]bool)
+	for _, consolidation := range pendingConsolidations {
+		consolidationTargets[consolidation.TargetIndex] = true
+	}
+
+	conflicts := []Conflict{}
+	add := func(r *roles, severity string, sections []string, format string, args ...interface{}) {
+		conflicts = append(conflicts, Conflict{Severity: severity, Validator: r.pubkey, Sections: sections, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, pubkey := range pubkeys {
+		r := byPubkey[pectra.NormalizePubkey(pubkey)]
+		sections := r.sections()
+		validator, ok := found[pectra.NormalizePubkey(pubkey)]
+		switch {
+		case !ok:
+			add(r, SeverityError, sections, "was not found in the beacon state")
+			continue
+		case validator.IsExiting():
+			add(r, SeverityError, sections, "is already exiting (status %s, exit epoch %d)", validator.Status, validator.Validator.ExitEpoch)
+			continue
+		case !validator.HasExecutionWithdrawalCredential():
+			add(r, SeverityError, sections, "has BLS withdrawal credentials, it cannot make execution layer requests")
+			continue
+		}
+
+		compounding := validator.WithdrawalPrefix() == beacon.CompoundingWithdrawalPrefix
+		if r.switched && compounding {
+			add(r, SeverityWarning, []string{SectionSwitch}, "already has 0x02 withdrawal credentials, switching it is pointless")
+		}
+		if r.exit && !r.fullExit && !compounding {
+			if r.switched {
+				add(r, SeverityError, []string{SectionSwitch, SectionELExit}, "has 0x01 withdrawal credentials, partial withdrawals are ignored until the switch to 0x02 is processed, run the switch first")
+			} else {
+				add(r, SeverityError, []string{SectionELExit}, "has 0x01 withdrawal credentials, partial withdrawals are only processed for 0x02 validators")
+			}
+		}
+		if r.fullExit && pendingByIndex[validator.Index] > 0 {
+			add(r, SeverityError, []string{SectionELExit}, "has %d Gwei of pending partial withdrawals, a full exit is ignored until they are processed", pendingByIndex[validator.Index])
+		}
+		if r.fullExit && consolidationTargets[validator.Index] {
+			add(r, SeverityWarning, []string{SectionELExit}, "is the target of a pending consolidation, the consolidated balance is withdrawn with the exit")
+		}
+	}
+
+	return relevant(conflicts, section), nil
+}
+
+// contains reports whether the sections include the section
+func contains(sections []string, section string) bool {
+	for _, s := range sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the number of conflicts refusing the operation
+func Errors(conflicts []Conflict) int {
+	count := 0
+	for _, c := range conflicts {
+		if c.Severity == SeverityError {
+			count++
+		}
+	}
+	return count
+}
+
+// Print prints the conflicts, errors in red and warnings in yellow
+func Print(conflicts []Conflict) {
+	for _, c := range conflicts {
+		if c.Severity == SeverityError {
+			color.Red("  - %s %s (%s)", c.Validator, c.Message, strings.Join(c.Sections, ", "))
+		} else {
+			color.Yellow("  - %s %s (%s)", c.Validator, c.Message, strings.Join(c.Sections, ", "))
+		}
+	}
+}
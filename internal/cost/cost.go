@@ -0,0 +1,313 @@
+package cost
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/confirmation"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/internal/transaction"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/fatih/color"
+)
+
+// Output formats of the report
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// feeFunctions maps the batch contract methods to the function returning their fee per request
+var feeFunctions = map[string]string{
+	pectra.MethodSwitch:        pectra.ConsolidationFeeFunction,
+	pectra.MethodConsolidation: pectra.ConsolidationFeeFunction,
+	pectra.MethodELExit:        pectra.ExitFeeFunction,
+}
+
+// Transaction is the gas estimate of a single SetCode transaction of the operation
+type Transaction struct {
+	Description string `json:"description"`
+	Gas         uint64 `json:"gas"`
+	// ExpectedCost is the gas cost (in wei) at the current base fee plus the suggested tip
+	ExpectedCost *big.Int `json:"expectedCostWei"`
+	// WorstCaseCost is the gas cost (in wei) if the whole max fee per gas is charged
+	WorstCaseCost *big.Int `json:"worstCaseCostWei"`
+}
+
+// Individual is the cost of sending every request directly to the system contract instead
+type Individual struct {
+	Predeploy       common.Address `json:"predeploy"`
+	GasPerRequest   uint64         `json:"gasPerRequest"`
+	Transactions    int            `json:"transactions"`
+	ExpectedCost    *big.Int       `json:"expectedCostWei"`
+	WorstCaseCost   *big.Int       `json:"worstCaseCostWei"`
+	ExpectedSavings *big.Int       `json:"expectedSavingsWei"`
+}
+
+// Report is the total cost of an operation
+type Report struct {
+	Operation  string         `json:"operation"`
+	ChainID    uint64         `json:"chainId"`
+	From       common.Address `json:"from"`
+	Validators int            `json:"validators"`
+
+	FeePerRequest *big.Int `json:"feePerRequestWei"`
+	RequestFees   *big.Int `json:"requestFeesWei"`
+
+	BaseFee  *big.Int      `json:"baseFeeWei"`
+	TipCap   *big.Int      `json:"tipCapWei"`
+	MaxFee   *big.Int      `json:"maxFeeWei"`
+	GasLimit uint64        `json:"gasLimit"`
+	Batched  []Transaction `json:"transactions"`
+	// ExpectedTotal and WorstCaseTotal add the request fees to the gas costs of all transactions
+	ExpectedTotal  *big.Int `json:"expectedTotalWei"`
+	WorstCaseTotal *big.Int `json:"worstCaseTotalWei"`
+	// RequiredBalance is what the node checks the sender balance against: the value plus the gas
+	// limit at the max fee, for each transaction
+	RequiredBalance *big.Int `json:"requiredBalanceWei"`
+
+	Individual Individual `json:"individual"`
+	Notes      []string   `json:"notes,omitempty"`
+}
+
+// Estimator computes the cost of batch calls made by an EOA delegated to the batch contract
+type Estimator struct {
+	Client          rpc.Client
+	ABI             abi.ABI
+	ContractAddress common.Address
+	From            common.Address
+}
+
+// Estimate computes the cost of the batch call. Nothing is signed, the delegation of the EOA is
+// simulated by overriding its code with the code of the batch contract.
+func (e *Estimator) Estimate(call *calldata.Call) (*Report, error) {
+	ctx := context.Background()
+
+	feeFunction, ok := feeFunctions[call.Method]
+	if !ok {
+		return nil, fmt.Errorf("method %s does not make validator requests", call.Method)
+	}
+
+	chainID, err := e.Client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the chain ID: %w", err)
+	}
+
+	fee, err := pectra.GetFee(ctx, e.Client, e.ABI, e.ContractAddress, feeFunction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the request fee: %w", err)
+	}
+
+	header, err := e.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the latest block: %w", err)
+	}
+	baseFee := header.BaseFee
+	if baseFee == nil {
+		baseFee = new(big.Int)
+	}
+	tipCap, err := e.Client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the gas tip cap: %w", err)
+	}
+	// The CLI uses the suggested gas price as the max fee per gas of its transactions
+	maxFee, err := e.Client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the gas price: %w", err)
+	}
+
+	count := int64(len(call.Requests))
+	report := &Report{
+		Operation:     call.Method,
+		ChainID:       chainID.Uint64(),
+		From:          e.From,
+		Validators:    len(call.Requests),
+		FeePerRequest: fee,
+		RequestFees:   new(big.Int).Mul(fee, big.NewInt(count)),
+		BaseFee:       baseFee,
+		TipCap:        tipCap,
+		MaxFee:        maxFee,
+		GasLimit:      pectra.DefaultGasLimit,
+	}
+	effectiveFee := minBig(new(big.Int).Add(baseFee, tipCap), maxFee)
+
+	data, err := calldata.Encode(e.ABI, call)
+	if err != nil {
+		return nil, err
+	}
+	code, err := e.Client.CodeAt(ctx, e.ContractAddress, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the code of the batch contract: %w", err)
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no contract deployed at %s", e.ContractAddress.Hex())
+	}
+
+	batchGas, err := e.Client.EstimateGasWithCode(ctx, ethereum.CallMsg{
+		From:  e.From,
+		To:    &e.From,
+		Data:  data,
+		Value: report.RequestFees,
+	}, e.From, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate the gas of the batch call: %w", err)
+	}
+	// The authorization is charged on top of the call, the refund for existing accounts is ignored
+	report.Batched = append(report.Batched, newTransaction("batch call with delegation", batchGas+params.CallNewAccountGas, effectiveFee, maxFee))
+
+	delegation, err := transaction.GetDelegation(e.Client, e.From)
+	if err != nil {
+		return nil, err
+	}
+	if delegation != nil && *delegation != e.ContractAddress {
+		report.Batched = append(report.Batched, newTransaction("restore delegation to "+delegation.Hex(), params.TxGas+params.CallNewAccountGas, effectiveFee, maxFee))
+		report.Notes = append(report.Notes, fmt.Sprintf("%s is delegated to %s, restoring it takes a second transaction", e.From.Hex(), delegation.Hex()))
+	}
+
+	report.ExpectedTotal = new(big.Int).Set(report.RequestFees)
+	report.WorstCaseTotal = new(big.Int).Set(report.RequestFees)
+	report.RequiredBalance = new(big.Int).Set(report.RequestFees)
+	for _, tx := range report.Batched {
+		report.ExpectedTotal.Add(report.ExpectedTotal, tx.ExpectedCost)
+		report.WorstCaseTotal.Add(report.WorstCaseTotal, tx.WorstCaseCost)
+		report.RequiredBalance.Add(report.RequiredBalance, new(big.Int).Mul(maxFee, new(big.Int).SetUint64(pectra.DefaultGasLimit)))
+	}
+
+	if err := e.estimateIndividual(report, call, effectiveFee); err != nil {
+		return nil, err
+	}
+	report.Notes = append(report.Notes, "request fees rise as the system contract queues fill, the fee is read at the latest block")
+
+	return report, nil
+}
+
+// estimateIndividual estimates sending each request in its own transaction to the system contract.
+// The gas of the first request is used for all of them.
+func (e *Estimator) estimateIndividual(report *Report, call *calldata.Call, effectiveFee *big.Int) error {
+	request := call.Requests[0]
+
+	predeploy := confirmation.ConsolidationRequestPredeploy
+	var data []byte
+	switch request.Kind {
+	case calldata.KindWithdrawal:
+		predeploy = confirmation.WithdrawalRequestPredeploy
+		data = append(common.FromHex(request.SourcePubkey), binary.BigEndian.AppendUint64(nil, request.Amount)...)
+	default:
+		data = append(common.FromHex(request.SourcePubkey), common.FromHex(request.TargetPubkey)...)
+	}
+
+	gas, err := e.Client.EstimateGas(context.Background(), ethereum.CallMsg{
+		From:  e.From,
+		To:    &predeploy,
+		Data:  data,
+		Value: report.FeePerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to estimate the gas of a request to %s: %w", predeploy.Hex(), err)
+	}
+
+	count := new(big.Int).SetUint64(uint64(len(call.Requests)))
+	report.Individual = Individual{
+		Predeploy:     predeploy,
+		GasPerRequest: gas,
+		Transactions:  len(call.Requests),
+		ExpectedCost:  new(big.Int).Add(report.RequestFees, gasCost(gas, effectiveFee, count)),
+		WorstCaseCost: new(big.Int).Add(report.RequestFees, gasCost(gas, report.MaxFee, count)),
+	}
+	report.Individual.ExpectedSavings = new(big.Int).Sub(report.Individual.ExpectedCost, report.ExpectedTotal)
+	return nil
+}
+
+// newTransaction prices the gas of a transaction at the expected and max fee per gas
+func newTransaction(description string, gas uint64, effectiveFee, maxFee *big.Int) Transaction {
+	one := big.NewInt(1)
+	return Transaction{
+		Description:   description,
+		Gas:           gas,
+		ExpectedCost:  gasCost(gas, effectiveFee, one),
+		WorstCaseCost: gasCost(gas, maxFee, one),
+	}
+}
+
+// gasCost returns the cost of count transactions using gas each at the fee per gas
+func gasCost(gas uint64, feePerGas, count *big.Int) *big.Int {
+	cost := new(big.Int).SetUint64(gas)
+	cost.Mul(cost, feePerGas)
+	return cost.Mul(cost, count)
+}
+
+// minBig returns the smaller of two big integers
+func minBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
+
+// Print writes the report in the given format
+func Print(report *Report, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case FormatTable, "":
+		printTable(report)
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s, expected %s or %s", format, FormatTable, FormatJSON)
+}
+
+// printTable prints the report as a table
+func printTable(report *Report) {
+	color.Cyan("Cost of %s for %d validator(s) from %s on chain %d:", report.Operation, report.Validators, report.From.Hex(), report.ChainID)
+	color.White("  %-36s %s", "Fee per request", formatWei(report.FeePerRequest))
+	color.White("  %-36s %s", "Request fees", formatWei(report.RequestFees))
+	color.White("  %-36s %s base fee, %s tip, %s max fee", "Fee per gas", formatGwei(report.BaseFee), formatGwei(report.TipCap), formatGwei(report.MaxFee))
+
+	color.Cyan("SetCode transactions:")
+	color.White("  %-36s %-10s %-24s %s", "TRANSACTION", "GAS", "EXPECTED", "WORST CASE")
+	for _, tx := range report.Batched {
+		color.White("  %-36s %-10d %-24s %s", tx.Description, tx.Gas, formatWei(tx.ExpectedCost), formatWei(tx.WorstCaseCost))
+	}
+
+	color.Green("  %-36s %s", "Expected total", formatWei(report.ExpectedTotal))
+	color.Yellow("  %-36s %s", "Worst-case total", formatWei(report.WorstCaseTotal))
+	color.Yellow("  %-36s %s (gas limit %d at the max fee)", "Required balance", formatWei(report.RequiredBalance), report.GasLimit)
+
+	individual := report.Individual
+	color.Cyan("Individual requests to %s:", individual.Predeploy.Hex())
+	color.White("  %-36s %d gas x %d transactions", "Gas", individual.GasPerRequest, individual.Transactions)
+	color.White("  %-36s %s", "Expected total", formatWei(individual.ExpectedCost))
+	color.White("  %-36s %s", "Worst-case total", formatWei(individual.WorstCaseCost))
+	if individual.ExpectedSavings.Sign() >= 0 {
+		color.Green("  %-36s %s", "Saved by batching", formatWei(individual.ExpectedSavings))
+	} else {
+		color.Yellow("  %-36s %s", "Extra cost of batching", formatWei(new(big.Int).Neg(individual.ExpectedSavings)))
+	}
+
+	for _, note := range report.Notes {
+		color.Yellow("Note: %s", note)
+	}
+}
+
+// formatWei formats an amount in wei together with its value in ETH
+func formatWei(wei *big.Int) string {
+	eth := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.Ether))
+	return fmt.Sprintf("%s wei (%s ETH)", wei, eth.Text('f', 6))
+}
+
+// formatGwei formats an amount in wei as Gwei
+func formatGwei(wei *big.Int) string {
+	gwei := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.GWei))
+	return gwei.Text('f', 3) + " Gwei"
+}
@@ -0,0 +1,321 @@
+package estimate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/confirmation"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/fatih/color"
+)
+
+// Electra churn and request processing parameters
+const (
+	// MinPerEpochChurnLimitElectra is the minimum balance churn (in Gwei) per epoch
+	MinPerEpochChurnLimitElectra = 128_000_000_000
+	// MaxPerEpochActivationExitChurnLimit caps the churn (in Gwei) used by activations and exits per epoch
+	MaxPerEpochActivationExitChurnLimit = 256_000_000_000
+	// ChurnLimitQuotient divides the total active balance into the balance churn per epoch
+	ChurnLimitQuotient = 65536
+	// MaxSeedLookahead delays exits to after the epochs whose committees are already known
+	MaxSeedLookahead = 4
+	// MinValidatorWithdrawabilityDelay is the number of epochs between exit and withdrawability
+	MinValidatorWithdrawabilityDelay = 256
+
+	// MaxWithdrawalRequestsPerBlock is the number of requests dequeued from the EIP-7002 contract per block
+	MaxWithdrawalRequestsPerBlock = 16
+	// MaxConsolidationRequestsPerBlock is the number of requests dequeued from the EIP-7251 contract per block
+	MaxConsolidationRequestsPerBlock = 2
+)
+
+// Request is a validator request to estimate
+type Request struct {
+	Kind   string
+	Pubkey string
+	// Amount is the requested partial withdrawal in Gwei
+	Amount   uint64
+	FullExit bool
+}
+
+// Estimate is the expected processing of a single request
+type Estimate struct {
+	Kind   string `json:"kind"`
+	Pubkey string `json:"pubkey"`
+	// DequeueSlots is the number of slots the request waits in the system contract queue
+	DequeueSlots uint64 `json:"dequeueSlots"`
+	// Balance is the balance (in Gwei) the request takes from the churn
+	Balance        uint64    `json:"balanceGwei,omitempty"`
+	ExitEpoch      uint64    `json:"exitEpoch,omitempty"`
+	ProcessedEpoch uint64    `json:"processedEpoch"`
+	ProcessedAt    time.Time `json:"processedAt"`
+	Note           string    `json:"note,omitempty"`
+}
+
+// churnQueue follows the earliest epoch and remaining balance of a churn-limited queue, like the
+// exit and consolidation queues of the beacon state
+type churnQueue struct {
+	earliestEpoch    uint64
+	balanceToConsume uint64
+	perEpochChurn    uint64
+}
+
+// consume returns the epoch at which the balance leaves the queue and updates the queue, following
+// compute_exit_epoch_and_update_churn and compute_consolidation_epoch_and_update_churn
+func (q *churnQueue) consume(currentEpoch, balance uint64) uint64 {
+	earliestEpoch := max(q.earliestEpoch, currentEpoch+1+MaxSeedLookahead)
+
+	balanceToConsume := q.balanceToConsume
+	if q.earliestEpoch < earliestEpoch {
+		balanceToConsume = q.perEpochChurn
+	}
+
+	if balance > balanceToConsume {
+		additionalEpochs := (balance-balanceToConsume-1)/q.perEpochChurn + 1
+		earliestEpoch += additionalEpochs
+		balanceToConsume += additionalEpochs * q.perEpochChurn
+	}
+
+	q.balanceToConsume = balanceToConsume - balance
+	q.earliestEpoch = earliestEpoch
+	return earliestEpoch
+}
+
+// State is the part of the beacon and execution state that drives request processing times
+type State struct {
+	Epoch              uint64
+	Genesis            time.Time
+	TotalActiveBalance uint64
+	ExitChurn          uint64
+	ConsolidationChurn uint64
+
+	// Requests waiting in the system contract queues
+	WithdrawalBacklog    uint64
+	ConsolidationBacklog uint64
+
+	exitQueue          churnQueue
+	consolidationQueue churnQueue
+	pendingPartials    map[uint64]uint64
+}
+
+// Churn returns the exit and consolidation churn limits (in Gwei) per epoch for the total active balance
+func Churn(totalActiveBalance uint64) (exitChurn, consolidationChurn uint64) {
+	balanceChurn := max(MinPerEpochChurnLimitElectra, totalActiveBalance/ChurnLimitQuotient)
+	balanceChurn -= balanceChurn % beacon.EffectiveBalanceIncrement
+
+	exitChurn = min(MaxPerEpochActivationExitChurnLimit, balanceChurn)
+	return exitChurn, balanceChurn - exitChurn
+}
+
+// LoadState reads the pending queues and active balance from the beacon node, and the system
+// contract queues from the RPC endpoints if a client is given. A nonzero totalActiveBalance is used
+// instead of the one computed from the balances of all validators.
+func LoadState(beaconClient *beacon.Client, client rpc.Client, totalActiveBalance uint64) (*State, error) {
+	state := &State{pendingPartials: make(map[uint64]uint64)}
+
+	var err error
+	if state.Genesis, err = beaconClient.GetGenesisTime(); err != nil {
+		return nil, err
+	}
+	if state.Epoch, err = beaconClient.GetCurrentEpoch(); err != nil {
+		return nil, err
+	}
+
+	state.TotalActiveBalance = totalActiveBalance
+	if state.TotalActiveBalance == 0 {
+		color.Cyan("Reading the balances of all validators, this can take a while...")
+		if state.TotalActiveBalance, err = beaconClient.GetTotalActiveBalance(); err != nil {
+			return nil, err
+		}
+	}
+	state.ExitChurn, state.ConsolidationChurn = Churn(state.TotalActiveBalance)
+	state.exitQueue.perEpochChurn = state.ExitChurn
+	state.consolidationQueue.perEpochChurn = state.ConsolidationChurn
+
+	// Consolidation sources exit through the consolidation churn, not the exit churn
+	consolidations, err := beaconClient.GetPendingConsolidations()
+	if err != nil {
+		return nil, err
+	}
+	consolidating := make(map[uint64]bool, len(consolidations))
+	sources := make([]uint64, 0, len(consolidations))
+	for _, consolidation := range consolidations {
+		consolidating[consolidation.SourceIndex] = true
+		sources = append(sources, consolidation.SourceIndex)
+	}
+	if len(sources) > 0 {
+		validators, err := beaconClient.GetValidatorsByIndex(sources)
+		if err != nil {
+			return nil, err
+		}
+		for _, validator := range validators {
+			state.consolidationQueue.add(validator.Validator.ExitEpoch, validator.Validator.EffectiveBalance)
+		}
+	}
+
+	exiting, err := beaconClient.GetValidatorsByStatus("active_exiting", "active_slashed")
+	if err != nil {
+		return nil, err
+	}
+	for _, validator := range exiting {
+		if !consolidating[validator.Index] {
+			state.exitQueue.add(validator.Validator.ExitEpoch, validator.Validator.EffectiveBalance)
+		}
+	}
+
+	partials, err := beaconClient.GetPendingPartialWithdrawals()
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		state.exitQueue.add(partial.WithdrawableEpoch-MinValidatorWithdrawabilityDelay, partial.Amount)
+		state.pendingPartials[partial.ValidatorIndex] += partial.Amount
+	}
+
+	if client != nil {
+		if state.WithdrawalBacklog, err = confirmation.QueueLength(client, confirmation.WithdrawalRequestPredeploy); err != nil {
+			return nil, err
+		}
+		if state.ConsolidationBacklog, err = confirmation.QueueLength(client, confirmation.ConsolidationRequestPredeploy); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// add accounts for a balance already scheduled to leave the queue at the epoch
+func (q *churnQueue) add(epoch, balance uint64) {
+	switch {
+	case epoch == beacon.FarFutureEpoch || epoch < q.earliestEpoch:
+		return
+	case epoch > q.earliestEpoch:
+		q.earliestEpoch = epoch
+		q.balanceToConsume = q.perEpochChurn
+	}
+	q.balanceToConsume -= min(balance, q.balanceToConsume)
+}
+
+// Estimate returns the expected processing of each request, in order, as if the batch were
+// included now. Validators are looked up by normalized pubkey.
+func (s *State) Estimate(requests []Request, validators map[string]*beacon.Validator) []Estimate {
+	exitQueue, consolidationQueue := s.exitQueue, s.consolidationQueue
+	withdrawals, consolidations := uint64(0), uint64(0)
+
+	pendingPartials := make(map[uint64]uint64, len(s.pendingPartials))
+	for index, amount := range s.pendingPartials {
+		pendingPartials[index] = amount
+	}
+
+	estimates := make([]Estimate, 0, len(requests))
+	for _, request := range requests {
+		estimate := Estimate{Kind: request.Kind, Pubkey: beacon.NormalizePubkey(request.Pubkey)}
+
+		// Requests leave the system contract queues a few per block, behind the current backlog
+		if request.Kind == calldata.KindWithdrawal {
+			estimate.DequeueSlots = (s.WithdrawalBacklog + withdrawals) / MaxWithdrawalRequestsPerBlock
+			withdrawals++
+		} else {
+			estimate.DequeueSlots = (s.ConsolidationBacklog + consolidations) / MaxConsolidationRequestsPerBlock
+			consolidations++
+		}
+		epoch := s.Epoch + estimate.DequeueSlots/beacon.SlotsPerEpoch
+
+		validator, ok := validators[estimate.Pubkey]
+		if !ok {
+			estimate.Note = "validator is unknown to the beacon node, the request would be ignored"
+			estimates = append(estimates, s.at(estimate, epoch))
+			continue
+		}
+		details := validator.Validator
+
+		switch {
+		case request.Kind == calldata.KindSwitch:
+			// Switches are applied as soon as the request reaches the beacon chain
+			estimate.Note = "credentials switch to 0x02 when the request is processed"
+			if validator.WithdrawalPrefix() == beacon.CompoundingWithdrawalPrefix {
+				estimate.Note = "already has 0x02 credentials"
+			} else if validator.Balance > beacon.MinActivationBalance {
+				estimate.Note += fmt.Sprintf(", the %d Gwei above 32 ETH is queued as a pending deposit", validator.Balance-beacon.MinActivationBalance)
+			}
+			estimates = append(estimates, s.at(estimate, epoch))
+
+		case request.Kind == calldata.KindConsolidation:
+			if s.ConsolidationChurn <= beacon.MinActivationBalance {
+				estimate.Note = "consolidation churn is too low, the request would be ignored"
+				estimates = append(estimates, s.at(estimate, epoch))
+				continue
+			}
+			estimate.Balance = details.EffectiveBalance
+			estimate.ExitEpoch = consolidationQueue.consume(epoch, details.EffectiveBalance)
+			estimate.Note = "balance moves to the target when the source becomes withdrawable"
+			estimates = append(estimates, s.at(estimate, estimate.ExitEpoch+MinValidatorWithdrawabilityDelay))
+
+		case request.FullExit:
+			if pendingPartials[validator.Index] > 0 {
+				estimate.Note = "validator has pending partial withdrawals, the full exit would be ignored"
+				estimates = append(estimates, s.at(estimate, epoch))
+				continue
+			}
+			estimate.Balance = details.EffectiveBalance
+			estimate.ExitEpoch = exitQueue.consume(epoch, details.EffectiveBalance)
+			estimate.Note = "withdrawable epoch, the balance is then paid out by the withdrawal sweep"
+			estimates = append(estimates, s.at(estimate, estimate.ExitEpoch+MinValidatorWithdrawabilityDelay))
+
+		default:
+			if validator.WithdrawalPrefix() != beacon.CompoundingWithdrawalPrefix {
+				estimate.Note = "partial withdrawals require 0x02 credentials, the request would be ignored"
+				estimates = append(estimates, s.at(estimate, epoch))
+				continue
+			}
+
+			excess := uint64(0)
+			if validator.Balance > beacon.MinActivationBalance+pendingPartials[validator.Index] {
+				excess = validator.Balance - beacon.MinActivationBalance - pendingPartials[validator.Index]
+			}
+			amount := min(request.Amount, excess)
+			if amount == 0 {
+				estimate.Note = "no balance above 32 ETH to withdraw, the request would be ignored"
+				estimates = append(estimates, s.at(estimate, epoch))
+				continue
+			}
+			if amount < request.Amount {
+				estimate.Note = fmt.Sprintf("capped to the %d Gwei above 32 ETH", amount)
+			}
+			pendingPartials[validator.Index] += amount
+
+			estimate.Balance = amount
+			estimate.ExitEpoch = exitQueue.consume(epoch, amount)
+			estimates = append(estimates, s.at(estimate, estimate.ExitEpoch+MinValidatorWithdrawabilityDelay))
+		}
+	}
+
+	return estimates
+}
+
+// at sets the epoch and time at which the request is processed
+func (s *State) at(estimate Estimate, epoch uint64) Estimate {
+	estimate.ProcessedEpoch = epoch
+	estimate.ProcessedAt = beacon.EpochTime(s.Genesis, epoch).UTC()
+	return estimate
+}
+
+// Print prints the churn state and the expected processing of each request
+func (s *State) Print(estimates []Estimate) {
+	color.Cyan("Current epoch: %d", s.Epoch)
+	color.Cyan("Total active balance: %d ETH", s.TotalActiveBalance/beacon.EffectiveBalanceIncrement)
+	color.Cyan("Exit churn: %d ETH per epoch, exit queue until epoch %d", s.ExitChurn/beacon.EffectiveBalanceIncrement, max(s.exitQueue.earliestEpoch, s.Epoch))
+	color.Cyan("Consolidation churn: %d ETH per epoch, consolidation queue until epoch %d", s.ConsolidationChurn/beacon.EffectiveBalanceIncrement, max(s.consolidationQueue.earliestEpoch, s.Epoch))
+	color.Cyan("System contract backlog: %d withdrawal request(s), %d consolidation request(s)", s.WithdrawalBacklog, s.ConsolidationBacklog)
+
+	color.White("  %-13s %-98s %-10s %-10s %-21s %s", "REQUEST", "VALIDATOR", "EXIT", "PROCESSED", "TIME", "NOTE")
+	for _, estimate := range estimates {
+		exitEpoch := "-"
+		if estimate.ExitEpoch != 0 {
+			exitEpoch = fmt.Sprintf("%d", estimate.ExitEpoch)
+		}
+		eta := time.Until(estimate.ProcessedAt).Round(time.Minute)
+		color.White("  %-13s %-98s %-10s %-10d %-21s %s", estimate.Kind, estimate.Pubkey, exitEpoch, estimate.ProcessedEpoch, estimate.ProcessedAt.Format("2006-01-02 15:04 MST"), fmt.Sprintf("(in %s) %s", eta, estimate.Note))
+	}
+}
@@ -0,0 +1,152 @@
+package group
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
+)
+
+// Statuses of a group once it was run
+const (
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Group is the validators of an operation sharing a withdrawal address, with the config running
+// the operation for them only
+type Group struct {
+	Address    common.Address `json:"withdrawalAddress"`
+	Validators []string       `json:"validators"`
+	Config     *config.Config `json:"-"`
+}
+
+// Result is the outcome of the operation for a group
+type Result struct {
+	Address    common.Address `json:"withdrawalAddress"`
+	Validators int            `json:"validators"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// ByWithdrawalAddress resolves the withdrawal address of every validator of the operation from
+// the beacon state and splits the config into one config per address, in the order the addresses
+// first appear. Consolidation sources keep the configured target.
+func ByWithdrawalAddress(cfg *config.Config, command string, beaconClient *beacon.Client) ([]Group, error) {
+	pubkeys, err := operationValidators(cfg, command)
+	if err != nil {
+		return nil, err
+	}
+
+	validators, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the validators from the beacon node: %w", err)
+	}
+	byPubkey := make(map[string]beacon.Validator, len(validators))
+	for _, validator := range validators {
+		byPubkey[beacon.NormalizePubkey(validator.Validator.Pubkey)] = validator
+	}
+
+	var groups []Group
+	index := make(map[common.Address]int)
+	var problems []string
+	for _, pubkey := range pubkeys {
+		validator, ok := byPubkey[beacon.NormalizePubkey(pubkey)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("validator %s was not found in the beacon state", pubkey))
+			continue
+		}
+		if !validator.HasExecutionWithdrawalCredential() {
+			problems = append(problems, fmt.Sprintf("validator %s has BLS withdrawal credentials, it has no withdrawal address", pubkey))
+			continue
+		}
+
+		address := validator.WithdrawalAddress()
+		i, ok := index[address]
+		if !ok {
+			i = len(groups)
+			index[address] = i
+			groups = append(groups, Group{Address: address})
+		}
+		groups[i].Validators = append(groups[i].Validators, pubkey)
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			color.Red("  - %s", problem)
+		}
+		return nil, fmt.Errorf("the withdrawal address of %d validator(s) could not be resolved", len(problems))
+	}
+
+	for i := range groups {
+		groups[i].Config = groupConfig(cfg, command, groups[i].Validators)
+	}
+	return groups, nil
+}
+
+// operationValidators returns the validators the operation makes requests for
+func operationValidators(cfg *config.Config, command string) ([]string, error) {
+	switch command {
+	case "switch":
+		return cfg.Switch.Validators, nil
+	case "consolidate":
+		return cfg.Consolidate.SourceValidators, nil
+	case "el-exit":
+		pubkeys := make([]string, 0, len(cfg.ELExit.Validators))
+		for pubkey := range cfg.ELExit.Validators {
+			pubkeys = append(pubkeys, pubkey)
+		}
+		sort.Strings(pubkeys)
+		return pubkeys, nil
+	default:
+		return nil, fmt.Errorf("validators of the %s command cannot be grouped", command)
+	}
+}
+
+// groupConfig returns a copy of the config with the operation limited to the validators
+func groupConfig(cfg *config.Config, command string, validators []string) *config.Config {
+	grouped := *cfg
+	switch command {
+	case "switch":
+		grouped.Switch = config.SwitchConfig{Validators: validators}
+	case "consolidate":
+		grouped.Consolidate = config.ConsolidateConfig{
+			SourceValidators: validators,
+			TargetValidator:  cfg.Consolidate.TargetValidator,
+		}
+	case "el-exit":
+		exits := make(map[string]config.ELExitDetails, len(validators))
+		for _, pubkey := range validators {
+			exits[pubkey] = cfg.ELExit.Validators[pubkey]
+		}
+		grouped.ELExit = config.ELExitConfig{Validators: exits}
+	}
+	return &grouped
+}
+
+// Print prints the groups found in the config
+func Print(groups []Group) {
+	color.Cyan("%d withdrawal address(es):", len(groups))
+	for _, g := range groups {
+		color.White("  %s: %d validator(s)", g.Address.Hex(), len(g.Validators))
+	}
+}
+
+// PrintResults prints the outcome of the operation for each group
+func PrintResults(results []Result) {
+	color.Cyan("Results per withdrawal address:")
+	for _, result := range results {
+		switch result.Status {
+		case StatusDone:
+			color.Green("  %s %-8s %d validator(s)", result.Address.Hex(), result.Status, result.Validators)
+		case StatusFailed:
+			color.Red("  %s %-8s %d validator(s): %s", result.Address.Hex(), result.Status, result.Validators, result.Error)
+		default:
+			color.Yellow("  %s %-8s %d validator(s): %s", result.Address.Hex(), result.Status, result.Validators, result.Error)
+		}
+	}
+}
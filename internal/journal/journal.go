@@ -0,0 +1,254 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// DefaultPath is the journal file used when none is given on the command line
+const DefaultPath = "pectra_journal.jsonl"
+
+// Transaction statuses recorded in the journal
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusFailed    = "failed"
+	StatusRejected  = "rejected"
+	StatusReplaced  = "replaced"
+)
+
+// Entry records the state of a sent transaction. The journal is append-only, the latest entry for a
+// transaction hash holds its current status.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Run identifies the run of the CLI that sent the transaction, resumed runs keep the run they resume
+	Run       string         `json:"run,omitempty"`
+	Operation string         `json:"operation"`
+	ChainID   uint64         `json:"chainId"`
+	From      common.Address `json:"from"`
+	// Validators are the validators the transaction makes requests for, the sources for consolidations
+	Validators []string `json:"validators,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	// AmountsGwei are the withdrawal amounts of the validators, in the same order, for withdrawals
+	AmountsGwei []uint64 `json:"amountsGwei,omitempty"`
+	// Unconfirmed are the validators whose request did not reach the system contracts although the
+	// transaction succeeded
+	Unconfirmed []string      `json:"unconfirmed,omitempty"`
+	Nonce       uint64        `json:"nonce"`
+	TxHash      common.Hash   `json:"txHash"`
+	RawTx       hexutil.Bytes `json:"rawTx"`
+	Status      string        `json:"status"`
+	BlockNumber uint64        `json:"blockNumber,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Journal is a JSON lines file recording every transaction sent by the CLI, so that an
+// interrupted run can be resumed
+type Journal struct {
+	path string
+	run  string
+}
+
+// Open returns the journal stored at path for a new run, the file is created on the first write
+func Open(path string) *Journal {
+	if path == "" {
+		path = DefaultPath
+	}
+	return &Journal{path: path, run: NewRun()}
+}
+
+// NewRun returns the ID of a run starting now, its start time
+func NewRun() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// Run returns the ID of the run the journal records transactions for
+func (j *Journal) Run() string {
+	return j.run
+}
+
+// WithRun returns the journal recording transactions for the given run, to resume it
+func (j *Journal) WithRun(run string) *Journal {
+	return &Journal{path: j.path, run: run}
+}
+
+// Path returns the path of the journal file
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Record appends an entry to the journal and syncs it to disk
+func (j *Journal) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.Run == "" {
+		entry.Run = j.run
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal %s: %w", j.path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	return file.Sync()
+}
+
+// Entries returns the latest entry of every transaction in the journal, in the order the
+// transactions were first recorded
+func (j *Journal) Entries() ([]Entry, error) {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", j.path, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	positions := make(map[common.Hash]int)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("invalid journal entry at %s:%d: %w", j.path, lineNumber, err)
+		}
+
+		if position, ok := positions[entry.TxHash]; ok {
+			entries[position] = entry
+			continue
+		}
+		positions[entry.TxHash] = len(entries)
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %w", j.path, err)
+	}
+
+	return entries, nil
+}
+
+// Find returns the latest entry of the transaction, or nil if it is not in the journal
+func (j *Journal) Find(hash common.Hash) (*Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.TxHash == hash {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// Pending returns the transactions on the chain that have not been confirmed, failed or replaced yet
+func (j *Journal) Pending(chainID uint64) ([]Entry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Entry
+	for _, entry := range entries {
+		if entry.ChainID == chainID && entry.Status == StatusPending {
+			pending = append(pending, entry)
+		}
+	}
+	return pending, nil
+}
+
+// LatestRun returns the run of the latest transaction of the operation on the chain, or an empty
+// string if the journal has none
+func (j *Journal) LatestRun(chainID uint64, operation string) (string, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return "", err
+	}
+
+	run := ""
+	var latest time.Time
+	for _, entry := range entries {
+		if entry.ChainID == chainID && entry.Operation == operation && !entry.Time.Before(latest) {
+			run, latest = entry.Run, entry.Time
+		}
+	}
+	return run, nil
+}
+
+// ConfirmedRequest is a request confirmed in the journal
+type ConfirmedRequest struct {
+	TxHash common.Hash
+	// AmountGwei is the withdrawal amount of the request, 0 for full exits and other operations
+	AmountGwei uint64
+}
+
+// Confirmed returns the validators with a confirmed request of the operation on the chain in the
+// given run, mapped to the confirmed request. For consolidations only requests to the given target
+// count.
+func (j *Journal) Confirmed(chainID uint64, run, operation, target string) (map[string]ConfirmedRequest, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed := make(map[string]ConfirmedRequest)
+	for _, entry := range entries {
+		if entry.ChainID != chainID || entry.Run != run || entry.Operation != operation || entry.Status != StatusConfirmed {
+			continue
+		}
+		if target != "" && !strings.EqualFold(normalize(entry.Target), normalize(target)) {
+			continue
+		}
+		unconfirmed := make(map[string]bool)
+		for _, validator := range entry.Unconfirmed {
+			unconfirmed[normalize(validator)] = true
+		}
+		for i, validator := range entry.Validators {
+			if unconfirmed[normalize(validator)] {
+				continue
+			}
+			request := ConfirmedRequest{TxHash: entry.TxHash}
+			if i < len(entry.AmountsGwei) {
+				request.AmountGwei = entry.AmountsGwei[i]
+			}
+			confirmed[normalize(validator)] = request
+		}
+	}
+	return confirmed, nil
+}
+
+// IsConfirmed reports whether the validator is in a set returned by Confirmed
+func IsConfirmed(confirmed map[string]ConfirmedRequest, validator string) (ConfirmedRequest, bool) {
+	request, ok := confirmed[normalize(validator)]
+	return request, ok
+}
+
+// normalize formats a validator public key for comparison
+func normalize(pubkey string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(pubkey), "0x"))
+}
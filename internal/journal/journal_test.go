@@ -0,0 +1,74 @@
+package journal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	testChainID   = 560048
+	testValidator = "0xb5a2635ef8d420a0c5d23341c638dd11a500aefa8f7d9fc1f726edbf8163f4e0b727f47faa57b91af50c13e863f13142"
+)
+
+// TestConfirmedRun checks that only the requests confirmed in the resumed run are skipped, so a
+// withdrawal confirmed by an earlier run does not hide a new one
+func TestConfirmedRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	earlier := Open(path).WithRun("earlier")
+	current := Open(path).WithRun("current")
+
+	record := func(j *Journal, hash common.Hash, amountGwei uint64, at time.Time) {
+		t.Helper()
+		entry := Entry{
+			Time:        at,
+			Operation:   "batchELExit",
+			ChainID:     testChainID,
+			Validators:  []string{testValidator},
+			AmountsGwei: []uint64{amountGwei},
+			TxHash:      hash,
+			Status:      StatusConfirmed,
+		}
+		if err := j.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Date(2025, 5, 7, 0, 0, 0, 0, time.UTC)
+	record(earlier, common.HexToHash("0x01"), 1_000_000_000, start)
+	record(current, common.HexToHash("0x02"), 2_000_000_000, start.Add(time.Hour))
+
+	run, err := current.LatestRun(testChainID, "batchELExit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run != "current" {
+		t.Fatalf("LatestRun = %q, want %q", run, "current")
+	}
+
+	tests := []struct {
+		run        string
+		confirmed  bool
+		hash       common.Hash
+		amountGwei uint64
+	}{
+		{run: "earlier", confirmed: true, hash: common.HexToHash("0x01"), amountGwei: 1_000_000_000},
+		{run: "current", confirmed: true, hash: common.HexToHash("0x02"), amountGwei: 2_000_000_000},
+		{run: "new", confirmed: false},
+	}
+	for _, test := range tests {
+		confirmed, err := current.Confirmed(testChainID, test.run, "batchELExit", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, ok := IsConfirmed(confirmed, testValidator[2:])
+		if ok != test.confirmed {
+			t.Errorf("run %s: confirmed = %v, want %v", test.run, ok, test.confirmed)
+			continue
+		}
+		if ok && (request.TxHash != test.hash || request.AmountGwei != test.amountGwei) {
+			t.Errorf("run %s: confirmed %s with %d Gwei, want %s with %d Gwei", test.run, request.TxHash.Hex(), request.AmountGwei, test.hash.Hex(), test.amountGwei)
+		}
+	}
+}
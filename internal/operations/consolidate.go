@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"math/big"
 
-	"github.com/Luganodes/Pectra-CLI/internal/transaction"
-	"github.com/Luganodes/Pectra-CLI/internal/utils"
-	"github.com/ethereum/go-ethereum/common"
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
 	"github.com/fatih/color"
-	"github.com/holiman/uint256"
 )
 
 // ConsolidateOperation represents a batch consolidation operation
@@ -19,65 +18,136 @@ type ConsolidateOperation struct {
 	AmountPerValidator *big.Int
 }
 
-// Execute performs the batch consolidation operation
-func (op *ConsolidateOperation) Execute() error {
-	if len(op.SourceValidators) == 0 || op.TargetValidator == "" {
-		return fmt.Errorf("source or target validators not specified for consolidate operation")
+// batch returns the requests of the operation
+func (op *ConsolidateOperation) batch() pectra.ConsolidationBatch {
+	return pectra.ConsolidationBatch{Sources: pectra.RemoveDuplicatePubkeys(op.SourceValidators), Target: op.TargetValidator}
+}
+
+// Validate implements Operation
+func (op *ConsolidateOperation) Validate() error {
+	// Positions are reported before duplicates are dropped, as they are in the config
+	if err := pectra.ValidatePubkeys(op.SourceValidators); err != nil {
+		return fmt.Errorf("invalid public key in consolidate.sourceValidators: %w", err)
 	}
+	op.SourceValidators = removeDuplicates("consolidate.sourceValidators", op.SourceValidators)
 
-	op.SourceValidators = utils.RemoveDuplicateValidators(op.SourceValidators)
+	if err := op.batch().Validate(); err != nil {
+		return err
+	}
 
-	if len(op.SourceValidators) > 63 {
-		return fmt.Errorf("a maximum of 63 validators can be consolidated at a time")
+	if op.Beacon == nil {
+		color.Yellow("No beacon node available (beaconUrl not set or offline mode), skipping consolidation eligibility checks against beacon state")
+		return nil
 	}
+	return op.checkEligibility()
+}
 
-	// Validate source validator public keys
-	if err := utils.ValidateValidatorPubkeys(op.SourceValidators); err != nil {
-		return fmt.Errorf("invalid source validator public key: %w", err)
+// Build implements Operation
+func (op *ConsolidateOperation) Build() (*pectra.Call, error) {
+	return pectra.BuildCall(op.ABI, op.batch(), feeOrDefault(op.AmountPerValidator))
+}
+
+// Describe implements Operation
+func (op *ConsolidateOperation) Describe() Description {
+	batch := op.batch()
+	requests := make([]Request, 0, len(batch.Sources))
+	for _, source := range batch.Sources {
+		requests = append(requests, Request{Kind: calldata.KindConsolidation, Pubkey: source, Target: batch.Target})
 	}
+	return op.describe("consolidate", batch, feeOrDefault(op.AmountPerValidator), requests)
+}
+
+// checkEligibility validates the source and target validators against the beacon state
+// and reports the projected balance of the target validator
+func (op *ConsolidateOperation) checkEligibility() error {
+	color.Cyan("Checking consolidation eligibility against beacon state...")
 
-	// Validate target validator public key
-	if err := utils.ValidateValidatorPubkeys([]string{op.TargetValidator}); err != nil {
-		return fmt.Errorf("invalid target validator public key: %w", err)
+	epoch, err := op.Beacon.GetCurrentEpoch()
+	if err != nil {
+		return err
 	}
 
-	// Check if target validator is in source validators
-	for _, sourceValidator := range op.SourceValidators {
-		if sourceValidator == op.TargetValidator {
-			return fmt.Errorf("target validator (%s) cannot be in the list of source validators", op.TargetValidator)
+	validators, err := op.Beacon.GetValidators(append([]string{op.TargetValidator}, op.SourceValidators...))
+	if err != nil {
+		return err
+	}
+	byPubkey := make(map[string]beacon.Validator, len(validators))
+	for _, validator := range validators {
+		byPubkey[beacon.NormalizePubkey(validator.Validator.Pubkey)] = validator
+	}
+
+	pendingWithdrawals, err := op.Beacon.GetPendingPartialWithdrawals()
+	if err != nil {
+		return err
+	}
+	pendingByIndex := make(map[uint64]uint64)
+	for _, withdrawal := range pendingWithdrawals {
+		pendingByIndex[withdrawal.ValidatorIndex] += withdrawal.Amount
+	}
+
+	problems := []string{}
+
+	// checkCommon runs the checks shared by the source and target validators
+	checkCommon := func(role string, validator beacon.Validator) {
+		pubkey := validator.Validator.Pubkey
+		if !validator.HasExecutionWithdrawalCredential() {
+			problems = append(problems, fmt.Sprintf("%s %s does not have execution (0x01/0x02) withdrawal credentials", role, pubkey))
+		}
+		if !validator.IsActive(epoch) {
+			problems = append(problems, fmt.Sprintf("%s %s is not active (status: %s)", role, pubkey, validator.Status))
+		} else if epoch < validator.Validator.ActivationEpoch+beacon.ShardCommitteePeriod {
+			problems = append(problems, fmt.Sprintf("%s %s has not passed the shard committee period (eligible at epoch %d, current epoch %d)",
+				role, pubkey, validator.Validator.ActivationEpoch+beacon.ShardCommitteePeriod, epoch))
 		}
+		if validator.IsExiting() {
+			problems = append(problems, fmt.Sprintf("%s %s is already exiting (exit epoch %d)", role, pubkey, validator.Validator.ExitEpoch))
+		}
+		if validator.Validator.Slashed {
+			problems = append(problems, fmt.Sprintf("%s %s is slashed", role, pubkey))
+		}
+	}
+
+	target, ok := byPubkey[beacon.NormalizePubkey(op.TargetValidator)]
+	if !ok {
+		return fmt.Errorf("target validator %s was not found in the beacon state", op.TargetValidator)
+	}
+	checkCommon("target", target)
+	if target.WithdrawalPrefix() != beacon.CompoundingWithdrawalPrefix {
+		problems = append(problems, fmt.Sprintf("target %s does not have 0x02 withdrawal credentials, switch it before consolidating", target.Validator.Pubkey))
 	}
+	withdrawalAddress := target.WithdrawalAddress()
 
-	// Use provided amount or default to 1
-	amountPerValidator := op.AmountPerValidator
-	if amountPerValidator == nil {
-		color.Yellow("Amount per validator is not set, using default value of 1")
-		amountPerValidator = big.NewInt(1)
+	projectedBalance := target.Balance
+	for _, pubkey := range op.SourceValidators {
+		source, ok := byPubkey[beacon.NormalizePubkey(pubkey)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("source %s was not found in the beacon state", pubkey))
+			continue
+		}
+		checkCommon("source", source)
+		if source.WithdrawalAddress() != withdrawalAddress {
+			problems = append(problems, fmt.Sprintf("source %s has withdrawal address %s, but the target has %s",
+				source.Validator.Pubkey, source.WithdrawalAddress().Hex(), withdrawalAddress.Hex()))
+		}
+		if pending := pendingByIndex[source.Index]; pending > 0 {
+			problems = append(problems, fmt.Sprintf("source %s has %d Gwei of pending partial withdrawals", source.Validator.Pubkey, pending))
+		}
+		projectedBalance += source.Balance
 	}
 
-	pubkeys := [][]byte{}
-	for _, validator := range op.SourceValidators {
-		pubkeys = append(pubkeys, common.FromHex(validator))
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			color.Red("  - %s", problem)
+		}
+		return fmt.Errorf("%d consolidation eligibility check(s) failed", len(problems))
 	}
-	target := common.FromHex(op.TargetValidator)
 
-	data, err := op.ABI.Pack("batchConsolidation", pubkeys, target)
-	if err != nil {
-		return fmt.Errorf("failed to pack the data: %w", err)
+	color.Green("All validators are eligible for consolidation into withdrawal address %s", withdrawalAddress.Hex())
+	color.Cyan("Projected target balance after consolidation: %d Gwei", projectedBalance)
+	if projectedBalance > beacon.MaxEffectiveBalanceElectra {
+		color.Yellow("Warning: projected target balance exceeds 2048 ETH, the surplus of %d Gwei will be swept to %s",
+			projectedBalance-beacon.MaxEffectiveBalanceElectra, withdrawalAddress.Hex())
 	}
 
-	value := new(big.Int)
-	value.Mul(big.NewInt(int64(len(pubkeys))), amountPerValidator)
-	color.Cyan("Sending transaction with value: %v (for %d validators at %d each)",
-		value, len(pubkeys), amountPerValidator)
-
-	return transaction.SendTransactionUsingAuthorization(
-		op.Client,
-		op.PrivateKey,
-		op.ContractAddress,
-		data,
-		uint256.NewInt(uint64(value.Int64())),
-		op.ExplorerUrl,
-		op.Airgapped,
-	)
+	return nil
 }
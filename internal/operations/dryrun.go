@@ -0,0 +1,138 @@
+package operations
+
+import (
+	"fmt"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/transaction"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
+	"github.com/holiman/uint256"
+)
+
+// Effect is the expected result of a request for a validator once it is processed
+type Effect struct {
+	Pubkey string `json:"pubkey"`
+	Effect string `json:"effect"`
+}
+
+// DryRunReport is what a dry run found out about an operation
+type DryRunReport struct {
+	Description Description             `json:"description"`
+	Transaction *transaction.Simulation `json:"transaction"`
+	Effects     []Effect                `json:"effects"`
+}
+
+// Prepared is an operation validated and built into the call sent by the delegated EOA
+type Prepared struct {
+	Call        *pectra.Call
+	Value       *uint256.Int
+	Description Description
+	Effects     []Effect
+}
+
+// Prepare validates and builds the operation and works out the effect of each of its requests
+func Prepare(op Operation) (*Prepared, error) {
+	if err := op.Validate(); err != nil {
+		return nil, err
+	}
+
+	call, err := op.Build()
+	if err != nil {
+		return nil, err
+	}
+	value, overflow := uint256.FromBig(call.Value)
+	if overflow {
+		return nil, fmt.Errorf("value %s overflows", call.Value)
+	}
+
+	// The effects are read back from the packed calldata, so they show what the contract will receive
+	effects, err := DecodeEffects(op.Base().ABI, call.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Prepared{
+		Call:        call,
+		Value:       value,
+		Description: op.Describe(),
+		Effects:     effects,
+	}, nil
+}
+
+// DryRun validates and builds the operation and simulates the transaction sent by the EOA from,
+// without signing or writing anything
+func DryRun(op Operation, from common.Address) error {
+	prepared, err := Prepare(op)
+	if err != nil {
+		return err
+	}
+
+	base := op.Base()
+	var simulation *transaction.Simulation
+	if base.Offline != nil {
+		simulation, err = transaction.SimulateOffline(base.Offline, base.ContractAddress, prepared.Value)
+	} else {
+		simulation, err = transaction.Simulate(base.Client, from, base.ContractAddress, prepared.Call.Data, prepared.Value)
+	}
+	if err != nil {
+		return output.WithCode(output.CodeValidation, err)
+	}
+
+	report := DryRunReport{
+		Description: prepared.Description,
+		Transaction: simulation,
+		Effects:     prepared.Effects,
+	}
+	report.Description.Print()
+	simulation.Print()
+	PrintEffects(report.Effects)
+	output.SetFrom(simulation.From)
+	output.SetData(report)
+
+	if simulation.Balance != nil && simulation.Balance.Cmp(simulation.RequiredBalance) < 0 {
+		color.Yellow("The balance of %s does not cover the value and the gas at the current max fee", from.Hex())
+	}
+	color.Green("Dry run: nothing was signed, sent or written")
+	return nil
+}
+
+// PrintEffects prints the expected effect of each request
+func PrintEffects(effects []Effect) {
+	color.Cyan("Expected effects:")
+	for _, effect := range effects {
+		color.White("  %s %s", effect.Pubkey, effect.Effect)
+	}
+}
+
+// DecodeEffects decodes batch contract calldata and describes what each request does to its validator
+func DecodeEffects(contractABI abi.ABI, data []byte) ([]Effect, error) {
+	call, err := calldata.Decode(contractABI, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the batch calldata: %w", err)
+	}
+	return effects(call), nil
+}
+
+// effects describes what each decoded request does to its validator
+func effects(call *calldata.Call) []Effect {
+	result := make([]Effect, 0, len(call.Requests))
+	for _, request := range call.Requests {
+		var effect string
+		switch {
+		case request.Kind == calldata.KindSwitch:
+			effect = "withdrawal credentials switch from 0x01 to 0x02 (compounding)"
+		case request.Kind == calldata.KindConsolidation:
+			effect = fmt.Sprintf("balance moves to %s, then the validator exits", request.TargetPubkey)
+		case request.FullExit:
+			effect = "full exit, the balance is withdrawn to the withdrawal address"
+		default:
+			effect = fmt.Sprintf("partial withdrawal of %d Gwei to the withdrawal address", request.Amount)
+		}
+		result = append(result, Effect{Pubkey: request.SourcePubkey, Effect: effect})
+	}
+	return result
+}
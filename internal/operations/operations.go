@@ -2,11 +2,18 @@ package operations
 
 import (
 	"crypto/ecdsa"
+	"fmt"
 	"math/big"
 
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/journal"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/internal/transaction"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/fatih/color"
 	"github.com/holiman/uint256"
 )
 
@@ -17,23 +24,186 @@ type ELExitData struct {
 	ConfirmFullExit bool
 }
 
-// Operation defines the interface for all validator operations
+// Operation defines the interface for all validator operations. Operations only describe the
+// batch contract call, Execute sends it the same way for all of them.
 type Operation interface {
-	Execute() error
+	// Validate checks the requests, and the validators against the beacon state when available
+	Validate() error
+	// Build packs the batch contract call with the value paying the request fees
+	Build() (*pectra.Call, error)
+	// Describe summarizes what the operation requests
+	Describe() Description
+	// Base returns the settings used to send the call
+	Base() *BaseOperation
+}
+
+// Request is a single validator request of an operation
+type Request struct {
+	Kind       string `json:"kind"`
+	Pubkey     string `json:"pubkey"`
+	Target     string `json:"target,omitempty"`
+	AmountGwei uint64 `json:"amountGwei,omitempty"`
+	FullExit   bool   `json:"fullExit,omitempty"`
+}
+
+// Description is a structured summary of an operation
+type Description struct {
+	Operation     string         `json:"operation"`
+	Method        string         `json:"method"`
+	Contract      common.Address `json:"contract"`
+	Requests      []Request      `json:"requests"`
+	FeePerRequest *big.Int       `json:"feePerRequestWei"`
+	Value         *big.Int       `json:"valueWei"`
+}
+
+// Print prints the description
+func (d Description) Print() {
+	color.Cyan("%s: %s on %s with %d request(s)", d.Operation, d.Method, d.Contract.Hex(), len(d.Requests))
+	for i, request := range d.Requests {
+		details := ""
+		switch {
+		case request.Target != "" && request.Target != request.Pubkey:
+			details = "to " + request.Target
+		case request.FullExit:
+			details = "full exit"
+		case request.AmountGwei > 0:
+			details = fmt.Sprintf("%d Gwei", request.AmountGwei)
+		}
+		color.White("  %-4d %-13s %s %s", i+1, request.Kind, request.Pubkey, details)
+	}
+	color.Cyan("Value: %v wei (for %d validators at %v each)", d.Value, len(d.Requests), d.FeePerRequest)
+}
+
+// removeDuplicates drops the validators listed more than once in a list of the config, warning
+// with their positions in the list
+func removeDuplicates(list string, pubkeys []string) []string {
+	for _, duplicate := range pectra.DuplicatePubkeys(pubkeys) {
+		color.Yellow("%s #%d %s duplicates #%d, the validator is only requested once", list, duplicate.Position, duplicate.Pubkey, duplicate.FirstPosition)
+	}
+	return pectra.RemoveDuplicatePubkeys(pubkeys)
+}
+
+// Execute validates and builds the operation, then sends its call
+func Execute(op Operation) error {
+	if err := op.Validate(); err != nil {
+		return err
+	}
+
+	call, err := op.Build()
+	if err != nil {
+		return err
+	}
+
+	description := op.Describe()
+	description.Print()
+	output.SetData(description)
+
+	return op.Base().sendCall(call)
+}
+
+// Base implements Operation for the operations embedding BaseOperation
+func (op *BaseOperation) Base() *BaseOperation {
+	return op
 }
 
 // BaseOperation contains common fields for all operations
 type BaseOperation struct {
-	Client          *ethclient.Client
+	Client          rpc.Client
 	PrivateKey      *ecdsa.PrivateKey
 	ContractAddress common.Address
 	ABI             abi.ABI
 	ExplorerUrl     string
 	Airgapped       bool
+	Beacon          *beacon.Client
+	// Sponsored makes SponsorKey pay for the transaction, the EOA only signs the authorization
+	Sponsored  bool
+	SponsorKey *ecdsa.PrivateKey
+	// AuthorizationOnly makes airgapped mode produce only the authorization to be signed offline
+	AuthorizationOnly bool
+	// Offline holds the chain data used instead of Client in offline mode
+	Offline *transaction.NetworkSnapshot
+	// From is the withdrawal EOA when it is known without a key, it is prompted for in airgapped mode otherwise
+	From common.Address
+	// Journal records the transactions sent
+	Journal *journal.Journal
+	// OutputDir is the directory the files of airgapped and offline mode are written to
+	OutputDir string
+}
+
+// transactionOptions returns the options of the transaction functions sending or writing the call
+func (op *BaseOperation) transactionOptions() transaction.Options {
+	return transaction.Options{
+		ExplorerURL: op.ExplorerUrl,
+		Journal:     op.Journal,
+		OutputDir:   op.OutputDir,
+	}
+}
+
+// Send sends the call to the delegated EOA, paid either by the EOA itself or by the sponsor. In
+// airgapped mode it writes either the unsigned transaction or, when only the authorization is signed
+// offline, the authorization request.
+func (op *BaseOperation) Send(data []byte, value *uint256.Int) error {
+	opts := op.transactionOptions()
+	switch {
+	case op.Offline != nil:
+		return transaction.PrepareOfflineTransaction(op.Offline, op.From, op.ContractAddress, data, value, op.AuthorizationOnly, op.Sponsored, opts)
+	case op.Airgapped && (op.AuthorizationOnly || op.Sponsored):
+		return transaction.PrepareAuthorizationRequest(op.Client, op.From, op.ContractAddress, data, value, op.Sponsored, opts)
+	case op.Sponsored:
+		return transaction.SendSponsoredTransaction(
+			op.Client,
+			op.PrivateKey,
+			op.SponsorKey,
+			op.ContractAddress,
+			data,
+			value,
+			opts,
+		)
+	default:
+		return transaction.SendTransactionUsingAuthorization(
+			op.Client,
+			op.PrivateKey,
+			op.From,
+			op.ContractAddress,
+			data,
+			value,
+			op.Airgapped,
+			opts,
+		)
+	}
+}
+
+// feeOrDefault returns the fee paid for each request, 1 wei if it is not set
+func feeOrDefault(fee *big.Int) *big.Int {
+	if fee == nil {
+		return big.NewInt(1)
+	}
+	return fee
+}
+
+// sendCall sends the packed batch call with the fees of its requests
+func (op *BaseOperation) sendCall(call *pectra.Call) error {
+	value, overflow := uint256.FromBig(call.Value)
+	if overflow {
+		return fmt.Errorf("value %s overflows", call.Value)
+	}
+	return op.Send(call.Data, value)
+}
+
+// describe builds the description of a batch paying fee for each request
+func (op *BaseOperation) describe(operation string, batch pectra.Batch, fee *big.Int, requests []Request) Description {
+	return Description{
+		Operation:     operation,
+		Method:        batch.Method(),
+		Contract:      op.ContractAddress,
+		Requests:      requests,
+		FeePerRequest: fee,
+		Value:         new(big.Int).Mul(fee, big.NewInt(int64(batch.Len()))),
+	}
 }
 
 // SendTransaction sends a transaction with the given data and value
-func SendTransaction(client *ethclient.Client, privateKey *ecdsa.PrivateKey,
+func SendTransaction(client rpc.Client, privateKey *ecdsa.PrivateKey,
 	contract common.Address, data []byte, value *uint256.Int) error {
 	// Implementation will be in transaction package
 	return nil
@@ -4,11 +4,8 @@ import (
 	"fmt"
 	"math/big"
 
-	"github.com/Luganodes/Pectra-CLI/internal/transaction"
-	"github.com/Luganodes/Pectra-CLI/internal/utils"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/fatih/color"
-	"github.com/holiman/uint256"
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
 )
 
 // SwitchOperation represents a batch switch operation
@@ -18,52 +15,32 @@ type SwitchOperation struct {
 	AmountPerValidator *big.Int
 }
 
-// Execute performs the batch switch operation
-func (op *SwitchOperation) Execute() error {
-	if len(op.Validators) == 0 {
-		return fmt.Errorf("no validators specified for switch operation")
-	}
-
-	op.Validators = utils.RemoveDuplicateValidators(op.Validators)
-
-	if len(op.Validators) > 200 {
-		return fmt.Errorf("a maximum of 200 validators can be switched at a time")
-	}
-
-	// Validate source validator public keys
-	if err := utils.ValidateValidatorPubkeys(op.Validators); err != nil {
-		return fmt.Errorf("invalid source validator public key: %w", err)
-	}
+// batch returns the requests of the operation
+func (op *SwitchOperation) batch() pectra.SwitchBatch {
+	return pectra.SwitchBatch{Validators: pectra.RemoveDuplicatePubkeys(op.Validators)}
+}
 
-	// Use provided amount or default to 1
-	amountPerValidator := op.AmountPerValidator
-	if amountPerValidator == nil {
-		color.Yellow("Amount per validator is not set, using default value of 1")
-		amountPerValidator = big.NewInt(1)
+// Validate implements Operation
+func (op *SwitchOperation) Validate() error {
+	// Positions are reported before duplicates are dropped, as they are in the config
+	if err := pectra.ValidatePubkeys(op.Validators); err != nil {
+		return fmt.Errorf("invalid public key in switch.validators: %w", err)
 	}
+	op.Validators = removeDuplicates("switch.validators", op.Validators)
+	return op.batch().Validate()
+}
 
-	pubkeys := [][]byte{}
-	for _, validator := range op.Validators {
-		pubkeys = append(pubkeys, common.FromHex(validator))
-	}
+// Build implements Operation
+func (op *SwitchOperation) Build() (*pectra.Call, error) {
+	return pectra.BuildCall(op.ABI, op.batch(), feeOrDefault(op.AmountPerValidator))
+}
 
-	data, err := op.ABI.Pack("batchSwitch", pubkeys)
-	if err != nil {
-		return fmt.Errorf("failed to pack the data: %w", err)
+// Describe implements Operation
+func (op *SwitchOperation) Describe() Description {
+	batch := op.batch()
+	requests := make([]Request, 0, len(batch.Validators))
+	for _, validator := range batch.Validators {
+		requests = append(requests, Request{Kind: calldata.KindSwitch, Pubkey: validator, Target: validator})
 	}
-
-	value := new(big.Int)
-	value.Mul(big.NewInt(int64(len(op.Validators))), amountPerValidator)
-	color.Cyan("Sending transaction with value: %v (for %d validators at %d each)",
-		value, len(op.Validators), amountPerValidator)
-
-	return transaction.SendTransactionUsingAuthorization(
-		op.Client,
-		op.PrivateKey,
-		op.ContractAddress,
-		data,
-		uint256.NewInt(uint64(value.Int64())),
-		op.ExplorerUrl,
-		op.Airgapped,
-	)
+	return op.describe("switch", batch, feeOrDefault(op.AmountPerValidator), requests)
 }
@@ -0,0 +1,202 @@
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
+)
+
+// Output modes selected with the global --output flag
+const (
+	ModeText = "text"
+	ModeJSON = "json"
+)
+
+// SchemaVersion is the version of the JSON result. It changes only when fields are removed or
+// change meaning, new fields may be added at any time.
+const SchemaVersion = 1
+
+// Error codes of the JSON result
+const (
+	CodeError         = "ERROR"
+	CodeUsage         = "USAGE"
+	CodeConfig        = "CONFIG_INVALID"
+	CodeRPC           = "RPC_UNAVAILABLE"
+	CodeBeacon        = "BEACON_UNAVAILABLE"
+	CodeChainMismatch = "CHAIN_MISMATCH"
+	CodeKey           = "KEY_INVALID"
+	CodeValidation    = "VALIDATION_FAILED"
+	CodeRejected      = "TX_REJECTED"
+	CodeReverted      = "TX_FAILED"
+	CodeReplaced      = "TX_REPLACED"
+	CodePending       = "TX_PENDING"
+	CodeUnconfirmed   = "REQUESTS_UNCONFIRMED"
+)
+
+// Transaction is a transaction sent or prepared by the command
+type Transaction struct {
+	Hash        common.Hash    `json:"hash"`
+	From        common.Address `json:"from"`
+	Nonce       uint64         `json:"nonce"`
+	Status      string         `json:"status"`
+	BlockNumber uint64         `json:"blockNumber,omitempty"`
+	GasUsed     uint64         `json:"gasUsed,omitempty"`
+	// FeePaid is the gas fee in wei, without the value sent
+	FeePaid *big.Int `json:"feePaidWei,omitempty"`
+}
+
+// Validator is the outcome of the request of a single validator
+type Validator struct {
+	Kind       string `json:"kind"`
+	Pubkey     string `json:"pubkey"`
+	Target     string `json:"target,omitempty"`
+	AmountGwei uint64 `json:"amountGwei,omitempty"`
+	FullExit   bool   `json:"fullExit,omitempty"`
+	Status     string `json:"status"`
+	ReasonCode *uint8 `json:"reasonCode,omitempty"`
+	TxHash     string `json:"txHash,omitempty"`
+}
+
+// ErrorResult is the error of a failed command
+type ErrorResult struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Result is the single JSON document written to stdout by every command in JSON mode
+type Result struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Command       string          `json:"command"`
+	Operation     string          `json:"operation,omitempty"`
+	ChainID       uint64          `json:"chainId,omitempty"`
+	From          *common.Address `json:"from,omitempty"`
+	Transactions  []Transaction   `json:"transactions"`
+	Validators    []Validator     `json:"validators"`
+	Files         []string        `json:"files"`
+	// Data holds the command specific result, such as the cost or watch report
+	Data  interface{}  `json:"data,omitempty"`
+	Error *ErrorResult `json:"error"`
+}
+
+var (
+	mode   = ModeText
+	result = Result{SchemaVersion: SchemaVersion, Transactions: []Transaction{}, Validators: []Validator{}, Files: []string{}}
+)
+
+// Setup selects the output mode. In JSON mode all human readable output goes to stderr, so that
+// stdout only holds the result.
+func Setup(outputMode string) error {
+	switch outputMode {
+	case ModeText, "":
+		mode = ModeText
+	case ModeJSON:
+		mode = ModeJSON
+		color.Output = color.Error
+	default:
+		return WithCode(CodeUsage, fmt.Errorf("unknown output mode %s, expected text or json", outputMode))
+	}
+	return nil
+}
+
+// JSON reports whether the result is written as JSON
+func JSON() bool {
+	return mode == ModeJSON
+}
+
+// SetCommand records the command being run
+func SetCommand(command string) {
+	result.Command = command
+}
+
+// SetOperation records the validator operation of the command
+func SetOperation(operation string) {
+	result.Operation = operation
+}
+
+// SetChain records the chain the command runs against
+func SetChain(chainID uint64) {
+	result.ChainID = chainID
+}
+
+// SetFrom records the EOA the command acts for
+func SetFrom(from common.Address) {
+	result.From = &from
+}
+
+// SetData records the command specific result
+func SetData(data interface{}) {
+	result.Data = data
+}
+
+// AddFile records a file written by the command
+func AddFile(path string) {
+	result.Files = append(result.Files, path)
+}
+
+// AddValidators records the outcome of validator requests
+func AddValidators(validators ...Validator) {
+	result.Validators = append(result.Validators, validators...)
+}
+
+// RecordTransaction adds the transaction to the result, or replaces it if it was recorded before
+func RecordTransaction(tx Transaction) {
+	for i := range result.Transactions {
+		if result.Transactions[i].Hash == tx.Hash {
+			result.Transactions[i] = tx
+			return
+		}
+	}
+	result.Transactions = append(result.Transactions, tx)
+}
+
+// Emit writes the result with the error of the command, if any, to stdout
+func Emit(err error) error {
+	if err != nil {
+		result.Error = &ErrorResult{Code: Code(err), Message: err.Error()}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// codedError attaches an error code to an error
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// WithCode attaches the error code to the error. Errors that already carry a code keep it, so
+// that the code set closest to the failure wins.
+func WithCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return &codedError{code: code, err: err}
+}
+
+// Code returns the error code attached to the error, CodeError if there is none
+func Code(err error) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return CodeError
+}
@@ -0,0 +1,412 @@
+package plan
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/operations"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/internal/transaction"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
+	"github.com/holiman/uint256"
+)
+
+// Version is the version of the plan file format
+const Version = 2
+
+// Tolerances bound how far the chain and beacon state may move between plan and apply. They are
+// given to apply and never read from the plan file, so editing the plan cannot loosen them.
+type Tolerances struct {
+	// GasHeadroomPercent bounds the planned max fee to the gas price at plan time plus this
+	// percentage. Apply also refuses once the gas price exceeds the planned max fee.
+	GasHeadroomPercent uint64
+	// BalanceGwei is how far the balance of a validator may move from the planned one
+	BalanceGwei uint64
+	// ValidFor is how long after it was created the plan can be applied
+	ValidFor time.Duration
+}
+
+// ValidatorState is the beacon state of a validator when the plan was made
+type ValidatorState struct {
+	Pubkey                string `json:"pubkey"`
+	Index                 uint64 `json:"index"`
+	Status                string `json:"status"`
+	WithdrawalCredentials string `json:"withdrawalCredentials"`
+	BalanceGwei           uint64 `json:"balanceGwei"`
+}
+
+// Plan is an operation fixed in advance for approval: the exact transaction to send, the state it
+// was planned against and the expected effect on each validator
+type Plan struct {
+	Version     int                            `json:"version"`
+	CreatedAt   time.Time                      `json:"createdAt"`
+	Network     string                         `json:"network"`
+	Operation   string                         `json:"operation"`
+	Description operations.Description         `json:"description"`
+	Transaction transaction.PlannedTransaction `json:"transaction"`
+	Snapshot    *transaction.NetworkSnapshot   `json:"snapshot"`
+	Validators  []ValidatorState               `json:"validators"`
+	Effects     []operations.Effect            `json:"effects"`
+}
+
+// Create plans the operation sent by the EOA from. The call is simulated and the chain and beacon
+// state it depends on is recorded, so that apply can refuse if it changed. The max fee of the
+// transaction is the current gas price plus gasHeadroomPercent.
+func Create(op operations.Operation, command, network string, from common.Address, beaconClient *beacon.Client, gasHeadroomPercent uint64) (*Plan, error) {
+	prepared, err := operations.Prepare(op)
+	if err != nil {
+		return nil, err
+	}
+
+	base := op.Base()
+	simulation, err := transaction.Simulate(base.Client, from, base.ContractAddress, prepared.Call.Data, prepared.Value)
+	if err != nil {
+		return nil, output.WithCode(output.CodeValidation, err)
+	}
+	if simulation.Balance.Cmp(simulation.RequiredBalance) < 0 {
+		color.Yellow("The balance of %s (%s wei) does not cover the value and the gas at the current max fee (%s wei)", from.Hex(), simulation.Balance, simulation.RequiredBalance)
+	}
+
+	snapshot, err := transaction.TakeSnapshot(base.Client, from, base.ContractAddress)
+	if err != nil {
+		return nil, err
+	}
+	setRequestFee(snapshot, prepared.Call.Method, prepared.Call.FeePerRequest)
+
+	validators, err := validatorStates(beaconClient, prepared.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plan{
+		Version:     Version,
+		CreatedAt:   snapshot.CreatedAt,
+		Network:     network,
+		Operation:   command,
+		Description: prepared.Description,
+		Transaction: transaction.PlannedTransaction{
+			ChainID:              snapshot.ChainID,
+			From:                 from,
+			Nonce:                snapshot.Nonce,
+			MaxFeePerGas:         withHeadroom(snapshot.MaxFeePerGas, gasHeadroomPercent),
+			MaxPriorityFeePerGas: snapshot.MaxPriorityFeePerGas,
+			Gas:                  pectra.DefaultGasLimit,
+			Contract:             base.ContractAddress,
+			Data:                 prepared.Call.Data,
+			Value:                prepared.Call.Value,
+		},
+		Snapshot:   snapshot,
+		Validators: validators,
+		Effects:    prepared.Effects,
+	}, nil
+}
+
+// withHeadroom returns the gas price plus the given percentage
+func withHeadroom(gasPrice *big.Int, percent uint64) *big.Int {
+	maxFee := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(100+percent))
+	return maxFee.Div(maxFee, big.NewInt(100))
+}
+
+// Verify checks the plan against the current chain and beacon state and simulates the planned
+// transaction again. It returns an error listing every difference beyond the tolerances.
+func (p *Plan) Verify(client rpc.Client, contractABI abi.ABI, beaconClient *beacon.Client, now time.Time, tolerances Tolerances) error {
+	if p.Version != Version {
+		return fmt.Errorf("unsupported plan version %d, expected %d", p.Version, Version)
+	}
+	if err := p.checkConsistency(contractABI); err != nil {
+		return fmt.Errorf("the plan is inconsistent: %w", err)
+	}
+
+	var drifts []string
+	if expiresAt := p.CreatedAt.Add(tolerances.ValidFor); now.After(expiresAt) {
+		drifts = append(drifts, fmt.Sprintf("the plan expired at %s", expiresAt.Format(time.RFC3339)))
+	}
+	if maxFee := withHeadroom(p.Snapshot.MaxFeePerGas, tolerances.GasHeadroomPercent); p.Transaction.MaxFeePerGas.Cmp(maxFee) > 0 {
+		drifts = append(drifts, fmt.Sprintf("the planned max fee of %s wei exceeds the gas price at plan time plus %d%% (%s wei)", p.Transaction.MaxFeePerGas, tolerances.GasHeadroomPercent, maxFee))
+	}
+
+	tx := p.Transaction
+	current, err := transaction.TakeSnapshot(client, tx.From, tx.Contract)
+	if err != nil {
+		return err
+	}
+	drifts = append(drifts, p.chainDrifts(current)...)
+
+	fee, err := pectra.GetFee(context.Background(), client, contractABI, tx.Contract, feeFunction(p.Description.Method))
+	if err != nil {
+		return fmt.Errorf("failed to get the request fee: %w", err)
+	}
+	if fee.Cmp(p.Description.FeePerRequest) > 0 {
+		drifts = append(drifts, fmt.Sprintf("the request fee rose from %s to %s wei", p.Description.FeePerRequest, fee))
+	}
+
+	validatorDrifts, err := p.validatorDrifts(beaconClient, tolerances.BalanceGwei)
+	if err != nil {
+		return err
+	}
+	drifts = append(drifts, validatorDrifts...)
+
+	if len(drifts) > 0 {
+		for _, drift := range drifts {
+			color.Red("  %s", drift)
+		}
+		return fmt.Errorf("the current state no longer matches the plan (%d difference(s))", len(drifts))
+	}
+
+	value, overflow := uint256.FromBig(tx.Value)
+	if overflow {
+		return fmt.Errorf("value %s overflows", tx.Value)
+	}
+	if _, err := transaction.Simulate(client, tx.From, tx.Contract, tx.Data, value); err != nil {
+		return err
+	}
+
+	color.Green("The current state matches the plan")
+	return nil
+}
+
+// checkConsistency makes sure the planned transaction is the one described by the plan, so that a
+// plan edited after approval is refused
+func (p *Plan) checkConsistency(contractABI abi.ABI) error {
+	tx := p.Transaction
+	if p.Snapshot == nil {
+		return fmt.Errorf("the snapshot is missing")
+	}
+	if tx.ChainID == nil || p.Snapshot.ChainID == nil || tx.ChainID.Cmp(p.Snapshot.ChainID) != 0 {
+		return fmt.Errorf("the transaction and the snapshot are for different chains")
+	}
+	if tx.From != p.Snapshot.Address || tx.Nonce != p.Snapshot.Nonce {
+		return fmt.Errorf("the transaction sender or nonce differs from the snapshot")
+	}
+	if tx.Contract != p.Snapshot.Contract || tx.Contract != p.Description.Contract {
+		return fmt.Errorf("the transaction delegates to a different contract than the one planned")
+	}
+	if tx.Value == nil || p.Description.Value == nil || tx.Value.Cmp(p.Description.Value) != 0 {
+		return fmt.Errorf("the transaction value differs from the planned request fees")
+	}
+	if tx.MaxFeePerGas == nil || p.Snapshot.MaxFeePerGas == nil {
+		return fmt.Errorf("the max fee of the transaction or the gas price of the snapshot is missing")
+	}
+
+	effects, err := operations.DecodeEffects(contractABI, tx.Data)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(effects, p.Effects) {
+		return fmt.Errorf("the transaction data does not make the planned requests")
+	}
+	return nil
+}
+
+// chainDrifts compares the current chain state with the planned snapshot
+func (p *Plan) chainDrifts(current *transaction.NetworkSnapshot) []string {
+	var drifts []string
+	planned := p.Snapshot
+
+	if current.ChainID.Cmp(planned.ChainID) != 0 {
+		drifts = append(drifts, fmt.Sprintf("the chain ID is %s, the plan is for %s", current.ChainID, planned.ChainID))
+	}
+	if current.Nonce != planned.Nonce {
+		drifts = append(drifts, fmt.Sprintf("the nonce of %s is %d, the plan uses %d", planned.Address.Hex(), current.Nonce, planned.Nonce))
+	}
+	if !sameAddress(current.Delegation, planned.Delegation) {
+		drifts = append(drifts, fmt.Sprintf("the delegation of %s is %s, it was %s", planned.Address.Hex(), formatDelegation(current.Delegation), formatDelegation(planned.Delegation)))
+	}
+	if current.ContractCodeHash == nil || planned.ContractCodeHash == nil || *current.ContractCodeHash != *planned.ContractCodeHash {
+		drifts = append(drifts, fmt.Sprintf("the code of the batch contract at %s changed", planned.Contract.Hex()))
+	}
+	if current.MaxFeePerGas.Cmp(p.Transaction.MaxFeePerGas) > 0 {
+		drifts = append(drifts, fmt.Sprintf("the gas price is %s wei, above the planned max fee of %s wei", current.MaxFeePerGas, p.Transaction.MaxFeePerGas))
+	}
+	return drifts
+}
+
+// validatorDrifts compares the current beacon state of the validators with the planned one
+func (p *Plan) validatorDrifts(beaconClient *beacon.Client, balanceToleranceGwei uint64) ([]string, error) {
+	pubkeys := make([]string, 0, len(p.Validators))
+	for _, state := range p.Validators {
+		pubkeys = append(pubkeys, state.Pubkey)
+	}
+	validators, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the validators from the beacon node: %w", err)
+	}
+	current := make(map[string]beacon.Validator, len(validators))
+	for _, validator := range validators {
+		current[beacon.NormalizePubkey(validator.Validator.Pubkey)] = validator
+	}
+
+	var drifts []string
+	for _, planned := range p.Validators {
+		validator, ok := current[beacon.NormalizePubkey(planned.Pubkey)]
+		if !ok {
+			drifts = append(drifts, fmt.Sprintf("validator %s is no longer known to the beacon node", planned.Pubkey))
+			continue
+		}
+		if validator.Status != planned.Status {
+			drifts = append(drifts, fmt.Sprintf("validator %s is %s, it was %s", planned.Pubkey, validator.Status, planned.Status))
+		}
+		if !strings.EqualFold(validator.Validator.WithdrawalCredentials, planned.WithdrawalCredentials) {
+			drifts = append(drifts, fmt.Sprintf("the withdrawal credentials of validator %s changed to %s", planned.Pubkey, validator.Validator.WithdrawalCredentials))
+		}
+		diff := validator.Balance - planned.BalanceGwei
+		if validator.Balance < planned.BalanceGwei {
+			diff = planned.BalanceGwei - validator.Balance
+		}
+		if diff > balanceToleranceGwei {
+			drifts = append(drifts, fmt.Sprintf("the balance of validator %s moved from %d to %d Gwei", planned.Pubkey, planned.BalanceGwei, validator.Balance))
+		}
+	}
+	return drifts, nil
+}
+
+// Print prints the plan for review
+func (p *Plan) Print() {
+	tx := p.Transaction
+	p.Description.Print()
+	color.Cyan("Transaction:")
+	color.White("  %-24s %s (%s)", "Network", p.Network, tx.ChainID)
+	color.White("  %-24s %s", "From / to", tx.From.Hex())
+	color.White("  %-24s %d", "Nonce", tx.Nonce)
+	color.White("  %-24s %s", "Delegate to", tx.Contract.Hex())
+	color.White("  %-24s %s wei", "Value", tx.Value)
+	color.White("  %-24s %d", "Gas limit", tx.Gas)
+	color.White("  %-24s %s wei", "Max fee per gas", tx.MaxFeePerGas)
+	color.White("  %-24s %s wei", "Max priority fee", tx.MaxPriorityFeePerGas)
+	if p.Snapshot.Delegation != nil {
+		color.Yellow("  %-24s %s, replaced by the operation", "Current delegation", p.Snapshot.Delegation.Hex())
+	}
+
+	color.Cyan("Validators:")
+	for _, state := range p.Validators {
+		color.White("  %s index %d, %s, %d Gwei, credentials %s", state.Pubkey, state.Index, state.Status, state.BalanceGwei, state.WithdrawalCredentials)
+	}
+	operations.PrintEffects(p.Effects)
+	color.Cyan("Planned at %s", p.CreatedAt.Format(time.RFC3339))
+}
+
+// Write writes the plan to a file and returns its hash
+func Write(p *Plan, fileName string) (string, error) {
+	jsonData, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal plan to JSON: %w", err)
+	}
+
+	if err := os.WriteFile(fileName, jsonData, 0644); err != nil {
+		return "", fmt.Errorf("failed to write plan to file: %w", err)
+	}
+
+	color.Green("Plan written to %s", fileName)
+	output.AddFile(fileName)
+	return hash(jsonData), nil
+}
+
+// Read reads a plan from a file and returns it with its hash
+func Read(fileName string) (*Plan, string, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read plan file %s: %w", fileName, err)
+	}
+
+	// Fields the plan format does not know, such as tolerances, must not be silently ignored
+	var p Plan
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JSON from %s: %w", fileName, err)
+	}
+	return &p, hash(data), nil
+}
+
+// hash returns the SHA-256 of the plan file, which approvers compare before apply
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validatorStates reads the beacon state of every validator the operation makes requests for
+func validatorStates(beaconClient *beacon.Client, description operations.Description) ([]ValidatorState, error) {
+	var pubkeys []string
+	seen := make(map[string]bool)
+	for _, request := range description.Requests {
+		for _, pubkey := range []string{request.Pubkey, request.Target} {
+			if pubkey == "" || seen[beacon.NormalizePubkey(pubkey)] {
+				continue
+			}
+			seen[beacon.NormalizePubkey(pubkey)] = true
+			pubkeys = append(pubkeys, pubkey)
+		}
+	}
+
+	validators, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the validators from the beacon node: %w", err)
+	}
+	byPubkey := make(map[string]beacon.Validator, len(validators))
+	for _, validator := range validators {
+		byPubkey[beacon.NormalizePubkey(validator.Validator.Pubkey)] = validator
+	}
+
+	states := make([]ValidatorState, 0, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		validator, ok := byPubkey[beacon.NormalizePubkey(pubkey)]
+		if !ok {
+			return nil, fmt.Errorf("validator %s is not known to the beacon node", pubkey)
+		}
+		states = append(states, ValidatorState{
+			Pubkey:                pubkey,
+			Index:                 validator.Index,
+			Status:                validator.Status,
+			WithdrawalCredentials: validator.Validator.WithdrawalCredentials,
+			BalanceGwei:           validator.Balance,
+		})
+	}
+	return states, nil
+}
+
+// feeFunction returns the batch contract function returning the fee of the method's requests
+func feeFunction(method string) string {
+	if method == pectra.MethodELExit {
+		return pectra.ExitFeeFunction
+	}
+	return pectra.ConsolidationFeeFunction
+}
+
+// setRequestFee records the fee paid for each request in the snapshot
+func setRequestFee(snapshot *transaction.NetworkSnapshot, method string, fee *big.Int) {
+	if feeFunction(method) == pectra.ExitFeeFunction {
+		snapshot.ExitFee = fee
+	} else {
+		snapshot.ConsolidationFee = fee
+	}
+}
+
+// sameAddress compares two optional addresses
+func sameAddress(a, b *common.Address) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// formatDelegation formats an optional delegation target
+func formatDelegation(delegation *common.Address) string {
+	if delegation == nil {
+		return "none"
+	}
+	return delegation.Hex()
+}
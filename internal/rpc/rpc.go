@@ -0,0 +1,324 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/fatih/color"
+)
+
+// maxHeadAge is how old the head block of an endpoint may be for it to be considered healthy
+const maxHeadAge = 2 * time.Minute
+
+// Client is the subset of the Ethereum JSON-RPC API used by the CLI. It is implemented by Pool.
+type Client interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	NetworkID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	EstimateGasWithCode(ctx context.Context, msg ethereum.CallMsg, account common.Address, code []byte) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// endpoint is a single RPC endpoint of a pool
+type endpoint struct {
+	name   string
+	client *ethclient.Client
+}
+
+// Pool spreads requests over several RPC endpoints. Reads fail over to the next healthy endpoint,
+// transactions are broadcast to all healthy endpoints and to the private relay, and receipts are
+// looked up on every endpoint so that inclusion is noticed whichever endpoint sees it first.
+type Pool struct {
+	endpoints []endpoint
+	relay     *endpoint
+}
+
+// Dial connects to the RPC endpoints of the configuration and keeps the healthy ones
+func Dial(cfg *config.Config) (*Pool, error) {
+	// Offline runs need no endpoint, so one is only required once the network is contacted
+	if len(cfg.Endpoints()) == 0 {
+		return nil, fmt.Errorf("rpcUrl or rpcUrls is required in the configuration to connect to the network")
+	}
+
+	pool := &Pool{}
+
+	var chainID *big.Int
+	if cfg.Profile.ChainID != 0 {
+		chainID = new(big.Int).SetUint64(cfg.Profile.ChainID)
+	}
+
+	for _, rawURL := range cfg.Endpoints() {
+		name := redact(rawURL)
+		client, err := ethclient.Dial(rawURL)
+		if err != nil {
+			color.Yellow("Skipping RPC endpoint %s: %v", name, err)
+			continue
+		}
+
+		endpointChainID, err := checkHealth(client)
+		if err != nil {
+			color.Yellow("Skipping unhealthy RPC endpoint %s: %v", name, err)
+			client.Close()
+			continue
+		}
+
+		// All endpoints must agree with the network profile, or with the first healthy endpoint
+		if chainID == nil {
+			chainID = endpointChainID
+		} else if endpointChainID.Cmp(chainID) != 0 {
+			color.Yellow("Skipping RPC endpoint %s: it is on chain ID %s, expected %s", name, endpointChainID, chainID)
+			client.Close()
+			continue
+		}
+
+		pool.endpoints = append(pool.endpoints, endpoint{name: name, client: client})
+	}
+
+	if len(pool.endpoints) == 0 {
+		return nil, fmt.Errorf("no healthy RPC endpoint available")
+	}
+	if len(pool.endpoints) > 1 {
+		color.Green("Using %d healthy RPC endpoints", len(pool.endpoints))
+	}
+
+	if cfg.PrivateRelayUrl != "" {
+		client, err := ethclient.Dial(cfg.PrivateRelayUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the private relay: %w", err)
+		}
+		pool.relay = &endpoint{name: redact(cfg.PrivateRelayUrl), client: client}
+	}
+
+	return pool, nil
+}
+
+// checkHealth returns the chain ID of the endpoint if it is synced and its head is recent
+func checkHealth(client *ethclient.Client) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the chain ID: %w", err)
+	}
+
+	progress, err := client.SyncProgress(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the sync status: %w", err)
+	}
+	if progress != nil && !progress.Done() {
+		return nil, fmt.Errorf("node is syncing (block %d of %d)", progress.CurrentBlock, progress.HighestBlock)
+	}
+
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the head block: %w", err)
+	}
+	if age := time.Since(time.Unix(int64(head.Time), 0)); age > maxHeadAge {
+		return nil, fmt.Errorf("head block %d is %s old", head.Number, age.Round(time.Second))
+	}
+
+	return chainID, nil
+}
+
+// read runs fn against the healthy endpoints in order until one answers. Errors returned by the
+// node itself, such as reverts, are returned right away since other endpoints would answer the same.
+func read[T any](p *Pool, fn func(*ethclient.Client) (T, error)) (T, error) {
+	var result T
+	var err error
+	for _, endpoint := range p.endpoints {
+		result, err = fn(endpoint.client)
+		if err == nil || isNodeError(err) {
+			return result, err
+		}
+		color.Yellow("RPC endpoint %s failed, trying the next one: %v", endpoint.name, err)
+	}
+	return result, err
+}
+
+// isNodeError reports whether the error is an answer of the node rather than a transport failure
+func isNodeError(err error) bool {
+	var rpcErr gethrpc.Error
+	return errors.Is(err, ethereum.NotFound) || errors.As(err, &rpcErr)
+}
+
+// ChainID returns the chain ID of the endpoints
+func (p *Pool) ChainID(ctx context.Context) (*big.Int, error) {
+	return read(p, func(c *ethclient.Client) (*big.Int, error) { return c.ChainID(ctx) })
+}
+
+// NetworkID returns the network ID of the endpoints
+func (p *Pool) NetworkID(ctx context.Context) (*big.Int, error) {
+	return read(p, func(c *ethclient.Client) (*big.Int, error) { return c.NetworkID(ctx) })
+}
+
+// HeaderByNumber returns a block header, the latest one if number is nil
+func (p *Pool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return read(p, func(c *ethclient.Client) (*types.Header, error) { return c.HeaderByNumber(ctx, number) })
+}
+
+// PendingNonceAt returns the pending nonce of the account
+func (p *Pool) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return read(p, func(c *ethclient.Client) (uint64, error) { return c.PendingNonceAt(ctx, account) })
+}
+
+// NonceAt returns the nonce of the account at the given block
+func (p *Pool) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return read(p, func(c *ethclient.Client) (uint64, error) { return c.NonceAt(ctx, account, blockNumber) })
+}
+
+// BalanceAt returns the balance of the account at the given block
+func (p *Pool) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return read(p, func(c *ethclient.Client) (*big.Int, error) { return c.BalanceAt(ctx, account, blockNumber) })
+}
+
+// CodeAt returns the code of the account at the given block
+func (p *Pool) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return read(p, func(c *ethclient.Client) ([]byte, error) { return c.CodeAt(ctx, account, blockNumber) })
+}
+
+// StorageAt returns the value of the storage slot of the account at the given block
+func (p *Pool) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	return read(p, func(c *ethclient.Client) ([]byte, error) { return c.StorageAt(ctx, account, key, blockNumber) })
+}
+
+// CallContract executes a message call
+func (p *Pool) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return read(p, func(c *ethclient.Client) ([]byte, error) { return c.CallContract(ctx, msg, blockNumber) })
+}
+
+// EstimateGas estimates the gas needed to execute a message call
+func (p *Pool) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return read(p, func(c *ethclient.Client) (uint64, error) { return c.EstimateGas(ctx, msg) })
+}
+
+// EstimateGasWithCode estimates the gas needed to execute a message call with the code of the account
+// overridden, e.g. to simulate an EOA delegated to a contract before the authorization is signed.
+// The endpoints must support state overrides in eth_estimateGas.
+func (p *Pool) EstimateGasWithCode(ctx context.Context, msg ethereum.CallMsg, account common.Address, code []byte) (uint64, error) {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["input"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	overrides := map[common.Address]map[string]interface{}{
+		account: {"code": hexutil.Bytes(code)},
+	}
+
+	return read(p, func(c *ethclient.Client) (uint64, error) {
+		var gas hexutil.Uint64
+		err := c.Client().CallContext(ctx, &gas, "eth_estimateGas", arg, "latest", overrides)
+		return uint64(gas), err
+	})
+}
+
+// SuggestGasPrice returns the suggested max fee per gas
+func (p *Pool) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return read(p, func(c *ethclient.Client) (*big.Int, error) { return c.SuggestGasPrice(ctx) })
+}
+
+// SuggestGasTipCap returns the suggested max priority fee per gas
+func (p *Pool) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return read(p, func(c *ethclient.Client) (*big.Int, error) { return c.SuggestGasTipCap(ctx) })
+}
+
+// TransactionByHash looks the transaction up on every endpoint, so that it is found even if only
+// one of them has it in its pool
+func (p *Pool) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	var lastErr error
+	for _, endpoint := range p.endpoints {
+		tx, pending, err := endpoint.client.TransactionByHash(ctx, hash)
+		if err == nil {
+			return tx, pending, nil
+		}
+		lastErr = err
+	}
+	return nil, false, lastErr
+}
+
+// TransactionReceipt looks the receipt up on every endpoint, so that inclusion is tracked from
+// whichever endpoint sees it first
+func (p *Pool) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	var lastErr error
+	for _, endpoint := range p.endpoints {
+		receipt, err := endpoint.client.TransactionReceipt(ctx, txHash)
+		if err == nil {
+			return receipt, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// SendTransaction broadcasts the transaction to all healthy endpoints and to the private relay. It
+// succeeds if at least one of them accepted the transaction.
+func (p *Pool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	targets := p.endpoints
+	if p.relay != nil {
+		targets = append([]endpoint{*p.relay}, targets...)
+	}
+
+	accepted := 0
+	var lastErr error
+	for _, endpoint := range targets {
+		err := endpoint.client.SendTransaction(ctx, tx)
+		if err != nil && !isAlreadyKnown(err) {
+			color.Yellow("RPC endpoint %s rejected the transaction: %v", endpoint.name, err)
+			lastErr = err
+			continue
+		}
+		accepted++
+		if len(targets) > 1 {
+			color.Green("Transaction sent to %s", endpoint.name)
+		}
+	}
+
+	if accepted == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// isAlreadyKnown reports whether the endpoint already had the transaction, e.g. through gossip
+func isAlreadyKnown(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "already known") || strings.Contains(message, "already imported")
+}
+
+// redact returns the scheme and host of an endpoint URL, dropping paths and queries that often
+// carry API keys
+func redact(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "endpoint"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,393 @@
+package safe
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/confirmation"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/fatih/color"
+)
+
+// MultiSendCallOnly are the canonical MultiSendCallOnly deployments by Safe version. Safe{Wallet}
+// batches the calls of a Transaction Builder file through the one of the version of the Safe.
+var MultiSendCallOnly = map[string]common.Address{
+	"1.3.0": common.HexToAddress("0x40A2aCCbd92BCA938b02010E17A5b8929b49130D"),
+	"1.4.1": common.HexToAddress("0x9641d764fc13c8B624c04430C7356C1C7C8102e2"),
+}
+
+// Safe operation types
+const (
+	OperationCall         = 0
+	OperationDelegateCall = 1
+)
+
+var (
+	// domainTypeHash is the EIP-712 domain type of Safe v1.3.0 and later
+	domainTypeHash = crypto.Keccak256Hash([]byte("EIP712Domain(uint256 chainId,address verifyingContract)"))
+	// safeTxTypeHash is the EIP-712 type of a Safe transaction
+	safeTxTypeHash = crypto.Keccak256Hash([]byte("SafeTx(address to,uint256 value,bytes data,uint8 operation,uint256 safeTxGas,uint256 baseGas,uint256 gasPrice,address gasToken,address refundReceiver,uint256 nonce)"))
+)
+
+// multiSendABI is the single method of the MultiSend contracts
+const multiSendABI = `[{"inputs":[{"internalType":"bytes","name":"transactions","type":"bytes"}],"name":"multiSend","outputs":[],"stateMutability":"payable","type":"function"}]`
+
+// Call is a call made by the Safe
+type Call struct {
+	To    common.Address
+	Value *big.Int
+	Data  []byte
+}
+
+// BuilderTransaction is a transaction of a Transaction Builder file
+type BuilderTransaction struct {
+	To                   common.Address    `json:"to"`
+	Value                string            `json:"value"`
+	Data                 string            `json:"data"`
+	ContractMethod       *json.RawMessage  `json:"contractMethod"`
+	ContractInputsValues map[string]string `json:"contractInputsValues"`
+}
+
+// BuilderMeta is the metadata of a Transaction Builder file
+type BuilderMeta struct {
+	Name                   string         `json:"name"`
+	Description            string         `json:"description"`
+	TxBuilderVersion       string         `json:"txBuilderVersion"`
+	CreatedFromSafeAddress common.Address `json:"createdFromSafeAddress"`
+	// CreatedFromOwnerAddress is left empty, the file is not created by an owner
+	CreatedFromOwnerAddress string `json:"createdFromOwnerAddress"`
+}
+
+// BuilderFile is a batch in the JSON format imported by the Safe Transaction Builder app
+type BuilderFile struct {
+	Version      string               `json:"version"`
+	ChainID      string               `json:"chainId"`
+	CreatedAt    int64                `json:"createdAt"`
+	Meta         BuilderMeta          `json:"meta"`
+	Transactions []BuilderTransaction `json:"transactions"`
+}
+
+// Transaction is the Safe transaction executing a batch, the values co-signers check before signing
+type Transaction struct {
+	Safe      common.Address `json:"safe"`
+	ChainID   *big.Int       `json:"chainId"`
+	To        common.Address `json:"to"`
+	Value     *big.Int       `json:"value"`
+	Data      string         `json:"data"`
+	Operation uint8          `json:"operation"`
+	Nonce     uint64         `json:"nonce"`
+	// DomainHash and MessageHash are shown by hardware wallets when signing the SafeTxHash
+	DomainHash  common.Hash `json:"domainHash"`
+	MessageHash common.Hash `json:"messageHash"`
+	SafeTxHash  common.Hash `json:"safeTxHash"`
+}
+
+// Nonce reads the nonce of the next transaction of the Safe, failing if there is no contract at
+// the address
+func Nonce(ctx context.Context, caller bind.ContractCaller, safe common.Address) (uint64, error) {
+	code, err := caller.CodeAt(ctx, safe, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the code of %s: %w", safe.Hex(), err)
+	}
+	if len(code) == 0 {
+		return 0, fmt.Errorf("there is no contract at %s, it is not a deployed Safe", safe.Hex())
+	}
+
+	// nonce()
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &safe, Data: common.FromHex("0xaffed0e0")}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the nonce of the Safe %s: %w", safe.Hex(), err)
+	}
+	if len(result) != 32 {
+		return 0, fmt.Errorf("unexpected nonce of %d bytes returned by %s, is it a Safe?", len(result), safe.Hex())
+	}
+	nonce := new(big.Int).SetBytes(result)
+	if !nonce.IsUint64() {
+		return 0, fmt.Errorf("invalid nonce %s returned by %s", nonce, safe.Hex())
+	}
+	return nonce.Uint64(), nil
+}
+
+// Version reads the version of the Safe, failing if there is no contract at the address
+func Version(ctx context.Context, caller bind.ContractCaller, safe common.Address) (string, error) {
+	code, err := caller.CodeAt(ctx, safe, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the code of %s: %w", safe.Hex(), err)
+	}
+	if len(code) == 0 {
+		return "", fmt.Errorf("there is no contract at %s, it is not a deployed Safe", safe.Hex())
+	}
+
+	// VERSION()
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &safe, Data: common.FromHex("0xffa1ad74")}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the version of the Safe %s: %w", safe.Hex(), err)
+	}
+	stringType, err := abi.NewType("string", "", nil)
+	if err != nil {
+		return "", err
+	}
+	values, err := abi.Arguments{{Type: stringType}}.Unpack(result)
+	if err != nil {
+		return "", fmt.Errorf("unexpected version returned by %s, is it a Safe? %w", safe.Hex(), err)
+	}
+	return values[0].(string), nil
+}
+
+// MultiSendFor returns the MultiSendCallOnly contract Safe{Wallet} uses for a Safe of the version.
+// Other versions are refused, their MultiSend or their transaction hash may differ.
+func MultiSendFor(version string) (common.Address, error) {
+	multiSend, ok := MultiSendCallOnly[version]
+	if !ok {
+		return common.Address{}, fmt.Errorf("unsupported Safe version %q, expected 1.3.0 or 1.4.1", version)
+	}
+	return multiSend, nil
+}
+
+// CheckWithdrawalAddresses checks on the beacon state that the Safe is the withdrawal address of
+// every source validator of the batch, the only validators the Safe can make requests for
+func CheckWithdrawalAddresses(beaconClient *beacon.Client, safe common.Address, call *calldata.Call) error {
+	pubkeys := make([]string, 0, len(call.Requests))
+	for _, request := range call.Requests {
+		pubkeys = append(pubkeys, request.SourcePubkey)
+	}
+	validators, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		return fmt.Errorf("failed to get the validators from the beacon node: %w", err)
+	}
+	byPubkey := make(map[string]beacon.Validator, len(validators))
+	for _, validator := range validators {
+		byPubkey[beacon.NormalizePubkey(validator.Validator.Pubkey)] = validator
+	}
+
+	var problems int
+	for _, pubkey := range pubkeys {
+		validator, ok := byPubkey[beacon.NormalizePubkey(pubkey)]
+		switch {
+		case !ok:
+			color.Red("  - validator %s was not found in the beacon state", pubkey)
+		case !validator.HasExecutionWithdrawalCredential():
+			color.Red("  - validator %s has BLS withdrawal credentials", pubkey)
+		case validator.WithdrawalAddress() != safe:
+			color.Red("  - validator %s withdraws to %s, not to the Safe", pubkey, validator.WithdrawalAddress().Hex())
+		default:
+			continue
+		}
+		problems++
+	}
+	if problems > 0 {
+		return fmt.Errorf("the Safe %s is not the withdrawal address of %d validator(s)", safe.Hex(), problems)
+	}
+	return nil
+}
+
+// PredeployFee reads the current fee of an EIP-7002 or EIP-7251 request contract, which returns
+// it when called without data
+func PredeployFee(ctx context.Context, caller bind.ContractCaller, predeploy common.Address) (*big.Int, error) {
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &predeploy}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the fee of %s: %w", predeploy.Hex(), err)
+	}
+	if len(result) != 32 {
+		return nil, fmt.Errorf("unexpected fee of %d bytes returned by %s", len(result), predeploy.Hex())
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
+// PredeployCalls converts the requests of a batch into direct calls to the system contracts, each
+// paying the fee of its contract
+func PredeployCalls(call *calldata.Call, withdrawalFee, consolidationFee *big.Int) ([]Call, error) {
+	calls := make([]Call, 0, len(call.Requests))
+	for _, request := range call.Requests {
+		source := common.FromHex(request.SourcePubkey)
+		switch request.Kind {
+		case calldata.KindSwitch, calldata.KindConsolidation:
+			// A switch is a consolidation of a validator into itself
+			data := append(append([]byte{}, source...), common.FromHex(request.TargetPubkey)...)
+			calls = append(calls, Call{To: confirmation.ConsolidationRequestPredeploy, Value: consolidationFee, Data: data})
+		case calldata.KindWithdrawal:
+			amount := request.Amount
+			if request.FullExit {
+				amount = 0
+			}
+			data := binary.BigEndian.AppendUint64(append([]byte{}, source...), amount)
+			calls = append(calls, Call{To: confirmation.WithdrawalRequestPredeploy, Value: withdrawalFee, Data: data})
+		default:
+			return nil, fmt.Errorf("unknown request kind %s", request.Kind)
+		}
+	}
+	return calls, nil
+}
+
+// NewBuilderFile returns the Transaction Builder file making the calls from the Safe
+func NewBuilderFile(chainID *big.Int, safe common.Address, name, description string, calls []Call) *BuilderFile {
+	transactions := make([]BuilderTransaction, 0, len(calls))
+	for _, call := range calls {
+		transactions = append(transactions, BuilderTransaction{
+			To:    call.To,
+			Value: call.Value.String(),
+			Data:  "0x" + common.Bytes2Hex(call.Data),
+		})
+	}
+
+	return &BuilderFile{
+		Version:   "1.0",
+		ChainID:   chainID.String(),
+		CreatedAt: time.Now().UnixMilli(),
+		Meta: BuilderMeta{
+			Name:                   name,
+			Description:            description,
+			TxBuilderVersion:       "1.16.5",
+			CreatedFromSafeAddress: safe,
+		},
+		Transactions: transactions,
+	}
+}
+
+// Calls returns the calls of the file
+func (f *BuilderFile) Calls() ([]Call, error) {
+	calls := make([]Call, 0, len(f.Transactions))
+	for i, tx := range f.Transactions {
+		value, ok := math.ParseBig256(tx.Value)
+		if !ok {
+			return nil, fmt.Errorf("transaction %d has an invalid value %q", i+1, tx.Value)
+		}
+		if tx.Data != "" && !strings.HasPrefix(tx.Data, "0x") {
+			return nil, fmt.Errorf("transaction %d has data without 0x prefix", i+1)
+		}
+		calls = append(calls, Call{To: tx.To, Value: value, Data: common.FromHex(tx.Data)})
+	}
+	return calls, nil
+}
+
+// EncodeMultiSend encodes the calls as a multiSend call, each call packed as operation, to, value,
+// data length and data
+func EncodeMultiSend(calls []Call) ([]byte, error) {
+	var packed []byte
+	for _, call := range calls {
+		packed = append(packed, OperationCall)
+		packed = append(packed, call.To.Bytes()...)
+		packed = append(packed, common.LeftPadBytes(call.Value.Bytes(), 32)...)
+		packed = append(packed, common.LeftPadBytes(big.NewInt(int64(len(call.Data))).Bytes(), 32)...)
+		packed = append(packed, call.Data...)
+	}
+
+	parsed, err := abi.JSON(strings.NewReader(multiSendABI))
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Pack("multiSend", packed)
+}
+
+// NewTransaction returns the Safe transaction making the calls, with the hashes signed by the
+// owners. As in Safe{Wallet}, a single call is made directly and several calls are batched by
+// delegating to the MultiSend contract.
+func NewTransaction(chainID *big.Int, safe, multiSend common.Address, nonce uint64, calls []Call) (*Transaction, error) {
+	tx := &Transaction{
+		Safe:    safe,
+		ChainID: chainID,
+		Nonce:   nonce,
+	}
+	var data []byte
+	switch len(calls) {
+	case 0:
+		return nil, fmt.Errorf("there are no calls to make")
+	case 1:
+		data = calls[0].Data
+		tx.To = calls[0].To
+		tx.Value = calls[0].Value
+		tx.Operation = OperationCall
+	default:
+		var err error
+		data, err = EncodeMultiSend(calls)
+		if err != nil {
+			return nil, err
+		}
+		tx.To = multiSend
+		tx.Value = big.NewInt(0)
+		tx.Operation = OperationDelegateCall
+	}
+	tx.Data = "0x" + common.Bytes2Hex(data)
+
+	tx.DomainHash = crypto.Keccak256Hash(
+		domainTypeHash.Bytes(),
+		math.U256Bytes(new(big.Int).Set(chainID)),
+		common.LeftPadBytes(safe.Bytes(), 32),
+	)
+	// The gas parameters, gas token and refund receiver are zero, as set by the Transaction Builder
+	tx.MessageHash = crypto.Keccak256Hash(
+		safeTxTypeHash.Bytes(),
+		common.LeftPadBytes(tx.To.Bytes(), 32),
+		math.U256Bytes(new(big.Int).Set(tx.Value)),
+		crypto.Keccak256(data),
+		common.LeftPadBytes([]byte{tx.Operation}, 32),
+		make([]byte, 32),
+		make([]byte, 32),
+		make([]byte, 32),
+		make([]byte, 32),
+		make([]byte, 32),
+		math.U256Bytes(new(big.Int).SetUint64(nonce)),
+	)
+	tx.SafeTxHash = crypto.Keccak256Hash([]byte{0x19, 0x01}, tx.DomainHash.Bytes(), tx.MessageHash.Bytes())
+	return tx, nil
+}
+
+// Print prints the Safe transaction for the co-signers
+func (tx *Transaction) Print() {
+	color.Cyan("Safe transaction:")
+	color.White("  %-14s %s (chain ID %s)", "Safe", tx.Safe.Hex(), tx.ChainID)
+	if tx.Operation == OperationDelegateCall {
+		color.White("  %-14s %s (delegatecall to MultiSend)", "To", tx.To.Hex())
+	} else {
+		color.White("  %-14s %s (call)", "To", tx.To.Hex())
+		color.White("  %-14s %s wei", "Value", tx.Value)
+	}
+	color.White("  %-14s %d", "Nonce", tx.Nonce)
+	color.White("  %-14s %s", "Domain hash", tx.DomainHash.Hex())
+	color.White("  %-14s %s", "Message hash", tx.MessageHash.Hex())
+	color.Green("  %-14s %s", "SafeTxHash", tx.SafeTxHash.Hex())
+}
+
+// ReadBuilderFile reads a Transaction Builder file
+func ReadBuilderFile(filePath string) (*BuilderFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Transaction Builder file %s: %w", filePath, err)
+	}
+
+	var file BuilderFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON from %s: %w", filePath, err)
+	}
+	return &file, nil
+}
+
+// WriteBuilderFile writes a Transaction Builder file
+func WriteBuilderFile(file *BuilderFile, fileName string) error {
+	jsonData, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal Transaction Builder file to JSON: %w", err)
+	}
+
+	if err := os.WriteFile(fileName, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write Transaction Builder file: %w", err)
+	}
+
+	color.Green("Safe Transaction Builder file written to %s", fileName)
+	output.AddFile(fileName)
+	return nil
+}
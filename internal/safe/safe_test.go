@@ -0,0 +1,128 @@
+package safe
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// The expected SafeTxHashes below are also checked against the EIP-712 encoder of go-ethereum
+var (
+	testSafe      = common.HexToAddress("0x1c511d88ba898b4D9cd9113D13B9c360a02Fcea1")
+	testChainID   = big.NewInt(1)
+	testNonce     = uint64(7)
+	testPredeploy = common.HexToAddress("0x0000BBdDc7CE488642fb579F8B00f3a590007251")
+)
+
+// typedDataHash hashes the Safe transaction with the EIP-712 encoder of go-ethereum, independently
+// of NewTransaction
+func typedDataHash(t *testing.T, tx *Transaction) common.Hash {
+	t.Helper()
+	typedData := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"SafeTx": {
+				{Name: "to", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "data", Type: "bytes"},
+				{Name: "operation", Type: "uint8"},
+				{Name: "safeTxGas", Type: "uint256"},
+				{Name: "baseGas", Type: "uint256"},
+				{Name: "gasPrice", Type: "uint256"},
+				{Name: "gasToken", Type: "address"},
+				{Name: "refundReceiver", Type: "address"},
+				{Name: "nonce", Type: "uint256"},
+			},
+		},
+		PrimaryType: "SafeTx",
+		Domain: apitypes.TypedDataDomain{
+			ChainId:           (*math.HexOrDecimal256)(tx.ChainID),
+			VerifyingContract: tx.Safe.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"to":             tx.To.Hex(),
+			"value":          tx.Value.String(),
+			"data":           tx.Data,
+			"operation":      hexutil.EncodeUint64(uint64(tx.Operation)),
+			"safeTxGas":      "0",
+			"baseGas":        "0",
+			"gasPrice":       "0",
+			"gasToken":       common.Address{}.Hex(),
+			"refundReceiver": common.Address{}.Hex(),
+			"nonce":          new(big.Int).SetUint64(tx.Nonce).String(),
+		},
+	}
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatalf("failed to hash the typed data: %v", err)
+	}
+	return common.BytesToHash(hash)
+}
+
+func TestNewTransactionSingleCall(t *testing.T) {
+	call := Call{To: testPredeploy, Value: big.NewInt(1), Data: common.FromHex("0xaa")}
+	tx, err := NewTransaction(testChainID, testSafe, MultiSendCallOnly["1.4.1"], testNonce, []Call{call})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single call is made directly, without MultiSend
+	if tx.Operation != OperationCall || tx.To != testPredeploy || tx.Value.Cmp(call.Value) != 0 || tx.Data != "0xaa" {
+		t.Fatalf("unexpected transaction to %s with value %s, data %s and operation %d", tx.To.Hex(), tx.Value, tx.Data, tx.Operation)
+	}
+	want := common.HexToHash("0x5bb1d1814dc282c59b2b55d5a40b15b3da812c57f1c51c42ddcab1003dc79baf")
+	if tx.SafeTxHash != want {
+		t.Errorf("SafeTxHash is %s, want %s", tx.SafeTxHash.Hex(), want.Hex())
+	}
+	if hash := typedDataHash(t, tx); tx.SafeTxHash != hash {
+		t.Errorf("SafeTxHash is %s, the EIP-712 hash is %s", tx.SafeTxHash.Hex(), hash.Hex())
+	}
+}
+
+func TestNewTransactionMultiSend(t *testing.T) {
+	calls := []Call{
+		{To: testPredeploy, Value: big.NewInt(1), Data: common.FromHex("0xaa")},
+		{To: testPredeploy, Value: big.NewInt(2), Data: common.FromHex("0xbb")},
+	}
+	tx, err := NewTransaction(testChainID, testSafe, MultiSendCallOnly["1.3.0"], testNonce, calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.Operation != OperationDelegateCall || tx.To != MultiSendCallOnly["1.3.0"] || tx.Value.Sign() != 0 {
+		t.Fatalf("unexpected transaction to %s with value %s and operation %d", tx.To.Hex(), tx.Value, tx.Operation)
+	}
+	want := common.HexToHash("0x2ad7a7136f8edfa6f3c9ad3292ea4b35eb051faa764bcde53ddd015efd01314d")
+	if tx.SafeTxHash != want {
+		t.Errorf("SafeTxHash is %s, want %s", tx.SafeTxHash.Hex(), want.Hex())
+	}
+	if hash := typedDataHash(t, tx); tx.SafeTxHash != hash {
+		t.Errorf("SafeTxHash is %s, the EIP-712 hash is %s", tx.SafeTxHash.Hex(), hash.Hex())
+	}
+}
+
+func TestNewTransactionWithoutCalls(t *testing.T) {
+	if _, err := NewTransaction(testChainID, testSafe, MultiSendCallOnly["1.4.1"], testNonce, nil); err == nil {
+		t.Fatal("expected an error for a transaction without calls")
+	}
+}
+
+func TestMultiSendFor(t *testing.T) {
+	for _, version := range []string{"1.3.0", "1.4.1"} {
+		if _, err := MultiSendFor(version); err != nil {
+			t.Errorf("version %s: %v", version, err)
+		}
+	}
+	for _, version := range []string{"1.2.0", "1.5.0", ""} {
+		if _, err := MultiSendFor(version); err == nil {
+			t.Errorf("expected version %q to be refused", version)
+		}
+	}
+}
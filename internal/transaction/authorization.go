@@ -0,0 +1,244 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/fatih/color"
+	"github.com/holiman/uint256"
+)
+
+// AuthorizationRequest is the file exchanged with the airgapped machine when only the EIP-7702
+// authorization is signed offline. It carries the call that will be made to the delegated EOA, so
+// that the final transaction can be assembled with fresh fees once the authorization is signed.
+type AuthorizationRequest struct {
+	ChainID   string         `json:"chainId"`
+	Authority common.Address `json:"authority"`
+	// Sponsored is true if the transaction is sent by a sponsor rather than by the authority itself
+	Sponsored             bool                        `json:"sponsored"`
+	UnsignedAuthorization *types.SetCodeAuthorization `json:"unsignedAuthorization,omitempty"`
+	SignedAuthorization   *types.SetCodeAuthorization `json:"signedAuthorization,omitempty"`
+	Data                  hexutil.Bytes               `json:"data"`
+	Value                 string                      `json:"value"`
+}
+
+// PrepareAuthorizationRequest writes an authorization request for the withdrawal EOA to
+// unsigned_authorization.json, to be signed on an airgapped machine. No fees are fetched, they are
+// only set when the transaction is assembled. The EOA from is prompted for if it is not set.
+func PrepareAuthorizationRequest(client rpc.Client, from, contract common.Address, data []byte, value *uint256.Int, sponsored bool, opts Options) error {
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get the chain ID: %w", err)
+	}
+
+	fromAddress, err := withdrawalAddress(from)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	restoreTarget, err := checkExistingDelegation(client, fromAddress, contract)
+	if err != nil {
+		return err
+	}
+
+	return writeAuthorizationRequests(chainID, fromAddress, nonce, contract, data, value, sponsored, restoreTarget, opts)
+}
+
+// writeAuthorizationRequests writes the authorization request for the operation, and for restoring
+// the previous delegation of the EOA if requested
+func writeAuthorizationRequests(chainID *big.Int, fromAddress common.Address, nonce uint64, contract common.Address, data []byte, value *uint256.Int, sponsored bool, restoreTarget *common.Address, opts Options) error {
+	// A transaction sent by the EOA itself increments its nonce before the authorization is
+	// processed, so each such transaction consumes two nonces
+	authorization, err := pectra.Authorization(chainID, contract, nonce, !sponsored)
+	if err != nil {
+		return err
+	}
+	noncesPerTransaction := uint64(2)
+	if sponsored {
+		noncesPerTransaction = 1
+	}
+
+	request := AuthorizationRequest{
+		ChainID:               chainID.String(),
+		Authority:             fromAddress,
+		Sponsored:             sponsored,
+		UnsignedAuthorization: &authorization,
+		Data:                  data,
+		Value:                 valueString(value),
+	}
+	if err := WriteAuthorizationRequest(request, opts.outputPath("unsigned_authorization.json")); err != nil {
+		return err
+	}
+
+	if restoreTarget == nil {
+		return nil
+	}
+
+	return WriteAuthorizationRequest(AuthorizationRequest{
+		ChainID:   chainID.String(),
+		Authority: fromAddress,
+		Sponsored: sponsored,
+		UnsignedAuthorization: &types.SetCodeAuthorization{
+			ChainID: authorization.ChainID,
+			Address: *restoreTarget,
+			Nonce:   authorization.Nonce + noncesPerTransaction,
+		},
+		Value: "0",
+	}, opts.outputPath("unsigned_restore_authorization.json"))
+}
+
+// AssembleTransaction builds the transaction the authority sends itself using the signed
+// authorization and fresh fees, and writes it to unsigned_txn.json for a second offline signature
+func AssembleTransaction(client rpc.Client, request *AuthorizationRequest, opts Options) error {
+	if request.Sponsored {
+		return fmt.Errorf("the authorization is meant to be sent by a sponsor")
+	}
+
+	value, err := request.ParseValue()
+	if err != nil {
+		return err
+	}
+
+	nonce, err := client.PendingNonceAt(context.Background(), request.Authority)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	gasPrice, err := client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get gas price: %w", err)
+	}
+
+	tipCap, err := client.SuggestGasTipCap(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get the gas tip cap: %w", err)
+	}
+
+	// The authorization is already signed, so the call can be simulated to get the real gas usage
+	gas, err := client.EstimateGas(context.Background(), ethereum.CallMsg{
+		From:              request.Authority,
+		To:                &request.Authority,
+		Value:             value.ToBig(),
+		Data:              request.Data,
+		AuthorizationList: []types.SetCodeAuthorization{*request.SignedAuthorization},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to estimate gas for the transaction: %w", err)
+	}
+	gas += gas / 5
+
+	txn := setCodeTransaction{
+		chainID:     request.SignedAuthorization.ChainID.ToBig(),
+		fromAddress: request.Authority,
+		nonce:       nonce,
+		tipCap:      tipCap,
+		gasPrice:    gasPrice,
+		gas:         gas,
+		contract:    request.SignedAuthorization.Address,
+		data:        request.Data,
+		value:       value,
+	}
+	color.Cyan("Assembled transaction with nonce %d, gas limit %d, max fee %s wei and tip %s wei", nonce, gas, gasPrice, tipCap)
+
+	return writeUnsignedTransaction(txn, *request.SignedAuthorization, opts.outputPath("unsigned_txn.json"))
+}
+
+// VerifySignedAuthorization checks that the signed authorization of the request was produced by
+// the authority for the given chain and that its nonce is still current
+func VerifySignedAuthorization(client rpc.Client, request *AuthorizationRequest, chainID *big.Int) error {
+	authorization := request.SignedAuthorization
+	if authorization == nil {
+		return fmt.Errorf("the file does not contain a signed authorization")
+	}
+
+	if authorization.ChainID.ToBig().Cmp(chainID) != 0 {
+		return fmt.Errorf("authorization is for chain ID %s, but the RPC endpoint is on chain ID %s", authorization.ChainID.ToBig(), chainID)
+	}
+
+	authority, err := authorization.Authority()
+	if err != nil {
+		return fmt.Errorf("failed to recover the authority of the authorization: %w", err)
+	}
+	if authority != request.Authority {
+		return fmt.Errorf("authorization was signed by %s, expected %s", authority.Hex(), request.Authority.Hex())
+	}
+
+	nonce, err := client.PendingNonceAt(context.Background(), authority)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+	expectedNonce := nonce
+	if !request.Sponsored {
+		expectedNonce++
+	}
+	if authorization.Nonce != expectedNonce {
+		return fmt.Errorf("authorization nonce %d does not match the expected nonce %d of %s", authorization.Nonce, expectedNonce, authority.Hex())
+	}
+
+	return nil
+}
+
+// ReadAuthorizationRequest reads an authorization request from a file
+func ReadAuthorizationRequest(filePath string) (*AuthorizationRequest, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorization file %s: %w", filePath, err)
+	}
+
+	var request AuthorizationRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON from %s: %w", filePath, err)
+	}
+	return &request, nil
+}
+
+// WriteAuthorizationRequest writes an authorization request to a file
+func WriteAuthorizationRequest(request AuthorizationRequest, fileName string) error {
+	jsonData, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal authorization to JSON: %w", err)
+	}
+
+	if err := os.WriteFile(fileName, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write authorization to file: %w", err)
+	}
+
+	color.Green("Authorization data written to %s", fileName)
+	output.AddFile(fileName)
+	return nil
+}
+
+// ParseValue parses the decimal wei value of an authorization request
+func (r *AuthorizationRequest) ParseValue() (*uint256.Int, error) {
+	if r.Value == "" {
+		return uint256.NewInt(0), nil
+	}
+	value, err := uint256.FromDecimal(r.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q: %w", r.Value, err)
+	}
+	return value, nil
+}
+
+// valueString formats a transaction value as a decimal wei string
+func valueString(value *uint256.Int) string {
+	if value == nil {
+		return "0"
+	}
+	return value.Dec()
+}
@@ -0,0 +1,74 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// PlannedTransaction is a SetCode transaction fixed in advance, down to its nonce and fees. It is
+// sent by the delegated EOA itself.
+type PlannedTransaction struct {
+	ChainID              *big.Int       `json:"chainId"`
+	From                 common.Address `json:"from"`
+	Nonce                uint64         `json:"nonce"`
+	MaxFeePerGas         *big.Int       `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *big.Int       `json:"maxPriorityFeePerGas"`
+	Gas                  uint64         `json:"gas"`
+	Contract             common.Address `json:"contract"`
+	Data                 hexutil.Bytes  `json:"data"`
+	Value                *big.Int       `json:"value"`
+}
+
+// setCode returns the planned transaction in the form used to sign or write it
+func (p *PlannedTransaction) setCode() (setCodeTransaction, error) {
+	if p.ChainID == nil || p.MaxFeePerGas == nil || p.MaxPriorityFeePerGas == nil || p.Value == nil {
+		return setCodeTransaction{}, fmt.Errorf("the planned transaction is incomplete")
+	}
+	value, overflow := uint256.FromBig(p.Value)
+	if overflow {
+		return setCodeTransaction{}, fmt.Errorf("value %s overflows", p.Value)
+	}
+
+	return setCodeTransaction{
+		chainID:     p.ChainID,
+		fromAddress: p.From,
+		nonce:       p.Nonce,
+		tipCap:      p.MaxPriorityFeePerGas,
+		gasPrice:    p.MaxFeePerGas,
+		gas:         p.Gas,
+		contract:    p.Contract,
+		data:        p.Data,
+		value:       value,
+	}, nil
+}
+
+// SendPlannedTransaction signs the planned transaction exactly as it was planned, sends it and waits
+// for it to be mined. The key must belong to the planned sender.
+func SendPlannedTransaction(client rpc.Client, privateKey *ecdsa.PrivateKey, planned *PlannedTransaction, opts Options) error {
+	if address := crypto.PubkeyToAddress(privateKey.PublicKey); address != planned.From {
+		return fmt.Errorf("the private key belongs to %s, the plan is for %s", address.Hex(), planned.From.Hex())
+	}
+
+	txn, err := planned.setCode()
+	if err != nil {
+		return err
+	}
+	return signAndSend(client, privateKey, txn, opts)
+}
+
+// WritePlannedTransaction writes the planned transaction to unsigned_txn.json for signing on an
+// airgapped machine
+func WritePlannedTransaction(planned *PlannedTransaction, opts Options) error {
+	txn, err := planned.setCode()
+	if err != nil {
+		return err
+	}
+	return txn.writeUnsigned(opts.outputPath("unsigned_txn.json"))
+}
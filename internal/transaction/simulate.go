@@ -0,0 +1,165 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/fatih/color"
+	"github.com/holiman/uint256"
+)
+
+// Simulation is the transaction an operation would send, checked against the current chain state
+// without signing anything
+type Simulation struct {
+	ChainID *big.Int `json:"chainId"`
+	// From is the delegated EOA, which sends the transaction to itself
+	From          common.Address             `json:"from"`
+	Nonce         uint64                     `json:"nonce"`
+	Authorization types.SetCodeAuthorization `json:"authorization"`
+	// Delegation is the current delegation of the EOA, if any
+	Delegation           *common.Address `json:"currentDelegation,omitempty"`
+	Value                *big.Int        `json:"valueWei"`
+	MaxFeePerGas         *big.Int        `json:"maxFeePerGasWei"`
+	MaxPriorityFeePerGas *big.Int        `json:"maxPriorityFeePerGasWei"`
+	// Gas is the estimated gas including the authorization, 0 when the call was not simulated
+	Gas uint64 `json:"gas"`
+	// Balance and RequiredBalance are only checked when the call is simulated
+	Balance         *big.Int `json:"balanceWei,omitempty"`
+	RequiredBalance *big.Int `json:"requiredBalanceWei,omitempty"`
+	Simulated       bool     `json:"simulated"`
+}
+
+// Simulate estimates the gas of the call made by the EOA delegated to the contract, which also
+// executes it against the current state with the delegation in place. Batch calls cannot be
+// sponsored, so the EOA always sends the call itself. An error is returned if the call reverts.
+func Simulate(client rpc.Client, from, contract common.Address, data []byte, value *uint256.Int) (*Simulation, error) {
+	ctx := context.Background()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the chain ID: %w", err)
+	}
+
+	nonce, err := client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %w", err)
+	}
+
+	tipCap, err := client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the gas tip cap: %w", err)
+	}
+
+	delegation, err := GetDelegation(client, from)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := client.CodeAt(ctx, contract, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the code of the batch contract: %w", err)
+	}
+
+	authorization, err := pectra.Authorization(chainID, contract, nonce, true)
+	if err != nil {
+		return nil, err
+	}
+
+	simulation := &Simulation{
+		ChainID:              chainID,
+		From:                 from,
+		Nonce:                nonce,
+		Authorization:        authorization,
+		Delegation:           delegation,
+		Value:                value.ToBig(),
+		MaxFeePerGas:         gasPrice,
+		MaxPriorityFeePerGas: tipCap,
+		Simulated:            true,
+	}
+
+	// The authorization is not signed yet, so the code of the contract is put in place at the EOA
+	// instead and the gas of processing the authorization is added
+	gas, err := client.EstimateGasWithCode(ctx, ethereum.CallMsg{
+		From:  from,
+		To:    &from,
+		Data:  data,
+		Value: simulation.Value,
+	}, from, code)
+	if err != nil {
+		return nil, fmt.Errorf("simulation of the batch call failed: %w", err)
+	}
+	simulation.Gas = gas + params.CallNewAccountGas
+
+	simulation.Balance, err = client.BalanceAt(ctx, from, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the balance of %s: %w", from.Hex(), err)
+	}
+	// The transaction is sent with the default gas limit, which the balance must cover at the max fee
+	simulation.RequiredBalance = new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(pectra.DefaultGasLimit))
+	simulation.RequiredBalance.Add(simulation.RequiredBalance, simulation.Value)
+
+	return simulation, nil
+}
+
+// SimulateOffline describes the transaction an operation would prepare from the snapshot. Nothing
+// can be executed offline, so the call is not simulated.
+func SimulateOffline(snapshot *NetworkSnapshot, contract common.Address, value *uint256.Int) (*Simulation, error) {
+	authorization, err := pectra.Authorization(snapshot.ChainID, contract, snapshot.Nonce, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Simulation{
+		ChainID:              snapshot.ChainID,
+		From:                 snapshot.Address,
+		Nonce:                snapshot.Nonce,
+		Authorization:        authorization,
+		Delegation:           snapshot.Delegation,
+		Value:                value.ToBig(),
+		MaxFeePerGas:         snapshot.MaxFeePerGas,
+		MaxPriorityFeePerGas: snapshot.MaxPriorityFeePerGas,
+	}, nil
+}
+
+// Print prints the transaction that would be sent
+func (s *Simulation) Print() {
+	color.Cyan("Transaction:")
+	color.White("  %-24s %s", "Chain ID", s.ChainID)
+	color.White("  %-24s %s", "From", s.From.Hex())
+	color.White("  %-24s %s", "To", s.From.Hex())
+	color.White("  %-24s %d", "EOA nonce", s.Nonce)
+	color.White("  %-24s delegate to %s with nonce %d", "Authorization", s.Authorization.Address.Hex(), s.Authorization.Nonce)
+	if s.Delegation != nil {
+		color.Yellow("  %-24s %s", "Current delegation", s.Delegation.Hex())
+	}
+	color.White("  %-24s %s wei", "Value", s.Value)
+	color.White("  %-24s %s wei", "Max fee per gas", s.MaxFeePerGas)
+	color.White("  %-24s %s wei", "Max priority fee", s.MaxPriorityFeePerGas)
+
+	if !s.Simulated {
+		color.Yellow("  %-24s not simulated offline", "Gas")
+		return
+	}
+	color.Green("  %-24s %d (simulation succeeded)", "Gas", s.Gas)
+
+	if s.Balance == nil {
+		return
+	}
+	if s.Balance.Cmp(s.RequiredBalance) < 0 {
+		color.Red("  %-24s %s wei, %s wei required", "Balance", s.Balance, s.RequiredBalance)
+	} else {
+		color.White("  %-24s %s wei, %s wei required", "Balance", s.Balance, s.RequiredBalance)
+	}
+}
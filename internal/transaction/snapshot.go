@@ -0,0 +1,197 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/fatih/color"
+	"github.com/holiman/uint256"
+)
+
+// NetworkSnapshot holds the chain data needed to prepare transactions without RPC access. It is
+// exported on an online machine with the snapshot command and carried to the airgapped machine.
+type NetworkSnapshot struct {
+	ChainID              *big.Int        `json:"chainId"`
+	Address              common.Address  `json:"address"`
+	Nonce                uint64          `json:"nonce"`
+	MaxFeePerGas         *big.Int        `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *big.Int        `json:"maxPriorityFeePerGas"`
+	ConsolidationFee     *big.Int        `json:"consolidationFee,omitempty"`
+	ExitFee              *big.Int        `json:"exitFee,omitempty"`
+	Delegation           *common.Address `json:"delegation,omitempty"`
+	Contract             common.Address  `json:"contract,omitempty"`
+	ContractCodeHash     *common.Hash    `json:"contractCodeHash,omitempty"`
+	CreatedAt            time.Time       `json:"createdAt"`
+}
+
+// TakeSnapshot reads the chain data needed to prepare transactions for the address offline
+func TakeSnapshot(client rpc.Client, address, contract common.Address) (*NetworkSnapshot, error) {
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the chain ID: %w", err)
+	}
+
+	nonce, err := client.PendingNonceAt(context.Background(), address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	gasPrice, err := client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %w", err)
+	}
+
+	tipCap, err := client.SuggestGasTipCap(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the gas tip cap: %w", err)
+	}
+
+	delegation, err := GetDelegation(client, address)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := client.CodeAt(context.Background(), contract, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the contract code: %w", err)
+	}
+
+	snapshot := &NetworkSnapshot{
+		ChainID:              chainID,
+		Address:              address,
+		Nonce:                nonce,
+		MaxFeePerGas:         gasPrice,
+		MaxPriorityFeePerGas: tipCap,
+		Delegation:           delegation,
+		Contract:             contract,
+		CreatedAt:            time.Now().UTC(),
+	}
+	if len(code) > 0 {
+		codeHash := crypto.Keccak256Hash(code)
+		snapshot.ContractCodeHash = &codeHash
+	}
+
+	return snapshot, nil
+}
+
+// Validate checks that the snapshot carries everything needed to build a transaction
+func (s *NetworkSnapshot) Validate() error {
+	if s.ChainID == nil || s.ChainID.Sign() == 0 {
+		return fmt.Errorf("chain ID is missing from the network snapshot")
+	}
+	if s.MaxFeePerGas == nil || s.MaxPriorityFeePerGas == nil {
+		return fmt.Errorf("max fee per gas and max priority fee per gas are required")
+	}
+	if s.MaxPriorityFeePerGas.Cmp(s.MaxFeePerGas) > 0 {
+		return fmt.Errorf("max priority fee per gas (%s) cannot exceed max fee per gas (%s)", s.MaxPriorityFeePerGas, s.MaxFeePerGas)
+	}
+	return nil
+}
+
+// PrepareOfflineTransaction writes the unsigned transaction, or the authorization request if only
+// the authorization is signed offline, using the snapshot instead of an RPC endpoint. The EOA is the
+// snapshot address, or from if the snapshot has none. It is prompted for if neither is set.
+func PrepareOfflineTransaction(snapshot *NetworkSnapshot, from, contract common.Address, data []byte, value *uint256.Int, authorizationOnly, sponsored bool, opts Options) error {
+	if err := snapshot.Validate(); err != nil {
+		return err
+	}
+
+	fromAddress := snapshot.Address
+	switch {
+	case fromAddress != (common.Address{}) && from != (common.Address{}) && from != fromAddress:
+		return fmt.Errorf("the EOA %s differs from the snapshot address %s, whose nonce and delegation the snapshot holds", from.Hex(), fromAddress.Hex())
+	case fromAddress == (common.Address{}):
+		fromAddress = from
+	}
+	if fromAddress == (common.Address{}) {
+		addressStr, err := config.GetPublicKey()
+		if err != nil {
+			return fmt.Errorf("failed to get public key: %w", err)
+		}
+		fromAddress = common.HexToAddress(addressStr)
+	}
+
+	if snapshot.CreatedAt.IsZero() {
+		color.Yellow("Preparing transaction for %s with nonce %d", fromAddress.Hex(), snapshot.Nonce)
+	} else {
+		color.Yellow("Preparing transaction for %s with nonce %d from a snapshot taken at %s", fromAddress.Hex(), snapshot.Nonce, snapshot.CreatedAt.Format(time.RFC3339))
+	}
+
+	restoreTarget, err := confirmDelegationChange(fromAddress, snapshot.Delegation, contract)
+	if err != nil {
+		return err
+	}
+
+	if authorizationOnly || sponsored {
+		return writeAuthorizationRequests(snapshot.ChainID, fromAddress, snapshot.Nonce, contract, data, value, sponsored, restoreTarget, opts)
+	}
+
+	return writeUnsignedTransactions(setCodeTransaction{
+		chainID:     snapshot.ChainID,
+		fromAddress: fromAddress,
+		nonce:       snapshot.Nonce,
+		tipCap:      snapshot.MaxPriorityFeePerGas,
+		gasPrice:    snapshot.MaxFeePerGas,
+		contract:    contract,
+		data:        data,
+		value:       value,
+	}, restoreTarget, opts)
+}
+
+// ReadSnapshot reads a network snapshot from a file
+func ReadSnapshot(filePath string) (*NetworkSnapshot, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file %s: %w", filePath, err)
+	}
+
+	var snapshot NetworkSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON from %s: %w", filePath, err)
+	}
+	return &snapshot, nil
+}
+
+// WriteSnapshot writes a network snapshot to a file
+func WriteSnapshot(snapshot *NetworkSnapshot, fileName string) error {
+	jsonData, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot to JSON: %w", err)
+	}
+
+	if err := os.WriteFile(fileName, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot to file: %w", err)
+	}
+
+	color.Green("Network snapshot written to %s", fileName)
+	output.AddFile(fileName)
+	return nil
+}
+
+// RequestFee returns the per-validator request fee recorded for the given fee getter of the batch contract
+func (s *NetworkSnapshot) RequestFee(functionName string) (*big.Int, error) {
+	var fee *big.Int
+	switch functionName {
+	case pectra.ConsolidationFeeFunction:
+		fee = s.ConsolidationFee
+	case pectra.ExitFeeFunction:
+		fee = s.ExitFee
+	default:
+		return nil, fmt.Errorf("unknown fee function %s", functionName)
+	}
+
+	if fee == nil {
+		return nil, fmt.Errorf("the fee returned by %s is missing from the network snapshot, set it with --request-fee", functionName)
+	}
+	return fee, nil
+}
@@ -0,0 +1,141 @@
+package transaction
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/fatih/color"
+	"github.com/holiman/uint256"
+)
+
+// SendSponsoredTransaction sends a transaction where the withdrawal EOA only signs the EIP-7702
+// authorization and a separate sponsor account builds, funds and sends the transaction
+func SendSponsoredTransaction(client rpc.Client, privateKey, sponsorKey *ecdsa.PrivateKey, contract common.Address, data []byte, value *uint256.Int, opts Options) error {
+	if privateKey == nil {
+		return fmt.Errorf("private key is required for non-airgapped mode")
+	}
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get the chain ID: %w", err)
+	}
+
+	fromAddress := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	// The sponsor sends the transaction, so the authorization uses the current nonce of the EOA
+	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+
+	restoreTarget, err := checkExistingDelegation(client, fromAddress, contract)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := pectra.Authorization(chainID, contract, nonce, false)
+	if err != nil {
+		return err
+	}
+
+	signedAuthorization, err := types.SignSetCode(privateKey, authorization)
+	if err != nil {
+		return fmt.Errorf("failed to sign the authorization: %w", err)
+	}
+
+	if err := SendWithSponsor(client, sponsorKey, signedAuthorization, fromAddress, data, value, opts); err != nil {
+		return err
+	}
+
+	if restoreTarget == nil {
+		return nil
+	}
+
+	// Processing the authorization incremented the nonce of the EOA
+	color.Cyan("Restoring the delegation of %s to %s", fromAddress.Hex(), restoreTarget.Hex())
+	restoreAuthorization, err := pectra.Authorization(chainID, *restoreTarget, nonce+1, false)
+	if err != nil {
+		return err
+	}
+
+	restore, err := types.SignSetCode(privateKey, restoreAuthorization)
+	if err != nil {
+		return fmt.Errorf("failed to sign the restore authorization: %w", err)
+	}
+	return SendWithSponsor(client, sponsorKey, restore, fromAddress, nil, nil, opts)
+}
+
+// SendWithSponsor builds a SetCode transaction calling the authority with the signed authorization,
+// signs it with the sponsor key, sends it and waits for it to be mined. Only delegation changes can
+// be sponsored, the batch contract refuses calls that are not made by the EOA itself.
+func SendWithSponsor(client rpc.Client, sponsorKey *ecdsa.PrivateKey, authorization types.SetCodeAuthorization, authority common.Address, data []byte, value *uint256.Int, opts Options) error {
+	if len(data) > 0 {
+		return pectra.ErrSponsoredCall
+	}
+	if sponsorKey == nil {
+		return fmt.Errorf("sponsor private key is required")
+	}
+	if value == nil {
+		value = uint256.NewInt(0)
+	}
+
+	chainID := authorization.ChainID.ToBig()
+	sponsorAddress := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+	color.Cyan("Sponsor %s pays for the transaction to %s", sponsorAddress.Hex(), authority.Hex())
+
+	nonce, err := client.PendingNonceAt(context.Background(), sponsorAddress)
+	if err != nil {
+		return fmt.Errorf("failed to get the sponsor nonce: %w", err)
+	}
+
+	gasPrice, err := client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get gas price: %w", err)
+	}
+
+	tipCap, err := client.SuggestGasTipCap(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get the gas tip cap: %w", err)
+	}
+
+	// Estimating the gas also simulates the call, so a transaction that would revert is never sent
+	gas, err := client.EstimateGas(context.Background(), ethereum.CallMsg{
+		From:              sponsorAddress,
+		To:                &authority,
+		Value:             value.ToBig(),
+		Data:              data,
+		AuthorizationList: []types.SetCodeAuthorization{authorization},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to estimate gas for the sponsored transaction: %w", err)
+	}
+	gas += gas / 5
+
+	tx, err := pectra.NewSetCodeTx(pectra.TxParams{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: gasPrice,
+		Gas:       gas,
+		Authority: authority,
+		Data:      data,
+		Value:     value,
+	}, authorization)
+	if err != nil {
+		return fmt.Errorf("failed to build the transaction: %w", err)
+	}
+
+	tx, err = types.SignTx(tx, types.LatestSignerForChainID(chainID), sponsorKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign the transaction: %w", err)
+	}
+
+	return sendAndWait(client, tx, opts)
+}
@@ -0,0 +1,412 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/confirmation"
+	"github.com/Luganodes/Pectra-CLI/internal/journal"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/fatih/color"
+)
+
+// OperationDelegation is the journal operation of transactions that only change the delegation,
+// such as unsetting or restoring it
+const OperationDelegation = "delegation"
+
+// OperationUnknown is the journal operation of transactions whose calldata is not a batch contract call
+const OperationUnknown = "unknown"
+
+const (
+	// receiptPollInterval is how often the receipt of a pending transaction is polled
+	receiptPollInterval = 2 * time.Second
+	// rebroadcastAfter is how long a transaction may be unknown to every endpoint before it is
+	// considered dropped and broadcast again
+	rebroadcastAfter = time.Minute
+)
+
+// errReplaced is returned when the nonce of a tracked transaction was used by another transaction
+var errReplaced = errors.New("transaction was replaced by another transaction with the same nonce")
+
+// newJournalEntry builds the journal entry of a signed transaction, decoding the validators it
+// makes requests for from its calldata
+func newJournalEntry(tx *types.Transaction) (journal.Entry, error) {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return journal.Entry{}, fmt.Errorf("failed to recover the transaction signer: %w", err)
+	}
+
+	rawTx, err := tx.MarshalBinary()
+	if err != nil {
+		return journal.Entry{}, fmt.Errorf("failed to encode the transaction: %w", err)
+	}
+
+	entry := journal.Entry{
+		Operation: OperationDelegation,
+		ChainID:   tx.ChainId().Uint64(),
+		From:      from,
+		Nonce:     tx.Nonce(),
+		TxHash:    tx.Hash(),
+		RawTx:     rawTx,
+		Status:    journal.StatusPending,
+	}
+
+	if len(tx.Data()) == 0 {
+		return entry, nil
+	}
+
+	contractABI, err := config.LoadABI()
+	if err != nil {
+		return journal.Entry{}, err
+	}
+	call, err := calldata.Decode(contractABI, tx.Data())
+	if err != nil {
+		// Still journal calls that are not batch contract calls, e.g. when tracking a foreign hash
+		entry.Operation = OperationUnknown
+		return entry, nil
+	}
+
+	entry.Operation = call.Method
+	for _, request := range call.Requests {
+		entry.Validators = append(entry.Validators, request.SourcePubkey)
+		switch request.Kind {
+		case calldata.KindConsolidation:
+			entry.Target = request.TargetPubkey
+		case calldata.KindWithdrawal:
+			entry.AmountsGwei = append(entry.AmountsGwei, request.Amount)
+		}
+	}
+	return entry, nil
+}
+
+// recordStatus appends the new status of the transaction to the journal, with the receipt if it
+// was mined. A failure to write the journal does not change the outcome of the transaction, so it
+// is only reported.
+func recordStatus(j *journal.Journal, entry journal.Entry, status string, receipt *types.Receipt, cause error) {
+	entry.Time = time.Time{}
+	entry.Status = status
+	entry.BlockNumber = 0
+	if receipt != nil {
+		entry.BlockNumber = receipt.BlockNumber.Uint64()
+	}
+	entry.Error = ""
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+
+	outputTransaction(entry, receipt)
+	if err := j.Record(entry); err != nil {
+		color.Red("Failed to record transaction %s as %s in the journal: %v", entry.TxHash.Hex(), status, err)
+	}
+}
+
+// outputTransaction records the transaction in the result of the command
+func outputTransaction(entry journal.Entry, receipt *types.Receipt) {
+	tx := output.Transaction{
+		Hash:   entry.TxHash,
+		From:   entry.From,
+		Nonce:  entry.Nonce,
+		Status: entry.Status,
+	}
+	if receipt != nil {
+		tx.BlockNumber = receipt.BlockNumber.Uint64()
+		tx.GasUsed = receipt.GasUsed
+		if receipt.EffectiveGasPrice != nil {
+			tx.FeePaid = new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+		}
+	}
+	output.RecordTransaction(tx)
+}
+
+// outputValidators records the outcome of the requests of a mined transaction in the result of the
+// command
+func outputValidators(results []confirmation.Result, txHash common.Hash) {
+	for _, result := range results {
+		request := result.Request
+		validator := output.Validator{
+			Kind:       request.Kind,
+			Pubkey:     request.SourcePubkey,
+			AmountGwei: request.Amount,
+			FullExit:   request.FullExit,
+			Status:     result.Status,
+			ReasonCode: result.ReasonCode,
+			TxHash:     txHash.Hex(),
+		}
+		if request.Kind == calldata.KindConsolidation {
+			validator.Target = request.TargetPubkey
+		}
+		output.AddValidators(validator)
+	}
+}
+
+// TrackTransaction waits for a journaled transaction to be mined, broadcasting it again if it is
+// dropped by the endpoints, and records the outcome in the journal. A transaction that is not mined
+// within the receipt timeout is left pending in the journal.
+func TrackTransaction(client rpc.Client, entry journal.Entry, opts Options) error {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(entry.RawTx); err != nil {
+		return fmt.Errorf("failed to decode the journaled transaction %s: %w", entry.TxHash.Hex(), err)
+	}
+
+	color.Cyan("Waiting for transaction %s to be included in a block...", entry.TxHash.Hex())
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.receiptTimeout())
+	defer cancel()
+
+	j := opts.journal()
+	receipt, err := waitForReceipt(ctx, client, tx, entry.From)
+	if errors.Is(err, errReplaced) {
+		recordStatus(j, entry, journal.StatusReplaced, nil, err)
+		return output.WithCode(output.CodeReplaced, err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return output.WithCode(output.CodePending, fmt.Errorf("transaction %s was not mined within %s, it is still pending: follow it with track or resume, or replace it with higher fees", entry.TxHash.Hex(), opts.receiptTimeout()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to wait for the transaction to be included in a block: %w", err)
+	}
+
+	blockNumber := receipt.BlockNumber.Uint64()
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		recordStatus(j, entry, journal.StatusFailed, receipt, nil)
+		return output.WithCode(output.CodeReverted, fmt.Errorf("transaction failed"))
+	}
+
+	color.Green("Transaction successful in block %d", blockNumber)
+
+	// A successful receipt does not prove that every request reached the system contracts
+	unconfirmed, err := checkRequests(tx, receipt, requestSource(tx))
+	if err != nil {
+		// Without the logs nothing proves the requests went through, so none are skipped on resume
+		entry.Unconfirmed = entry.Validators
+		recordStatus(j, entry, journal.StatusConfirmed, receipt, err)
+		return fmt.Errorf("failed to check the validator requests in the receipt: %w", err)
+	}
+	entry.Unconfirmed = unconfirmed
+	recordStatus(j, entry, journal.StatusConfirmed, receipt, nil)
+
+	if len(unconfirmed) > 0 {
+		return output.WithCode(output.CodeUnconfirmed, fmt.Errorf("%d of %d validator request(s) did not reach the system contracts", len(unconfirmed), len(entry.Validators)))
+	}
+	return nil
+}
+
+// checkRequests prints the per-validator confirmation table of a successful batch transaction and
+// returns the validators whose request is missing from the receipt logs
+func checkRequests(tx *types.Transaction, receipt *types.Receipt, authority common.Address) ([]string, error) {
+	if len(tx.Data()) == 0 {
+		return nil, nil
+	}
+
+	results, err := decodeRequests(tx, receipt, authority)
+	if err != nil {
+		return nil, err
+	}
+	confirmation.PrintTable(results)
+	outputValidators(results, tx.Hash())
+
+	return confirmation.Unconfirmed(results), nil
+}
+
+// requestSource returns the delegated EOA a batch transaction calls, which is the source address of
+// its requests. It differs from the sender for sponsored transactions.
+func requestSource(tx *types.Transaction) common.Address {
+	if tx.To() == nil {
+		return common.Address{}
+	}
+	return *tx.To()
+}
+
+// decodeRequests maps the receipt logs of a batch transaction back to each of its requests
+func decodeRequests(tx *types.Transaction, receipt *types.Receipt, authority common.Address) ([]confirmation.Result, error) {
+	contractABI, err := config.LoadABI()
+	if err != nil {
+		return nil, err
+	}
+	call, err := calldata.Decode(contractABI, tx.Data())
+	if err != nil {
+		return nil, err
+	}
+	return confirmation.Check(contractABI, call, receipt, authority)
+}
+
+// MinedRequests returns the outcome of every request of a mined batch transaction, together with
+// the header of the block it was included in. The transaction is read from the journal if it is
+// there, from the RPC endpoints otherwise.
+func MinedRequests(client rpc.Client, hash common.Hash, opts Options) ([]confirmation.Result, *types.Header, error) {
+	ctx := context.Background()
+
+	tx := new(types.Transaction)
+	entry, err := opts.journal().Find(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry != nil {
+		if err := tx.UnmarshalBinary(entry.RawTx); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode the journaled transaction %s: %w", hash.Hex(), err)
+		}
+	} else {
+		tx, _, err = client.TransactionByHash(ctx, hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get transaction %s: %w", hash.Hex(), err)
+		}
+	}
+
+	if len(tx.Data()) == 0 {
+		return nil, nil, fmt.Errorf("transaction %s makes no validator requests", hash.Hex())
+	}
+
+	receipt, err := client.TransactionReceipt(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil, fmt.Errorf("transaction %s is not mined yet, follow it with the track command", hash.Hex())
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the receipt of %s: %w", hash.Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, nil, fmt.Errorf("transaction %s failed", hash.Hex())
+	}
+
+	results, err := decodeRequests(tx, receipt, requestSource(tx))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := client.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get block %s: %w", receipt.BlockNumber, err)
+	}
+	return results, header, nil
+}
+
+// waitForReceipt polls for the receipt of the transaction until the context is done. When no
+// endpoint knows the transaction anymore, it is broadcast again unless its nonce has been used by
+// another transaction.
+func waitForReceipt(ctx context.Context, client rpc.Client, tx *types.Transaction, from common.Address) (*types.Receipt, error) {
+	lastSeen := time.Now()
+	belowBaseFee := false
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+		if err == nil {
+			return receipt, nil
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			color.Yellow("Failed to get the transaction receipt, retrying: %v", err)
+		}
+
+		_, _, err = client.TransactionByHash(ctx, tx.Hash())
+		switch {
+		case err == nil:
+			lastSeen = time.Now()
+		case errors.Is(err, ethereum.NotFound) && time.Since(lastSeen) > rebroadcastAfter:
+			nonce, err := client.NonceAt(ctx, from, nil)
+			if err != nil {
+				color.Yellow("Failed to get the nonce of %s, retrying: %v", from.Hex(), err)
+				break
+			}
+			if nonce > tx.Nonce() {
+				// The transaction may have been mined between the two calls
+				if receipt, err := client.TransactionReceipt(ctx, tx.Hash()); err == nil {
+					return receipt, nil
+				}
+				return nil, errReplaced
+			}
+
+			color.Yellow("Transaction %s was dropped, broadcasting it again", tx.Hash().Hex())
+			if err := client.SendTransaction(ctx, tx); err != nil {
+				color.Yellow("Failed to broadcast the transaction again: %v", err)
+			}
+			lastSeen = time.Now()
+		}
+
+		// A max fee below the base fee keeps the transaction out of every block until the base fee drops
+		if header, err := client.HeaderByNumber(ctx, nil); err == nil && header.BaseFee != nil {
+			stuck := tx.GasFeeCap().Cmp(header.BaseFee) < 0
+			if stuck && !belowBaseFee {
+				color.Yellow("The max fee per gas of %s wei is below the base fee of %s wei, the transaction cannot be included until the base fee drops", tx.GasFeeCap(), header.BaseFee)
+			}
+			belowBaseFee = stuck
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(receiptPollInterval):
+		}
+	}
+}
+
+// TrackHash reattaches to a transaction by hash. Transactions missing from the journal are looked
+// up on the RPC endpoints and added to it.
+func TrackHash(client rpc.Client, hash common.Hash, opts Options) error {
+	j := opts.journal()
+	entry, err := j.Find(hash)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		tx, _, err := client.TransactionByHash(context.Background(), hash)
+		if err != nil {
+			return fmt.Errorf("transaction %s is neither in the journal nor known to the RPC endpoints: %w", hash.Hex(), err)
+		}
+		newEntry, err := newJournalEntry(tx)
+		if err != nil {
+			return err
+		}
+		if err := j.Record(newEntry); err != nil {
+			return err
+		}
+		entry = &newEntry
+	}
+
+	color.Cyan("Transaction %s/tx/%s: %s by %s with nonce %d, status %s", opts.ExplorerURL, entry.TxHash.Hex(), entry.Operation, entry.From.Hex(), entry.Nonce, entry.Status)
+	for _, validator := range entry.Validators {
+		color.White("  %s", validator)
+	}
+
+	// Mined transactions are looked up again to print their confirmation table
+	return TrackTransaction(client, *entry, opts)
+}
+
+// ResumePending reattaches to every pending transaction of the chain recorded in the journal
+func ResumePending(client rpc.Client, chainID uint64, opts Options) error {
+	j := opts.journal()
+	pending, err := j.Pending(chainID)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		color.Green("No pending transactions in %s", j.Path())
+		return nil
+	}
+
+	color.Cyan("Resuming %d pending transaction(s) from %s", len(pending), j.Path())
+	failed := 0
+	for _, entry := range pending {
+		color.Cyan("%s by %s with nonce %d (%d validators)", entry.Operation, entry.From.Hex(), entry.Nonce, len(entry.Validators))
+		if err := TrackTransaction(client, entry, opts); err != nil {
+			color.Red("Transaction %s: %v", entry.TxHash.Hex(), err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d pending transaction(s) did not confirm", failed)
+	}
+	return nil
+}
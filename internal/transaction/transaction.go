@@ -6,15 +6,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/Luganodes/Pectra-CLI/internal/config"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/Luganodes/Pectra-CLI/internal/journal"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/fatih/color"
 
@@ -22,8 +26,77 @@ import (
 	"github.com/holiman/uint256"
 )
 
-// SendTransactionUsingAuthorization sends a transaction with authorization
-func SendTransactionUsingAuthorization(client *ethclient.Client, privateKey *ecdsa.PrivateKey, contract common.Address, data []byte, value *uint256.Int, explorerURL string, airgapped bool) error {
+// setCodeTransaction holds everything needed to build a SetCode transaction for an EOA
+type setCodeTransaction struct {
+	chainID     *big.Int
+	fromAddress common.Address
+	nonce       uint64
+	tipCap      *big.Int
+	gasPrice    *big.Int
+	// gas is the gas limit, pectra.DefaultGasLimit is used if it is not set
+	gas      uint64
+	contract common.Address
+	data     []byte
+	value    *uint256.Int
+}
+
+// DefaultReceiptTimeout is how long a sent transaction is waited for when Options.ReceiptTimeout is
+// not set
+const DefaultReceiptTimeout = 30 * time.Minute
+
+// Options are the settings of the functions writing, sending and tracking transactions
+type Options struct {
+	// ExplorerURL is the block explorer sent transactions are linked to
+	ExplorerURL string
+	// Journal records every transaction sent, the journal at journal.DefaultPath if nil
+	Journal *journal.Journal
+	// OutputDir is the directory the unsigned transactions and authorization requests are written
+	// to, the working directory if it is not set
+	OutputDir string
+	// ReceiptTimeout is how long a transaction is waited for before it is left pending in the
+	// journal, DefaultReceiptTimeout if it is not set
+	ReceiptTimeout time.Duration
+}
+
+// journal returns the journal transactions are recorded in
+func (o Options) journal() *journal.Journal {
+	if o.Journal == nil {
+		return journal.Open(journal.DefaultPath)
+	}
+	return o.Journal
+}
+
+// outputPath returns the path of an output file in OutputDir
+func (o Options) outputPath(fileName string) string {
+	if o.OutputDir == "" {
+		return fileName
+	}
+	return filepath.Join(o.OutputDir, fileName)
+}
+
+// receiptTimeout returns how long a transaction is waited for
+func (o Options) receiptTimeout() time.Duration {
+	if o.ReceiptTimeout == 0 {
+		return DefaultReceiptTimeout
+	}
+	return o.ReceiptTimeout
+}
+
+// withdrawalAddress returns the address of the withdrawal EOA, prompting for it if it is not known
+func withdrawalAddress(from common.Address) (common.Address, error) {
+	if from != (common.Address{}) {
+		return from, nil
+	}
+	addressStr, err := config.GetPublicKey()
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to get public key: %w", err)
+	}
+	return common.HexToAddress(addressStr), nil
+}
+
+// SendTransactionUsingAuthorization sends a transaction with authorization. In airgapped mode the
+// transaction is written for the EOA from, which is prompted for if it is not set.
+func SendTransactionUsingAuthorization(client rpc.Client, privateKey *ecdsa.PrivateKey, from common.Address, contract common.Address, data []byte, value *uint256.Int, airgapped bool, opts Options) error {
 	chainID, err := client.NetworkID(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get the chain ID: %w", err)
@@ -41,11 +114,10 @@ func SendTransactionUsingAuthorization(client *ethclient.Client, privateKey *ecd
 	} else if airgapped {
 		// In airgapped mode without privateKey, use a placeholder address and nonce
 		// The actual values will be provided during signing
-		addressStr, err := config.GetPublicKey()
+		fromAddress, err = withdrawalAddress(from)
 		if err != nil {
-			return fmt.Errorf("failed to get public key: %w", err)
+			return err
 		}
-		fromAddress = common.HexToAddress(addressStr)
 		nonce, err = client.PendingNonceAt(context.Background(), fromAddress)
 		if err != nil {
 			return fmt.Errorf("failed to get nonce: %w", err)
@@ -54,6 +126,11 @@ func SendTransactionUsingAuthorization(client *ethclient.Client, privateKey *ecd
 		return fmt.Errorf("private key is required for non-airgapped mode")
 	}
 
+	restoreTarget, err := checkExistingDelegation(client, fromAddress, contract)
+	if err != nil {
+		return err
+	}
+
 	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get gas price: %w", err)
@@ -64,99 +141,241 @@ func SendTransactionUsingAuthorization(client *ethclient.Client, privateKey *ecd
 		return fmt.Errorf("failed to get the gas tip cap: %w", err)
 	}
 
-	authorization := types.SetCodeAuthorization{
-		ChainID: *uint256.NewInt(chainID.Uint64()),
-		Address: contract,
-		Nonce:   nonce + 1,
+	txn := setCodeTransaction{
+		chainID:     chainID,
+		fromAddress: fromAddress,
+		nonce:       nonce,
+		tipCap:      tipCap,
+		gasPrice:    gasPrice,
+		contract:    contract,
+		data:        data,
+		value:       value,
 	}
 
 	if airgapped {
-		tx := types.NewTx(&types.SetCodeTx{
-			ChainID:   uint256.NewInt(chainID.Uint64()),
-			Nonce:     nonce,
-			GasTipCap: uint256.NewInt(tipCap.Uint64()),
-			GasFeeCap: uint256.NewInt(gasPrice.Uint64()),
-			Gas:       uint64(30000000),
-			To:        fromAddress,
-			Value:     value,
-			Data:      data,
-			AuthList:  []types.SetCodeAuthorization{authorization},
-		})
-
-		// serialize the transaction to hex
-		txBytes, err := rlp.EncodeToBytes(tx)
-		if err != nil {
-			return fmt.Errorf("failed to serialize the transaction: %w", err)
-		}
+		return writeUnsignedTransactions(txn, restoreTarget, opts)
+	}
 
-		// Create JSON structure for the transaction
-		txData := map[string]string{
-			"unsignedTransaction": hex.EncodeToString(txBytes),
-			"chainId":             chainID.String(),
-		}
+	if err := signAndSend(client, privateKey, txn, opts); err != nil {
+		return err
+	}
 
-		// Marshal to JSON
-		jsonData, err := json.MarshalIndent(txData, "", "  ")
-		if err != nil {
-			return fmt.Errorf("failed to marshal transaction to JSON: %w", err)
-		}
+	if restoreTarget == nil {
+		return nil
+	}
 
-		// Write to file
-		err = os.WriteFile("unsigned_txn.json", jsonData, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to write transaction to file: %w", err)
-		}
+	color.Cyan("Restoring the delegation of %s to %s", fromAddress.Hex(), restoreTarget.Hex())
+	return signAndSend(client, privateKey, txn.restore(*restoreTarget), opts)
+}
 
-		color.Green("Transaction data written to unsigned_txn.json")
-	} else {
-		signedAuthorization, err := types.SignSetCode(privateKey, authorization)
-		if err != nil {
-			return fmt.Errorf("failed to sign the authorization: %w", err)
-		}
+// writeUnsignedTransactions writes the unsigned transaction for the operation, and for restoring the
+// previous delegation of the EOA if requested
+func writeUnsignedTransactions(txn setCodeTransaction, restoreTarget *common.Address, opts Options) error {
+	if err := txn.writeUnsigned(opts.outputPath("unsigned_txn.json")); err != nil {
+		return err
+	}
 
-		tx := types.NewTx(&types.SetCodeTx{
-			ChainID:   uint256.NewInt(chainID.Uint64()),
-			Nonce:     nonce,
-			GasTipCap: uint256.NewInt(tipCap.Uint64()),
-			GasFeeCap: uint256.NewInt(gasPrice.Uint64()),
-			Gas:       uint64(30000000),
-			To:        fromAddress,
-			Value:     value,
-			Data:      data,
-			AuthList:  []types.SetCodeAuthorization{signedAuthorization},
-		})
-
-		tx, err = types.SignTx(tx, types.LatestSignerForChainID(chainID), privateKey)
-		if err != nil {
-			return fmt.Errorf("failed to sign the transaction: %w", err)
-		}
+	if restoreTarget == nil {
+		return nil
+	}
 
-		err = client.SendTransaction(context.Background(), tx)
-		if err != nil {
-			return fmt.Errorf("failed to send the transaction: %w", err)
-		}
+	color.Cyan("Preparing the restoration of the delegation of %s to %s", txn.fromAddress.Hex(), restoreTarget.Hex())
+	restore := txn.restore(*restoreTarget)
+	return restore.writeUnsigned(opts.outputPath("unsigned_restore_txn.json"))
+}
+
+// restore returns the transaction that delegates the EOA back to the target after txn was mined.
+// The operation transaction consumes two nonces: one for the transaction and one for the authorization.
+func (txn setCodeTransaction) restore(target common.Address) setCodeTransaction {
+	return setCodeTransaction{
+		chainID:     txn.chainID,
+		fromAddress: txn.fromAddress,
+		nonce:       txn.nonce + 2,
+		tipCap:      txn.tipCap,
+		gasPrice:    txn.gasPrice,
+		contract:    target,
+	}
+}
 
-		color.Cyan("Transaction sent: %s/tx/%s", explorerURL, tx.Hash().Hex())
+// checkExistingDelegation inspects the code of the EOA and warns if it is already delegated to a
+// contract other than the one being authorized. It returns the current delegation target if the
+// operator wants it restored once the operation is done.
+func checkExistingDelegation(client rpc.Client, fromAddress, contract common.Address) (*common.Address, error) {
+	current, err := GetDelegation(client, fromAddress)
+	if err != nil {
+		return nil, err
+	}
+	return confirmDelegationChange(fromAddress, current, contract)
+}
+
+// GetDelegation returns the contract the EOA is delegated to, or nil if it has no delegation
+func GetDelegation(client rpc.Client, address common.Address) (*common.Address, error) {
+	code, err := client.CodeAt(context.Background(), address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the code of %s: %w", address.Hex(), err)
+	}
 
-		color.Cyan("Waiting for transaction to be included in a block...")
+	current, ok := types.ParseDelegation(code)
+	if !ok {
+		return nil, nil
+	}
+	return &current, nil
+}
 
-		receipt, err := bind.WaitMined(context.Background(), client, tx)
+// confirmDelegationChange warns if the EOA is delegated to a contract other than the one being
+// authorized and asks the operator whether the current delegation should be restored afterwards
+func confirmDelegationChange(fromAddress common.Address, delegation *common.Address, contract common.Address) (*common.Address, error) {
+	if delegation == nil || *delegation == contract {
+		return nil, nil
+	}
+	current := *delegation
+
+	color.Yellow("Warning: %s is currently delegated to %s", fromAddress.Hex(), current.Hex())
+
+	if contract == (common.Address{}) {
+		confirmed, err := config.Confirm("Unsetting the code will remove this delegation. Continue?")
 		if err != nil {
-			return fmt.Errorf("failed to wait for the transaction to be included in a block: %w", err)
+			return nil, err
 		}
-
-		if receipt.Status != types.ReceiptStatusSuccessful {
-			return fmt.Errorf("transaction failed")
+		if !confirmed {
+			return nil, fmt.Errorf("aborted: %s is delegated to %s", fromAddress.Hex(), current.Hex())
 		}
+		return nil, nil
+	}
+
+	color.Yellow("This operation will replace that delegation with %s", contract.Hex())
+	restore, err := config.Confirm(fmt.Sprintf("Restore the delegation to %s after the operation?", current.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	if !restore {
+		color.Yellow("The delegation to %s will not be restored", current.Hex())
+		return nil, nil
+	}
+	return &current, nil
+}
+
+// authorization returns the unsigned authorization for the transaction. The EOA sends the
+// transaction itself, so its nonce is incremented before the authorization is processed.
+func (txn setCodeTransaction) authorization() (types.SetCodeAuthorization, error) {
+	return pectra.Authorization(txn.chainID, txn.contract, txn.nonce, true)
+}
+
+// writeUnsigned writes the transaction with its unsigned authorization for signing on an airgapped
+// machine
+func (txn setCodeTransaction) writeUnsigned(fileName string) error {
+	authorization, err := txn.authorization()
+	if err != nil {
+		return err
+	}
+	return writeUnsignedTransaction(txn, authorization, fileName)
+}
+
+// build creates the SetCode transaction with the given authorization
+func (txn setCodeTransaction) build(authorization types.SetCodeAuthorization) (*types.Transaction, error) {
+	return pectra.NewSetCodeTx(pectra.TxParams{
+		ChainID:   txn.chainID,
+		Nonce:     txn.nonce,
+		GasTipCap: txn.tipCap,
+		GasFeeCap: txn.gasPrice,
+		Gas:       txn.gas,
+		Authority: txn.fromAddress,
+		Data:      txn.data,
+		Value:     txn.value,
+	}, authorization)
+}
+
+// writeUnsignedTransaction writes the unsigned transaction to a file for signing on an airgapped machine.
+// The authorization is either unsigned as well, or was signed beforehand.
+func writeUnsignedTransaction(txn setCodeTransaction, authorization types.SetCodeAuthorization, fileName string) error {
+	tx, err := txn.build(authorization)
+	if err != nil {
+		return fmt.Errorf("failed to build the transaction: %w", err)
+	}
+
+	// serialize the transaction to hex
+	txBytes, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return fmt.Errorf("failed to serialize the transaction: %w", err)
+	}
+
+	// Create JSON structure for the transaction
+	txData := map[string]string{
+		"unsignedTransaction": hex.EncodeToString(txBytes),
+		"chainId":             txn.chainID.String(),
+	}
 
-		color.Green("Transaction successful")
+	// Marshal to JSON
+	jsonData, err := json.MarshalIndent(txData, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal transaction to JSON: %w", err)
+	}
+
+	// Write to file
+	err = os.WriteFile(fileName, jsonData, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write transaction to file: %w", err)
 	}
 
+	color.Green("Transaction data written to %s", fileName)
+	output.SetFrom(txn.fromAddress)
+	output.AddFile(fileName)
 	return nil
 }
 
+// signAndSend signs the authorization and the transaction, sends it and waits for it to be mined
+func signAndSend(client rpc.Client, privateKey *ecdsa.PrivateKey, txn setCodeTransaction, opts Options) error {
+	authorization, err := txn.authorization()
+	if err != nil {
+		return err
+	}
+
+	signedAuthorization, err := types.SignSetCode(privateKey, authorization)
+	if err != nil {
+		return fmt.Errorf("failed to sign the authorization: %w", err)
+	}
+
+	tx, err := txn.build(signedAuthorization)
+	if err != nil {
+		return fmt.Errorf("failed to build the transaction: %w", err)
+	}
+	tx, err = types.SignTx(tx, types.LatestSignerForChainID(txn.chainID), privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign the transaction: %w", err)
+	}
+
+	return sendAndWait(client, tx, opts)
+}
+
+// sendAndWait records a signed transaction in the journal, sends it and waits for it to be mined
+func sendAndWait(client rpc.Client, tx *types.Transaction, opts Options) error {
+	entry, err := newJournalEntry(tx)
+	if err != nil {
+		return err
+	}
+
+	// The raw transaction is journaled before it is sent, so it can be tracked if the CLI dies
+	if err := opts.journal().Record(entry); err != nil {
+		return fmt.Errorf("refusing to send a transaction that cannot be journaled: %w", err)
+	}
+
+	// The delegated EOA the transaction acts for, the sender differs for sponsored transactions
+	output.SetFrom(requestSource(tx))
+	outputTransaction(entry, nil)
+
+	err = client.SendTransaction(context.Background(), tx)
+	if err != nil {
+		recordStatus(opts.journal(), entry, journal.StatusRejected, nil, err)
+		return output.WithCode(output.CodeRejected, fmt.Errorf("failed to send the transaction: %w", err))
+	}
+
+	color.Cyan("Transaction sent: %s/tx/%s", opts.ExplorerURL, tx.Hash().Hex())
+
+	return TrackTransaction(client, entry, opts)
+}
+
 // BroadcastTransactionFromFile broadcasts a signed transaction from the specified file
-func BroadcastTransactionFromFile(filePath string, configPath string) error {
+func BroadcastTransactionFromFile(filePath string, configPath string, opts BroadcastOptions) error {
 	// Read signed transaction from specified file
 	color.Cyan("Reading transaction from file: %s", filePath)
 	data, err := os.ReadFile(filePath)
@@ -207,17 +426,12 @@ func BroadcastTransactionFromFile(filePath string, configPath string) error {
 
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
-	rpcURL := cfg.RPCUrl
-	if rpcURL == "" {
-		return fmt.Errorf("RPC URL is not set in the config")
+		return output.WithCode(output.CodeConfig, fmt.Errorf("failed to load config: %w", err))
 	}
 
-	client, err := ethclient.Dial(rpcURL)
+	client, err := rpc.Dial(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to connect to the Ethereum client: %w", err)
+		return output.WithCode(output.CodeRPC, fmt.Errorf("failed to connect to the Ethereum client: %w", err))
 	}
 	color.Cyan("Connected to the Ethereum client")
 
@@ -225,27 +439,21 @@ func BroadcastTransactionFromFile(filePath string, configPath string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Send transaction
-	err = client.SendTransaction(ctx, tx)
+	rpcChainID, err := client.ChainID(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to broadcast transaction: %w", err)
+		return fmt.Errorf("failed to get the chain ID: %w", err)
 	}
-
-	color.Cyan("Transaction hash: %s", tx.Hash().Hex())
-
-	color.Cyan("Waiting for transaction to be included in a block...")
-
-	receipt, err := bind.WaitMined(context.Background(), client, tx)
-	if err != nil {
-		return fmt.Errorf("failed to wait for the transaction to be included in a block: %w", err)
+	if err := cfg.VerifyChainID(rpcChainID, opts.ConfirmMainnet); err != nil {
+		return output.WithCode(output.CodeChainMismatch, err)
 	}
-
-	if receipt.Status != types.ReceiptStatusSuccessful {
-		return fmt.Errorf("transaction failed")
+	if rpcChainID.Cmp(chainID) != 0 {
+		return output.WithCode(output.CodeChainMismatch, fmt.Errorf("transaction is for chain ID %s, but the RPC endpoint is on chain ID %s", chainID, rpcChainID))
 	}
 
-	color.Green("Transaction successful")
-
+	// Refuse anything that does not match what the operator expects to send
+	if err := validateSignedTransaction(client, cfg, tx, opts); err != nil {
+		return output.WithCode(output.CodeValidation, fmt.Errorf("refusing to broadcast: %w", err))
+	}
 
-	return nil
-}
\ No newline at end of file
+	return sendAndWait(client, tx, Options{ExplorerURL: cfg.BlockExplorerUrl, Journal: opts.Journal})
+}
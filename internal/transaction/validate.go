@@ -0,0 +1,183 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/journal"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/Luganodes/Pectra-CLI/internal/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/fatih/color"
+)
+
+// BroadcastOptions controls the checks made before a signed transaction is broadcast
+type BroadcastOptions struct {
+	ConfirmMainnet bool
+	// ExpectedFrom is the withdrawal EOA that must have signed the transaction, prompted if zero
+	ExpectedFrom common.Address
+	// AllowedDelegations are accepted as authorization targets in addition to the batch contract,
+	// e.g. to restore a previous delegation
+	AllowedDelegations   []common.Address
+	SkipCodeVerification bool
+	// Journal records the broadcast transaction, the journal at journal.DefaultPath if nil
+	Journal *journal.Journal
+}
+
+// validateSignedTransaction verifies a signed transaction before it is broadcast and prints a
+// decoded summary of it. It refuses transactions that do not match the expected EOA, the
+// allow-listed contracts or the current state of the account.
+func validateSignedTransaction(client rpc.Client, cfg *config.Config, tx *types.Transaction, opts BroadcastOptions) error {
+	if tx.Type() != types.SetCodeTxType {
+		return fmt.Errorf("expected an EIP-7702 SetCode transaction, got transaction type %d", tx.Type())
+	}
+
+	expectedFrom := opts.ExpectedFrom
+	if expectedFrom == (common.Address{}) {
+		addressStr, err := config.GetPublicKey()
+		if err != nil {
+			return fmt.Errorf("failed to get the expected withdrawal address: %w", err)
+		}
+		expectedFrom = common.HexToAddress(addressStr)
+	}
+
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return fmt.Errorf("failed to recover the transaction signer: %w", err)
+	}
+	if from != expectedFrom {
+		return fmt.Errorf("transaction was signed by %s, expected %s", from.Hex(), expectedFrom.Hex())
+	}
+	if to := tx.To(); to == nil || *to != expectedFrom {
+		return fmt.Errorf("transaction must call the withdrawal address %s itself", expectedFrom.Hex())
+	}
+
+	authorizations := tx.SetCodeAuthorizations()
+	if len(authorizations) != 1 {
+		return fmt.Errorf("expected exactly one authorization, got %d", len(authorizations))
+	}
+	authorization := authorizations[0]
+
+	authority, err := authorization.Authority()
+	if err != nil {
+		return fmt.Errorf("failed to recover the authorization signer: %w", err)
+	}
+	if authority != expectedFrom {
+		return fmt.Errorf("authorization was signed by %s, expected %s", authority.Hex(), expectedFrom.Hex())
+	}
+	if authorization.ChainID.ToBig().Cmp(tx.ChainId()) != 0 {
+		return fmt.Errorf("authorization is for chain ID %s, but the transaction is for chain ID %s", authorization.ChainID.ToBig(), tx.ChainId())
+	}
+
+	// The sender's nonce is incremented before the authorization is processed
+	if authorization.Nonce != tx.Nonce()+1 {
+		return fmt.Errorf("authorization nonce %d does not follow the transaction nonce %d", authorization.Nonce, tx.Nonce())
+	}
+
+	contractAddress := common.HexToAddress(cfg.PectraBatchContract)
+	target := authorization.Address
+	switch {
+	case target == contractAddress:
+		if opts.SkipCodeVerification {
+			color.Red("Skipping verification of the batch contract code at %s", contractAddress.Hex())
+		} else if err := utils.VerifyContractCode(client, contractAddress); err != nil {
+			return err
+		}
+	case target == common.Address{}:
+		if len(tx.Data()) > 0 {
+			return fmt.Errorf("transaction unsets the delegation but carries calldata")
+		}
+	case containsAddress(opts.AllowedDelegations, target):
+		if len(tx.Data()) > 0 {
+			return fmt.Errorf("transaction delegates to %s and carries calldata, only restoring a delegation is allowed", target.Hex())
+		}
+	default:
+		return fmt.Errorf("authorization delegates to %s, which is not the configured batch contract %s", target.Hex(), contractAddress.Hex())
+	}
+
+	nonce, err := client.PendingNonceAt(context.Background(), from)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+	if tx.Nonce() != nonce {
+		return fmt.Errorf("transaction nonce %d does not match the current nonce %d of %s", tx.Nonce(), nonce, from.Hex())
+	}
+
+	balance, err := client.BalanceAt(context.Background(), from, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get the balance: %w", err)
+	}
+	if balance.Cmp(tx.Cost()) < 0 {
+		return fmt.Errorf("balance of %s is %s wei, but value plus max gas costs %s wei", from.Hex(), balance, tx.Cost())
+	}
+
+	var call *calldata.Call
+	if len(tx.Data()) > 0 {
+		contractABI, err := config.LoadABI()
+		if err != nil {
+			return err
+		}
+		call, err = calldata.Decode(contractABI, tx.Data())
+		if err != nil {
+			return fmt.Errorf("calldata does not match the batch contract ABI: %w", err)
+		}
+	}
+
+	printTransactionSummary(tx, from, authorization, call)
+	return nil
+}
+
+// printTransactionSummary prints the decoded content of a validated transaction
+func printTransactionSummary(tx *types.Transaction, from common.Address, authorization types.SetCodeAuthorization, call *calldata.Call) {
+	color.Cyan("Transaction summary:")
+	color.White("  From / To:          %s", from.Hex())
+	color.White("  Chain ID:           %s", tx.ChainId())
+	color.White("  Nonce:              %d", tx.Nonce())
+	color.White("  Value:              %s wei", tx.Value())
+	color.White("  Gas limit:          %d", tx.Gas())
+	color.White("  Max fee per gas:    %s gwei", weiToGwei(tx.GasFeeCap()))
+	color.White("  Max priority fee:   %s gwei", weiToGwei(tx.GasTipCap()))
+	color.White("  Max cost:           %s wei", tx.Cost())
+	color.White("  Delegates to:       %s (authorization nonce %d)", authorization.Address.Hex(), authorization.Nonce)
+
+	if call == nil {
+		color.White("  Call:               none")
+		return
+	}
+
+	color.White("  Call:               %s (%d validators)", call.Method, len(call.Requests))
+	for _, request := range call.Requests {
+		switch request.Kind {
+		case calldata.KindConsolidation:
+			color.White("    %s -> %s", request.SourcePubkey, request.TargetPubkey)
+		case calldata.KindWithdrawal:
+			if request.FullExit {
+				color.White("    %s full exit", request.SourcePubkey)
+			} else {
+				color.White("    %s withdraw %d Gwei", request.SourcePubkey, request.Amount)
+			}
+		default:
+			color.White("    %s", request.SourcePubkey)
+		}
+	}
+}
+
+// containsAddress reports whether the address is in the list
+func containsAddress(addresses []common.Address, address common.Address) bool {
+	for _, candidate := range addresses {
+		if candidate == address {
+			return true
+		}
+	}
+	return false
+}
+
+// weiToGwei formats a wei amount in Gwei
+func weiToGwei(wei *big.Int) string {
+	return new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.GWei)).Text('f', 9)
+}
@@ -3,12 +3,11 @@ package utils
 import (
 	"context"
 	"fmt"
-	"math/big"
 
-	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/fatih/color"
 )
 
@@ -33,8 +32,36 @@ func PrintUsage() {
 	color.White("Execute partial or full exits for validators")
 	color.New(color.FgGreen).Print("  unset-code    ")
 	color.White("Unset code for the contract")
+	color.New(color.FgGreen).Print("  assemble      ")
+	color.White("Assemble the transaction for an authorization signed offline")
+	color.New(color.FgGreen).Print("  snapshot      ")
+	color.White("Export chain data for offline transaction preparation")
 	color.New(color.FgGreen).Print("  broadcast     ")
 	color.White("Broadcast a signed transaction")
+	color.New(color.FgGreen).Print("  track         ")
+	color.White("Track a sent transaction by hash until it is mined")
+	color.New(color.FgGreen).Print("  resume        ")
+	color.White("Reattach to pending transactions and resume an interrupted operation")
+	color.New(color.FgGreen).Print("  estimate      ")
+	color.White("Estimate when the requests of an operation will be processed")
+	color.New(color.FgGreen).Print("  cost          ")
+	color.White("Compute the total cost of an operation without any key")
+	color.New(color.FgGreen).Print("  plan          ")
+	color.White("Write a plan of an operation with the exact transaction for approval")
+	color.New(color.FgGreen).Print("  apply         ")
+	color.White("Execute a plan if the current state still matches it")
+	color.New(color.FgGreen).Print("  run-workflow  ")
+	color.White("Run a multi-step workflow waiting for beacon state between steps")
+	color.New(color.FgGreen).Print("  config validate ")
+	color.White("Validate a config file against the config schema")
+	color.New(color.FgGreen).Print("  config check  ")
+	color.White("Find conflicting requests across the operations of a config")
+	color.New(color.FgGreen).Print("  safe          ")
+	color.White("Write an operation as a Safe Transaction Builder batch for a Safe withdrawal address")
+	color.New(color.FgGreen).Print("  safe-hash     ")
+	color.White("Compute the Safe transaction hash of a Transaction Builder file")
+	color.New(color.FgGreen).Print("  watch         ")
+	color.White("Follow the validator requests of a transaction through the beacon chain queues")
 
 	// Global options
 	color.New(color.FgHiWhite, color.Bold).Println("\n🛠️  OPTIONS:")
@@ -44,6 +71,24 @@ func PrintUsage() {
 	color.White("Path to transaction file (required for broadcast command)")
 	color.New(color.FgYellow).Print("  -a, --airgapped ")
 	color.White("Run in airgapped mode")
+	color.New(color.FgYellow).Print("  --confirm-mainnet ")
+	color.White("Acknowledge that the operation targets Ethereum mainnet")
+	color.New(color.FgYellow).Print("  --skip-code-verification ")
+	color.White("Do not verify the batch contract code against audited releases (dangerous)")
+	color.New(color.FgYellow).Print("  --sponsor       ")
+	color.White("Have a separate sponsor key pay for the transaction (unset-code only)")
+	color.New(color.FgYellow).Print("  --authorization-only ")
+	color.White("In airgapped mode, only write the authorization for offline signing")
+	color.New(color.FgYellow).Print("  --group-by-withdrawal-address ")
+	color.White("Run one batch per withdrawal address found in the beacon state")
+	color.New(color.FgYellow).Print("  --dry-run       ")
+	color.White("Validate and simulate the operation without asking for a key or writing files (with --from)")
+	color.New(color.FgYellow).Print("  --offline       ")
+	color.White("Prepare unsigned transactions without RPC access (with --snapshot or --chain-id, --nonce, fee flags)")
+	color.New(color.FgYellow).Print("  --journal       ")
+	color.White("Path to the transaction journal (default pectra_journal.jsonl)")
+	color.New(color.FgYellow).Print("  --output        ")
+	color.White("Output mode, text or json (a single JSON result on stdout, everything else on stderr)")
 	color.New(color.FgYellow).Print("  -h, --help      ")
 	color.White("Show help for command")
 
@@ -52,7 +97,18 @@ func PrintUsage() {
 	color.White("  pectra-cli switch --config config.json")
 	color.White("  pectra-cli consolidate -c config.json -a")
 	color.White("  pectra-cli el-exit --config config.json")
-	color.White("  pectra-cli broadcast --file signed_txn.json")
+	color.White("  pectra-cli switch -c config.json --dry-run --from 0x...")
+	color.White("  pectra-cli broadcast -c config.json --file signed_txn.json --from 0x...")
+	color.White("  pectra-cli resume -c config.json el-exit")
+	color.White("  pectra-cli estimate -c config.json consolidate")
+	color.White("  pectra-cli cost -c config.json --from 0x... --format json el-exit")
+	color.White("  pectra-cli plan -c config.json --from 0x... consolidate")
+	color.White("  pectra-cli apply -c config.json --hash <plan hash> plan.json")
+	color.White("  pectra-cli run-workflow -c config.json sample_workflow.json")
+	color.White("  pectra-cli config validate -c config.json")
+	color.White("  pectra-cli config check -c config.json")
+	color.White("  pectra-cli safe -c config.json --safe 0x... switch")
+	color.White("  pectra-cli safe-hash --nonce 12 safe_batch.json")
 
 	// Configuration details
 	color.New(color.FgHiWhite, color.Bold).Println("\n⚙️  CONFIGURATION FORMAT:")
@@ -111,47 +167,27 @@ func PrintUsage() {
 	color.New(color.FgHiCyan).Println("═════════════════════════════════════════════════════════════════════")
 }
 
-// GetFee calls the getFee function on the contract and returns the fee value
-func GetFee(client *ethclient.Client, contractAddress common.Address, parsedABI abi.ABI, functionName string) (*big.Int, error) {
-	// Pack the function call data
-	data, err := parsedABI.Pack(functionName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to pack data for getFee call: %v", err)
-	}
-
-	// Create a message call
-	msg := ethereum.CallMsg{
-		To:   &contractAddress,
-		Data: data,
+// VerifyContractCode checks that the runtime code deployed at the contract address matches one of
+// the audited Pectra batch contract code hashes
+func VerifyContractCode(client rpc.Client, contractAddress common.Address) error {
+	if err := config.CheckAuditedCodeHashes(); err != nil {
+		return err
 	}
 
-	// Execute the call
-	result, err := client.CallContract(context.Background(), msg, nil)
+	code, err := client.CodeAt(context.Background(), contractAddress, nil)
 	if err != nil {
-		return nil, fmt.Errorf("contract call failed: %v", err)
+		return fmt.Errorf("failed to get the contract code: %v", err)
 	}
 
-	// Unpack the result
-	var fee *big.Int
-	err = parsedABI.UnpackIntoInterface(&fee, functionName, result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unpack result: %v", err)
+	if len(code) == 0 {
+		return fmt.Errorf("no contract code found at %s", contractAddress.Hex())
 	}
 
-	return fee, nil
-}
-
-// RemoveDuplicateValidators takes a slice of validator strings and returns a new slice
-// containing only unique validators.
-func RemoveDuplicateValidators(validators []string) []string {
-	seen := make(map[string]bool)
-	result := []string{}
-
-	for _, validator := range validators {
-		if _, ok := seen[validator]; !ok {
-			seen[validator] = true
-			result = append(result, validator)
-		}
+	codeHash := crypto.Keccak256Hash(code)
+	if !config.IsAuditedCodeHash(codeHash) {
+		return fmt.Errorf("code at %s (hash %s) does not match any audited Pectra batch contract", contractAddress.Hex(), codeHash.Hex())
 	}
-	return result
+
+	color.Green("Verified Pectra batch contract code at %s (hash %s)", contractAddress.Hex(), codeHash.Hex())
+	return nil
 }
@@ -0,0 +1,373 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/internal/confirmation"
+	"github.com/Luganodes/Pectra-CLI/internal/output"
+	"github.com/Luganodes/Pectra-CLI/internal/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
+)
+
+// Stages of a validator request on the beacon chain. A request is dropped when it did not reach the
+// system contract, and unconfirmed when it left the beacon state unchanged although the system
+// contract queue was emptied.
+const (
+	StageRequested   = "requested"
+	StageQueued      = "queued"
+	StageProcessed   = "processed"
+	StageDropped     = "dropped"
+	StageUnconfirmed = "unconfirmed"
+)
+
+// unconfirmedAfterEpochs is how many epochs a request may leave the beacon state unchanged once the
+// queue of its system contract is empty, before it is reported as unconfirmed. The beacon chain
+// applies dequeued requests within the block they are dequeued in.
+const unconfirmedAfterEpochs = 4
+
+// Progress is the beacon chain progress of a single validator request
+type Progress struct {
+	Kind           string     `json:"kind"`
+	Pubkey         string     `json:"pubkey"`
+	Target         string     `json:"target,omitempty"`
+	Amount         uint64     `json:"amountGwei,omitempty"`
+	FullExit       bool       `json:"fullExit,omitempty"`
+	ValidatorIndex *uint64    `json:"validatorIndex,omitempty"`
+	Stage          string     `json:"stage"`
+	Detail         string     `json:"detail,omitempty"`
+	ETAEpoch       uint64     `json:"etaEpoch,omitempty"`
+	ETA            *time.Time `json:"eta,omitempty"`
+
+	// seenQueued records that the request was seen in a beacon queue, so that leaving it means processed
+	seenQueued bool
+	// inRequestQueue records that the request is queued because its system contract queue is not empty
+	inRequestQueue bool
+}
+
+// Report is the progress of every validator request of a transaction
+type Report struct {
+	TxHash         common.Hash `json:"txHash"`
+	InclusionEpoch uint64      `json:"inclusionEpoch"`
+	Epoch          uint64      `json:"epoch"`
+	UpdatedAt      time.Time   `json:"updatedAt"`
+	Validators     []*Progress `json:"validators"`
+}
+
+// Watcher follows the validator requests of a mined transaction through the system contract and
+// beacon chain queues
+type Watcher struct {
+	beacon    *beacon.Client
+	execution rpc.Client
+	genesis   time.Time
+	report    Report
+	// emptySince is the epoch each system contract queue was first seen empty
+	emptySince map[common.Address]uint64
+}
+
+// New creates a watcher for the requests of the transaction included at the given time. Requests
+// that did not reach the system contracts are dropped from the start. The system contract queues
+// are read from the execution client, without it they are assumed empty since inclusion.
+func New(beaconClient *beacon.Client, execution rpc.Client, txHash common.Hash, results []confirmation.Result, includedAt time.Time) (*Watcher, error) {
+	genesis, err := beaconClient.GetGenesisTime()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		beacon:    beaconClient,
+		execution: execution,
+		genesis:   genesis,
+		report: Report{
+			TxHash:         txHash,
+			InclusionEpoch: beacon.EpochAt(genesis, includedAt),
+		},
+		emptySince: make(map[common.Address]uint64),
+	}
+
+	for _, result := range results {
+		request := result.Request
+		progress := &Progress{
+			Kind:     request.Kind,
+			Pubkey:   beacon.NormalizePubkey(request.SourcePubkey),
+			Amount:   request.Amount,
+			FullExit: request.FullExit,
+			Stage:    StageRequested,
+		}
+		if request.Kind == calldata.KindConsolidation {
+			progress.Target = beacon.NormalizePubkey(request.TargetPubkey)
+		}
+		if result.Status != confirmation.StatusConfirmed {
+			progress.Stage = StageDropped
+			progress.Detail = "request did not reach the system contract"
+		}
+		w.report.Validators = append(w.report.Validators, progress)
+	}
+
+	return w, nil
+}
+
+// Poll reads the beacon state and updates the progress of the requests that are not final yet
+func (w *Watcher) Poll() error {
+	epoch, err := w.beacon.GetCurrentEpoch()
+	if err != nil {
+		return err
+	}
+
+	var pubkeys []string
+	needsConsolidations, needsPartials := false, false
+	for _, progress := range w.report.Validators {
+		if progress.final() {
+			continue
+		}
+		pubkeys = append(pubkeys, progress.Pubkey)
+		switch {
+		case progress.Kind == calldata.KindConsolidation:
+			needsConsolidations = true
+		case progress.Kind == calldata.KindWithdrawal && !progress.FullExit:
+			needsPartials = true
+		}
+	}
+
+	w.report.Epoch = epoch
+	w.report.UpdatedAt = time.Now().UTC()
+	if len(pubkeys) == 0 {
+		return nil
+	}
+
+	validators, err := w.beacon.GetValidators(pubkeys)
+	if err != nil {
+		return err
+	}
+	byPubkey := make(map[string]*beacon.Validator, len(validators))
+	for i := range validators {
+		byPubkey[beacon.NormalizePubkey(validators[i].Validator.Pubkey)] = &validators[i]
+	}
+
+	var consolidations []beacon.PendingConsolidation
+	if needsConsolidations {
+		if consolidations, err = w.beacon.GetPendingConsolidations(); err != nil {
+			return err
+		}
+	}
+
+	var partials []beacon.PendingPartialWithdrawal
+	if needsPartials {
+		if partials, err = w.beacon.GetPendingPartialWithdrawals(); err != nil {
+			return err
+		}
+	}
+
+	backlogs, err := w.requestQueues(epoch)
+	if err != nil {
+		return err
+	}
+
+	for _, progress := range w.report.Validators {
+		if progress.final() {
+			continue
+		}
+		if progress.inRequestQueue {
+			progress.Stage = StageRequested
+			progress.Detail = ""
+			progress.inRequestQueue = false
+		}
+
+		validator, ok := byPubkey[progress.Pubkey]
+		if !ok {
+			progress.Detail = "validator is unknown to the beacon node"
+		} else {
+			index := validator.Index
+			progress.ValidatorIndex = &index
+			w.update(progress, validator, epoch, consolidations, partials)
+		}
+
+		if progress.Stage != StageRequested {
+			continue
+		}
+
+		// The request may still wait in the system contract queue, which only dequeues a few requests
+		// per block
+		predeploy := confirmation.Predeploy(progress.Kind)
+		if backlog := backlogs[predeploy]; backlog > 0 {
+			progress.Stage = StageQueued
+			progress.Detail = fmt.Sprintf("possibly waiting in the system contract queue of %s, %d request(s) pending", predeploy.Hex(), backlog)
+			progress.inRequestQueue = true
+			continue
+		}
+		if since := w.emptySince[predeploy]; epoch > since+unconfirmedAfterEpochs {
+			progress.Stage = StageUnconfirmed
+			progress.Detail = fmt.Sprintf("no effect on the beacon state %d epochs after the system contract queue emptied", epoch-since)
+			if progress.Kind == calldata.KindWithdrawal && !progress.FullExit {
+				progress.Detail += ", unless it was processed before watching started"
+			}
+		}
+	}
+
+	return nil
+}
+
+// requestQueues returns the number of requests waiting in each system contract queue and records
+// since when each queue is empty. Without an execution client the queues are assumed empty since
+// inclusion.
+func (w *Watcher) requestQueues(epoch uint64) (map[common.Address]uint64, error) {
+	backlogs := make(map[common.Address]uint64)
+	for _, predeploy := range []common.Address{confirmation.WithdrawalRequestPredeploy, confirmation.ConsolidationRequestPredeploy} {
+		if w.execution == nil {
+			w.emptySince[predeploy] = w.report.InclusionEpoch
+			continue
+		}
+
+		backlog, err := confirmation.QueueLength(w.execution, predeploy)
+		if err != nil {
+			return nil, err
+		}
+		backlogs[predeploy] = backlog
+		if backlog > 0 {
+			delete(w.emptySince, predeploy)
+		} else if _, ok := w.emptySince[predeploy]; !ok {
+			w.emptySince[predeploy] = epoch
+		}
+	}
+	return backlogs, nil
+}
+
+// update derives the stage of a request from the validator and the beacon queues
+func (w *Watcher) update(progress *Progress, validator *beacon.Validator, epoch uint64, consolidations []beacon.PendingConsolidation, partials []beacon.PendingPartialWithdrawal) {
+	details := validator.Validator
+
+	switch {
+	case progress.Kind == calldata.KindSwitch:
+		if validator.WithdrawalPrefix() == beacon.CompoundingWithdrawalPrefix {
+			progress.setProcessed("withdrawal credentials switched to 0x02")
+		}
+
+	case progress.Kind == calldata.KindConsolidation:
+		queued := false
+		for _, consolidation := range consolidations {
+			if consolidation.SourceIndex == validator.Index {
+				queued = true
+				break
+			}
+		}
+
+		switch {
+		case queued:
+			progress.seenQueued = true
+			w.setQueued(progress, details.WithdrawableEpoch, fmt.Sprintf("in pending consolidations, balance moves to the target at epoch %d", details.WithdrawableEpoch))
+		case progress.seenQueued || (validator.IsExiting() && epoch >= details.WithdrawableEpoch):
+			progress.setProcessed(fmt.Sprintf("balance moved to the target, source balance is %d Gwei", validator.Balance))
+		case validator.IsExiting():
+			progress.Stage = StageDropped
+			progress.Detail = "source is exiting but not in the pending consolidations queue"
+		}
+
+	case progress.FullExit:
+		switch {
+		case validator.IsExiting() && epoch >= details.ExitEpoch:
+			progress.setProcessed(fmt.Sprintf("exited at epoch %d, withdrawable at epoch %d", details.ExitEpoch, details.WithdrawableEpoch))
+		case validator.IsExiting():
+			w.setQueued(progress, details.ExitEpoch, fmt.Sprintf("in the exit queue, withdrawable at epoch %d", details.WithdrawableEpoch))
+		}
+
+	default:
+		var pending *beacon.PendingPartialWithdrawal
+		for i := range partials {
+			if partials[i].ValidatorIndex == validator.Index {
+				pending = &partials[i]
+				break
+			}
+		}
+
+		switch {
+		case pending != nil:
+			progress.seenQueued = true
+			w.setQueued(progress, pending.WithdrawableEpoch, fmt.Sprintf("in pending partial withdrawals for %d Gwei", pending.Amount))
+		case progress.seenQueued:
+			progress.setProcessed(fmt.Sprintf("withdrawn, balance is %d Gwei", validator.Balance))
+		}
+	}
+}
+
+// setQueued marks the request as waiting in a beacon queue until the given epoch
+func (w *Watcher) setQueued(progress *Progress, etaEpoch uint64, detail string) {
+	progress.Stage = StageQueued
+	progress.Detail = detail
+	progress.ETAEpoch = etaEpoch
+	eta := beacon.EpochTime(w.genesis, etaEpoch).UTC()
+	progress.ETA = &eta
+}
+
+// setProcessed marks the request as processed
+func (p *Progress) setProcessed(detail string) {
+	p.Stage = StageProcessed
+	p.Detail = detail
+	p.ETA = nil
+	p.ETAEpoch = 0
+}
+
+// final reports whether the request reached a stage it cannot leave
+func (p *Progress) final() bool {
+	return p.Stage == StageProcessed || p.Stage == StageDropped || p.Stage == StageUnconfirmed
+}
+
+// Done reports whether every request was processed, dropped or unconfirmed
+func (w *Watcher) Done() bool {
+	for _, progress := range w.report.Validators {
+		if !progress.final() {
+			return false
+		}
+	}
+	return true
+}
+
+// Report returns the current progress of the requests
+func (w *Watcher) Report() *Report {
+	return &w.report
+}
+
+// Print prints the current progress of every request
+func (w *Watcher) Print() {
+	color.Cyan("Epoch %d (transaction included in epoch %d):", w.report.Epoch, w.report.InclusionEpoch)
+	for _, progress := range w.report.Validators {
+		line := fmt.Sprintf("  %-13s %s %-10s", progress.Kind, progress.Pubkey, progress.Stage)
+		if progress.ETA != nil {
+			line += fmt.Sprintf(" ETA epoch %d (%s)", progress.ETAEpoch, progress.ETA.Format(time.RFC3339))
+		}
+		if progress.Detail != "" {
+			line += " - " + progress.Detail
+		}
+
+		switch progress.Stage {
+		case StageProcessed:
+			color.Green("%s", line)
+		case StageDropped, StageUnconfirmed:
+			color.Red("%s", line)
+		case StageQueued:
+			color.Cyan("%s", line)
+		default:
+			color.Yellow("%s", line)
+		}
+	}
+}
+
+// WriteReport writes the report to a JSON file
+func WriteReport(report *Report, fileName string) error {
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report to JSON: %w", err)
+	}
+
+	if err := os.WriteFile(fileName, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write report to file: %w", err)
+	}
+
+	color.Green("Report written to %s", fileName)
+	output.AddFile(fileName)
+	return nil
+}
@@ -0,0 +1,446 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Luganodes/Pectra-CLI/internal/beacon"
+	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/journal"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+	"github.com/fatih/color"
+)
+
+// Operations that can be used as workflow steps
+const (
+	OperationSwitch      = "switch"
+	OperationConsolidate = "consolidate"
+	OperationELExit      = "el-exit"
+	OperationUnsetCode   = "unset-code"
+)
+
+// DefaultTimeout is how long a step waits for its conditions when it sets no timeout
+const DefaultTimeout = 72 * time.Hour
+
+// Workflow is a sequence of operations run in order, each waiting for beacon state conditions
+// before it starts
+type Workflow struct {
+	Steps []Step `json:"steps"`
+}
+
+// Step is a single operation of a workflow. Only the config section of its operation is used.
+type Step struct {
+	Name        string                    `json:"name"`
+	Operation   string                    `json:"operation"`
+	Switch      *config.SwitchConfig      `json:"switch,omitempty"`
+	Consolidate *config.ConsolidateConfig `json:"consolidate,omitempty"`
+	ELExit      *config.ELExitConfig      `json:"elExit,omitempty"`
+	// WaitFor must hold on the beacon chain before the step runs
+	WaitFor []Condition `json:"waitFor,omitempty"`
+	// Timeout is how long to wait for the conditions, as a Go duration such as "48h"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Condition is a predicate on the beacon state of a validator. Every criterion that is set must hold.
+type Condition struct {
+	Validator string `json:"validator"`
+	// Credentials is the withdrawal credentials type, "0x01" or "0x02"
+	Credentials string `json:"credentials,omitempty"`
+	// Status lists the accepted beacon statuses, such as active_ongoing or withdrawal_done
+	Status         []string `json:"status,omitempty"`
+	MinBalanceGwei *uint64  `json:"minBalanceGwei,omitempty"`
+	MaxBalanceGwei *uint64  `json:"maxBalanceGwei,omitempty"`
+	// NoPendingConsolidation requires the validator to be neither source nor target of a pending consolidation
+	NoPendingConsolidation bool `json:"noPendingConsolidation,omitempty"`
+}
+
+// Runner executes the operation of a step, recording its transactions under the journal run.
+// resuming is set when the step was started before and did not complete, so requests that its run
+// already confirmed should be skipped.
+type Runner func(step Step, run string, resuming bool) error
+
+// Load reads and validates a workflow file
+func Load(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflow file: %w", err)
+	}
+
+	// The sections of the steps are checked like those of a config file, so a typo in a field name
+	// cannot leave a step empty
+	if problems := config.ValidateOperationSections(data, "steps"); len(problems) > 0 {
+		return nil, &config.ValidationError{File: path, Problems: problems}
+	}
+
+	var w Workflow
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&w); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow file: %w", err)
+	}
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Validate checks the steps and their conditions without any chain access
+func (w *Workflow) Validate() error {
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("the workflow has no steps")
+	}
+
+	names := make(map[string]bool)
+	for i, step := range w.Steps {
+		if step.Name == "" {
+			return fmt.Errorf("step %d has no name", i+1)
+		}
+		// Progress is recorded by step name, so names must be unique
+		if names[step.Name] {
+			return fmt.Errorf("step name %q is used more than once", step.Name)
+		}
+		names[step.Name] = true
+
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %q: %w", step.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the step carries the section of its operation and valid conditions
+func (s Step) validate() error {
+	switch s.Operation {
+	case OperationSwitch:
+		if s.Switch == nil {
+			return fmt.Errorf("the switch section is missing")
+		}
+		if err := pectra.ValidatePubkeys(s.Switch.Validators); err != nil {
+			return fmt.Errorf("invalid public key in switch.validators: %w", err)
+		}
+	case OperationConsolidate:
+		if s.Consolidate == nil {
+			return fmt.Errorf("the consolidate section is missing")
+		}
+		if err := pectra.ValidatePubkey(s.Consolidate.TargetValidator); err != nil {
+			return fmt.Errorf("invalid consolidate.targetValidator %s: %w", s.Consolidate.TargetValidator, err)
+		}
+		if err := pectra.ValidatePubkeys(s.Consolidate.SourceValidators); err != nil {
+			return fmt.Errorf("invalid public key in consolidate.sourceValidators: %w", err)
+		}
+	case OperationELExit:
+		if s.ELExit == nil {
+			return fmt.Errorf("the elExit section is missing")
+		}
+		// The keys of a map have no order, they are reported sorted
+		pubkeys := make([]string, 0, len(s.ELExit.Validators))
+		for pubkey := range s.ELExit.Validators {
+			pubkeys = append(pubkeys, pubkey)
+		}
+		sort.Strings(pubkeys)
+		if err := pectra.ValidatePubkeyKeys(pubkeys); err != nil {
+			return fmt.Errorf("invalid public key in elExit.validators: %w", err)
+		}
+	case OperationUnsetCode:
+	default:
+		return fmt.Errorf("unknown operation %q, expected switch, consolidate, el-exit or unset-code", s.Operation)
+	}
+
+	if _, err := s.timeout(); err != nil {
+		return err
+	}
+
+	for i, condition := range s.WaitFor {
+		if err := condition.validate(); err != nil {
+			return fmt.Errorf("condition %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// timeout returns how long the step waits for its conditions
+func (s Step) timeout() (time.Duration, error) {
+	if s.Timeout == "" {
+		return DefaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", s.Timeout)
+	}
+	return timeout, nil
+}
+
+// Config returns the configuration running the step: the network settings of base and only the
+// operation sections of the step, so the sections of base are neither run nor checked for conflicts
+func (s Step) Config(base *config.Config) *config.Config {
+	cfg := *base
+	cfg.Switch = config.SwitchConfig{}
+	cfg.Consolidate = config.ConsolidateConfig{}
+	cfg.ELExit = config.ELExitConfig{}
+	if s.Switch != nil {
+		cfg.Switch = *s.Switch
+	}
+	if s.Consolidate != nil {
+		cfg.Consolidate = *s.Consolidate
+	}
+	if s.ELExit != nil {
+		cfg.ELExit = *s.ELExit
+	}
+	return &cfg
+}
+
+// NeedsBeacon reports whether any step waits for beacon state
+func (w *Workflow) NeedsBeacon() bool {
+	for _, step := range w.Steps {
+		if len(step.WaitFor) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the condition names a validator and at least one criterion
+func (c Condition) validate() error {
+	if err := pectra.ValidatePubkey(c.Validator); err != nil {
+		return fmt.Errorf("invalid validator %s: %w", c.Validator, err)
+	}
+	if c.Credentials != "" && c.Credentials != "0x01" && c.Credentials != "0x02" {
+		return fmt.Errorf("invalid credentials %q, expected 0x01 or 0x02", c.Credentials)
+	}
+	if c.Credentials == "" && len(c.Status) == 0 && c.MinBalanceGwei == nil && c.MaxBalanceGwei == nil && !c.NoPendingConsolidation {
+		return fmt.Errorf("no criterion set for validator %s", c.Validator)
+	}
+	return nil
+}
+
+// check evaluates the condition against the validator and the pending consolidations. It returns
+// the reason why the condition does not hold yet, or an empty string.
+func (c Condition) check(validator *beacon.Validator, pending []beacon.PendingConsolidation) string {
+	if validator == nil {
+		return "not known to the beacon node"
+	}
+	if c.Credentials != "" {
+		prefix := fmt.Sprintf("0x%02x", validator.WithdrawalPrefix())
+		if prefix != c.Credentials {
+			return fmt.Sprintf("credentials are %s, waiting for %s", prefix, c.Credentials)
+		}
+	}
+	if len(c.Status) > 0 && !contains(c.Status, validator.Status) {
+		return fmt.Sprintf("status is %s, waiting for %s", validator.Status, strings.Join(c.Status, " or "))
+	}
+	if c.MinBalanceGwei != nil && validator.Balance < *c.MinBalanceGwei {
+		return fmt.Sprintf("balance is %d Gwei, waiting for at least %d", validator.Balance, *c.MinBalanceGwei)
+	}
+	if c.MaxBalanceGwei != nil && validator.Balance > *c.MaxBalanceGwei {
+		return fmt.Sprintf("balance is %d Gwei, waiting for at most %d", validator.Balance, *c.MaxBalanceGwei)
+	}
+	if c.NoPendingConsolidation {
+		for _, consolidation := range pending {
+			if consolidation.SourceIndex == validator.Index || consolidation.TargetIndex == validator.Index {
+				return "a consolidation is still pending"
+			}
+		}
+	}
+	return ""
+}
+
+// contains reports whether the status is in the list
+func contains(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait polls the beacon state until every condition of the step holds or the step times out
+func Wait(beaconClient *beacon.Client, step Step, interval time.Duration) error {
+	if len(step.WaitFor) == 0 {
+		return nil
+	}
+	timeout, err := step.timeout()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		unmet, err := unmetConditions(beaconClient, step.WaitFor)
+		if err != nil {
+			color.Yellow("Failed to read the beacon state, retrying: %v", err)
+		} else if len(unmet) == 0 {
+			color.Green("All conditions of step %q hold", step.Name)
+			return nil
+		} else {
+			for _, reason := range unmet {
+				color.White("  %s", reason)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the conditions of step %q did not hold within %s", step.Name, timeout)
+		}
+		color.Cyan("Waiting %s before checking the conditions of step %q again", interval, step.Name)
+		time.Sleep(interval)
+	}
+}
+
+// unmetConditions returns a description of every condition that does not hold yet
+func unmetConditions(beaconClient *beacon.Client, conditions []Condition) ([]string, error) {
+	pubkeys := make([]string, 0, len(conditions))
+	needsPending := false
+	for _, condition := range conditions {
+		pubkeys = append(pubkeys, condition.Validator)
+		needsPending = needsPending || condition.NoPendingConsolidation
+	}
+
+	validators, err := beaconClient.GetValidators(pubkeys)
+	if err != nil {
+		return nil, err
+	}
+	byPubkey := make(map[string]*beacon.Validator, len(validators))
+	for i := range validators {
+		byPubkey[beacon.NormalizePubkey(validators[i].Validator.Pubkey)] = &validators[i]
+	}
+
+	var pending []beacon.PendingConsolidation
+	if needsPending {
+		if pending, err = beaconClient.GetPendingConsolidations(); err != nil {
+			return nil, err
+		}
+	}
+
+	var unmet []string
+	for _, condition := range conditions {
+		if reason := condition.check(byPubkey[beacon.NormalizePubkey(condition.Validator)], pending); reason != "" {
+			unmet = append(unmet, fmt.Sprintf("%s: %s", condition.Validator, reason))
+		}
+	}
+	return unmet, nil
+}
+
+// Run runs the steps that are not completed yet in order, waiting for the conditions of each step
+// and recording the progress after each of them
+func (w *Workflow) Run(beaconClient *beacon.Client, progress *Progress, interval time.Duration, run Runner) error {
+	for i, step := range w.Steps {
+		if progress.IsCompleted(step.Name) {
+			color.Green("Step %d/%d %q was already completed", i+1, len(w.Steps), step.Name)
+			continue
+		}
+
+		color.Cyan("Step %d/%d %q: %s", i+1, len(w.Steps), step.Name, step.Operation)
+		if err := Wait(beaconClient, step, interval); err != nil {
+			return err
+		}
+
+		resuming := progress.Started == step.Name
+		if !resuming {
+			progress.Started = step.Name
+			progress.Run = journal.NewRun()
+		}
+		if err := progress.Save(); err != nil {
+			return err
+		}
+
+		if err := run(step, progress.Run, resuming); err != nil {
+			return fmt.Errorf("step %q failed: %w", step.Name, err)
+		}
+
+		progress.complete(step)
+		if err := progress.Save(); err != nil {
+			return err
+		}
+	}
+
+	color.Green("Workflow completed")
+	return nil
+}
+
+// Progress records the steps of a workflow that were completed, so that it can be resumed
+type Progress struct {
+	// Started is the step that was started last and has not completed
+	Started string `json:"started,omitempty"`
+	// Run is the journal run of the started step, the transactions it sent are recorded under it
+	Run       string          `json:"run,omitempty"`
+	Completed []CompletedStep `json:"completed"`
+
+	path string
+}
+
+// CompletedStep is a step that ran successfully
+type CompletedStep struct {
+	Name        string    `json:"name"`
+	Operation   string    `json:"operation"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// ProgressPath returns the default progress file of a workflow file
+func ProgressPath(workflowPath string) string {
+	return strings.TrimSuffix(workflowPath, ".json") + ".progress.json"
+}
+
+// LoadProgress reads the progress file, a missing file means no step was run yet
+func LoadProgress(path string) (*Progress, error) {
+	progress := &Progress{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, fmt.Errorf("failed to parse progress file %s: %w", path, err)
+	}
+	return progress, nil
+}
+
+// Save writes the progress file
+func (p *Progress) Save() error {
+	jsonData, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal progress to JSON: %w", err)
+	}
+	if err := os.WriteFile(p.path, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write progress file: %w", err)
+	}
+	return nil
+}
+
+// Done reports whether every step of the workflow was completed
+func (p *Progress) Done(w *Workflow) bool {
+	for _, step := range w.Steps {
+		if !p.IsCompleted(step.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCompleted reports whether the step completed in a previous run
+func (p *Progress) IsCompleted(name string) bool {
+	for _, step := range p.Completed {
+		if step.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// complete records the step as completed
+func (p *Progress) complete(step Step) {
+	p.Started = ""
+	p.Run = ""
+	p.Completed = append(p.Completed, CompletedStep{
+		Name:        step.Name,
+		Operation:   step.Operation,
+		CompletedAt: time.Now().UTC(),
+	})
+}
@@ -0,0 +1,12 @@
+//go:build cgo
+
+package pectra
+
+import blst "github.com/supranational/blst/bindings/go"
+
+// validG1Point reports whether the bytes are a compressed BLS12-381 G1 point of the prime order
+// subgroup other than the point at infinity, the only valid validator public keys
+func validG1Point(compressed []byte) bool {
+	point := new(blst.P1Affine).Uncompress(compressed)
+	return point != nil && point.KeyValidate()
+}
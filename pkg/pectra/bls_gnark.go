@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package pectra
+
+import bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+
+// validG1Point reports whether the bytes are a compressed BLS12-381 G1 point of the prime order
+// subgroup other than the point at infinity, the only valid validator public keys. blst needs cgo,
+// so builds without it check the point with gnark-crypto.
+func validG1Point(compressed []byte) bool {
+	var point bls12381.G1Affine
+	if _, err := point.SetBytes(compressed); err != nil {
+		return false
+	}
+	return !point.IsInfinity()
+}
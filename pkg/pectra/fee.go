@@ -0,0 +1,30 @@
+package pectra
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// GetFee calls a fee function of the batch contract and returns the current fee per request in wei
+func GetFee(ctx context.Context, caller ethereum.ContractCaller, contractABI abi.ABI, contract common.Address, functionName string) (*big.Int, error) {
+	data, err := contractABI.Pack(functionName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack data for %s call: %v", functionName, err)
+	}
+
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("contract call failed: %v", err)
+	}
+
+	var fee *big.Int
+	if err := contractABI.UnpackIntoInterface(&fee, functionName, result); err != nil {
+		return nil, fmt.Errorf("failed to unpack result: %v", err)
+	}
+	return fee, nil
+}
@@ -0,0 +1,57 @@
+// Package pectra builds calls to the Pectra batch contract and the EIP-7702 SetCode transactions
+// that delegate a withdrawal EOA to it. Nothing in this package signs or sends transactions, the
+// builders return unsigned transactions together with the metadata needed to review them.
+package pectra
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+//go:embed abi.json
+var abiFile []byte
+
+// Batch contract methods making validator requests
+const (
+	MethodSwitch        = "batchSwitch"
+	MethodConsolidation = "batchConsolidation"
+	MethodELExit        = "batchELExit"
+)
+
+// Batch contract functions returning the current fee per request
+const (
+	ConsolidationFeeFunction = "getConsolidationFee"
+	ExitFeeFunction          = "getExitFee"
+)
+
+// Maximum number of validators of a single batch call
+const (
+	MaxSwitchValidators        = 200
+	MaxConsolidationValidators = 63
+	MaxELExitValidators        = 200
+)
+
+// ErrSponsoredCall is returned for a batch call sent by another account than the delegated EOA. The
+// batch contract only accepts calls the EOA makes to itself and reverts with Unauthorized() for any
+// other sender, so a sponsor can pay for a delegation change but not for validator requests.
+var ErrSponsoredCall = errors.New("the Pectra batch contract only accepts calls from the delegated EOA itself, a sponsored transaction cannot make validator requests")
+
+// PubkeyLength is the length in bytes of a compressed BLS validator public key
+const PubkeyLength = 48
+
+// DefaultGasLimit is the gas limit of transactions whose authorization is not signed yet, so their
+// gas usage cannot be estimated
+const DefaultGasLimit = uint64(30000000)
+
+// ABI returns the ABI of the Pectra batch contract
+func ABI() (abi.ABI, error) {
+	contractABI, err := abi.JSON(strings.NewReader(string(abiFile)))
+	if err != nil {
+		return abi.ABI{}, fmt.Errorf("failed to parse ABI: %v", err)
+	}
+	return contractABI, nil
+}
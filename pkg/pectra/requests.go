@@ -0,0 +1,218 @@
+package pectra
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Batch is a set of validator requests made with a single batch contract call
+type Batch interface {
+	// Method is the batch contract method called
+	Method() string
+	// FeeFunction is the batch contract function returning the fee paid for each request
+	FeeFunction() string
+	// Len is the number of requests of the batch
+	Len() int
+	// Validate checks the requests without any chain access
+	Validate() error
+	// Pack encodes the batch contract call
+	Pack(contractABI abi.ABI) ([]byte, error)
+}
+
+// SwitchBatch switches the withdrawal credentials of validators from 0x01 to 0x02
+type SwitchBatch struct {
+	Validators []string
+}
+
+// ConsolidationBatch consolidates the source validators into the target validator
+type ConsolidationBatch struct {
+	Sources []string
+	Target  string
+}
+
+// Exit is a partial withdrawal or full exit of a single validator
+type Exit struct {
+	Pubkey string
+	// AmountGwei is the amount to withdraw, it must be 0 for full exits
+	AmountGwei uint64
+	FullExit   bool
+}
+
+// ELExitBatch makes execution layer triggered partial withdrawals and full exits
+type ELExitBatch struct {
+	Exits []Exit
+}
+
+// Call is a validated and packed batch contract call
+type Call struct {
+	Method string
+	Data   []byte
+	// Requests is the number of validator requests made by the call
+	Requests int
+	// FeePerRequest and Value are in wei, the call must be sent with Value
+	FeePerRequest *big.Int
+	Value         *big.Int
+}
+
+// BuildCall validates and packs the batch, paying feePerRequest for each of its requests
+func BuildCall(contractABI abi.ABI, batch Batch, feePerRequest *big.Int) (*Call, error) {
+	if err := batch.Validate(); err != nil {
+		return nil, err
+	}
+	if feePerRequest == nil || feePerRequest.Sign() < 0 {
+		return nil, fmt.Errorf("invalid fee per request %v", feePerRequest)
+	}
+
+	data, err := batch.Pack(contractABI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack the data: %w", err)
+	}
+
+	return &Call{
+		Method:        batch.Method(),
+		Data:          data,
+		Requests:      batch.Len(),
+		FeePerRequest: feePerRequest,
+		Value:         new(big.Int).Mul(feePerRequest, big.NewInt(int64(batch.Len()))),
+	}, nil
+}
+
+// Method implements Batch
+func (b SwitchBatch) Method() string { return MethodSwitch }
+
+// FeeFunction implements Batch
+func (b SwitchBatch) FeeFunction() string { return ConsolidationFeeFunction }
+
+// Len implements Batch
+func (b SwitchBatch) Len() int { return len(b.Validators) }
+
+// Validate implements Batch
+func (b SwitchBatch) Validate() error {
+	if len(b.Validators) == 0 {
+		return fmt.Errorf("no validators specified for switch operation")
+	}
+	if len(b.Validators) > MaxSwitchValidators {
+		return fmt.Errorf("a maximum of %d validators can be switched at a time", MaxSwitchValidators)
+	}
+	if err := ValidatePubkeys(b.Validators); err != nil {
+		return fmt.Errorf("invalid source validator public key: %w", err)
+	}
+	return nil
+}
+
+// Pack implements Batch
+func (b SwitchBatch) Pack(contractABI abi.ABI) ([]byte, error) {
+	return contractABI.Pack(MethodSwitch, pubkeyBytes(b.Validators))
+}
+
+// Method implements Batch
+func (b ConsolidationBatch) Method() string { return MethodConsolidation }
+
+// FeeFunction implements Batch
+func (b ConsolidationBatch) FeeFunction() string { return ConsolidationFeeFunction }
+
+// Len implements Batch
+func (b ConsolidationBatch) Len() int { return len(b.Sources) }
+
+// Validate implements Batch
+func (b ConsolidationBatch) Validate() error {
+	if len(b.Sources) == 0 || b.Target == "" {
+		return fmt.Errorf("source or target validators not specified for consolidate operation")
+	}
+	if len(b.Sources) > MaxConsolidationValidators {
+		return fmt.Errorf("a maximum of %d validators can be consolidated at a time", MaxConsolidationValidators)
+	}
+	if err := ValidatePubkeys(b.Sources); err != nil {
+		return fmt.Errorf("invalid source validator public key: %w", err)
+	}
+	if err := ValidatePubkey(b.Target); err != nil {
+		return fmt.Errorf("invalid target validator public key %s: %w", b.Target, err)
+	}
+	for _, source := range b.Sources {
+		if SamePubkey(source, b.Target) {
+			return fmt.Errorf("target validator (%s) cannot be in the list of source validators", b.Target)
+		}
+	}
+	return nil
+}
+
+// Pack implements Batch
+func (b ConsolidationBatch) Pack(contractABI abi.ABI) ([]byte, error) {
+	return contractABI.Pack(MethodConsolidation, pubkeyBytes(b.Sources), common.FromHex(b.Target))
+}
+
+// Method implements Batch
+func (b ELExitBatch) Method() string { return MethodELExit }
+
+// FeeFunction implements Batch
+func (b ELExitBatch) FeeFunction() string { return ExitFeeFunction }
+
+// Len implements Batch
+func (b ELExitBatch) Len() int { return len(b.Exits) }
+
+// Validate implements Batch
+func (b ELExitBatch) Validate() error {
+	if len(b.Exits) == 0 {
+		return fmt.Errorf("no validators specified for EL exit operation")
+	}
+	if len(b.Exits) > MaxELExitValidators {
+		return fmt.Errorf("a maximum of %d validators can be exited at a time", MaxELExitValidators)
+	}
+
+	// The exits come from the keys of a map, so their order is not the one of the file and each
+	// invalid key is reported by itself rather than by position
+	pubkeys := make([]string, 0, len(b.Exits))
+	for _, exit := range b.Exits {
+		pubkeys = append(pubkeys, exit.Pubkey)
+	}
+	if err := ValidatePubkeyKeys(pubkeys); err != nil {
+		return fmt.Errorf("validator public key validation failed: %w", err)
+	}
+	// The amounts of one validator listed twice cannot be merged
+	if duplicates := DuplicatePubkeys(pubkeys); len(duplicates) > 0 {
+		return fmt.Errorf("validator %s is listed more than once (as %s)", duplicates[0].Pubkey, pubkeys[duplicates[0].FirstPosition-1])
+	}
+
+	for _, exit := range b.Exits {
+		// A zero amount is a full exit, which must be requested explicitly
+		if exit.AmountGwei == 0 && !exit.FullExit {
+			return fmt.Errorf("validator %s has zero amount but confirmFullExit is not set. This exit will fail", exit.Pubkey)
+		}
+		if exit.AmountGwei != 0 && exit.FullExit {
+			return fmt.Errorf("validator %s doesn't have a zero amount but confirmFullExit is set. This exit will fail", exit.Pubkey)
+		}
+	}
+	return nil
+}
+
+// exitData matches the tuple expected by batchELExit
+type exitData struct {
+	Pubkey     []byte
+	Amount     uint64
+	IsFullExit bool
+}
+
+// Pack implements Batch
+func (b ELExitBatch) Pack(contractABI abi.ABI) ([]byte, error) {
+	exits := make([]exitData, 0, len(b.Exits))
+	for _, exit := range b.Exits {
+		exits = append(exits, exitData{
+			Pubkey:     common.FromHex(exit.Pubkey),
+			Amount:     exit.AmountGwei,
+			IsFullExit: exit.FullExit,
+		})
+	}
+	return contractABI.Pack(MethodELExit, exits)
+}
+
+// pubkeyBytes decodes hex public keys, with or without 0x prefix
+func pubkeyBytes(pubkeys []string) [][]byte {
+	decoded := make([][]byte, 0, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		decoded = append(decoded, common.FromHex(pubkey))
+	}
+	return decoded
+}
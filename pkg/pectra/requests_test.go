@@ -0,0 +1,109 @@
+package pectra_test
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Luganodes/Pectra-CLI/internal/calldata"
+	"github.com/Luganodes/Pectra-CLI/pkg/pectra"
+)
+
+// Public keys of hoodi validators, written the way calldata.Decode formats them
+const (
+	pubkeyA = "b5a2635ef8d420a0c5d23341c638dd11a500aefa8f7d9fc1f726edbf8163f4e0b727f47faa57b91af50c13e863f13142"
+	pubkeyB = "b5f27dae0d6623d953252405056ce3a56ddf575de95c46cb212d396ffe4ccf0f905c138a897e8bde2e7e146705f88306"
+	pubkeyC = "880165dbbc70136744d942a450317d9e2cb4684eb460e33b0171ccfa4ddac99eb93c5603b95511d0f7b388f47ebcd36f"
+)
+
+// repeat returns the public key n times
+func repeat(pubkey string, n int) []string {
+	pubkeys := make([]string, n)
+	for i := range pubkeys {
+		pubkeys[i] = pubkey
+	}
+	return pubkeys
+}
+
+func TestBatchValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   pectra.Batch
+		wantErr string
+	}{
+		{name: "switch", batch: pectra.SwitchBatch{Validators: []string{pubkeyA, "0x" + pubkeyB}}},
+		{name: "switch without validators", batch: pectra.SwitchBatch{}, wantErr: "no validators"},
+		{name: "switch above the maximum", batch: pectra.SwitchBatch{Validators: repeat(pubkeyA, pectra.MaxSwitchValidators+1)}, wantErr: "a maximum of"},
+		{name: "switch with an invalid key", batch: pectra.SwitchBatch{Validators: []string{pubkeyA, pubkeyA[:94]}}, wantErr: "public key #2"},
+		{name: "consolidation", batch: pectra.ConsolidationBatch{Sources: []string{pubkeyA, pubkeyB}, Target: pubkeyC}},
+		{name: "consolidation without target", batch: pectra.ConsolidationBatch{Sources: []string{pubkeyA}}, wantErr: "not specified"},
+		{name: "consolidation above the maximum", batch: pectra.ConsolidationBatch{Sources: repeat(pubkeyA, pectra.MaxConsolidationValidators+1), Target: pubkeyC}, wantErr: "a maximum of"},
+		{name: "consolidation into a source", batch: pectra.ConsolidationBatch{Sources: []string{pubkeyA, pubkeyB}, Target: "0x" + strings.ToUpper(pubkeyB)}, wantErr: "cannot be in the list"},
+		{name: "consolidation with an invalid target", batch: pectra.ConsolidationBatch{Sources: []string{pubkeyA}, Target: "0x1234"}, wantErr: "invalid target"},
+		{name: "exits", batch: pectra.ELExitBatch{Exits: []pectra.Exit{{Pubkey: pubkeyA, AmountGwei: 1_000_000_000}, {Pubkey: pubkeyB, FullExit: true}}}},
+		{name: "exits without validators", batch: pectra.ELExitBatch{}, wantErr: "no validators"},
+		{name: "exit of zero without full exit", batch: pectra.ELExitBatch{Exits: []pectra.Exit{{Pubkey: pubkeyA}}}, wantErr: "zero amount"},
+		{name: "full exit with an amount", batch: pectra.ELExitBatch{Exits: []pectra.Exit{{Pubkey: pubkeyA, AmountGwei: 1, FullExit: true}}}, wantErr: "confirmFullExit is set"},
+		{name: "exits of one validator twice", batch: pectra.ELExitBatch{Exits: []pectra.Exit{{Pubkey: pubkeyA, AmountGwei: 1}, {Pubkey: "0x" + pubkeyA, AmountGwei: 2}}}, wantErr: "more than once"},
+		{name: "exit with an invalid key", batch: pectra.ELExitBatch{Exits: []pectra.Exit{{Pubkey: "zz", AmountGwei: 1}}}, wantErr: "public key zz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.batch.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestPackDecode checks that calldata.Decode reads back the requests packed by each batch
+func TestPackDecode(t *testing.T) {
+	contractABI, err := pectra.ABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := []pectra.Batch{
+		pectra.SwitchBatch{Validators: []string{pubkeyA, pubkeyB, pubkeyC}},
+		pectra.ConsolidationBatch{Sources: []string{pubkeyA, pubkeyB}, Target: pubkeyC},
+		pectra.ELExitBatch{Exits: []pectra.Exit{
+			{Pubkey: pubkeyA, AmountGwei: 1_000_000_000},
+			{Pubkey: pubkeyB, FullExit: true},
+		}},
+	}
+	for _, batch := range batches {
+		t.Run(batch.Method(), func(t *testing.T) {
+			fee := big.NewInt(3)
+			call, err := pectra.BuildCall(contractABI, batch, fee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := new(big.Int).Mul(fee, big.NewInt(int64(batch.Len()))); call.Value.Cmp(want) != 0 {
+				t.Errorf("value = %s, want %s", call.Value, want)
+			}
+
+			decoded, err := calldata.Decode(contractABI, call.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Method != batch.Method() {
+				t.Errorf("method = %s, want %s", decoded.Method, batch.Method())
+			}
+			got, err := decoded.Batch()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, batch) {
+				t.Errorf("decoded batch = %+v, want %+v", got, batch)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package pectra
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+)
+
+// Authorization returns the unsigned EIP-7702 authorization delegating the EOA to the contract.
+// nonce is the current nonce of the EOA. When the EOA sends the transaction itself its nonce is
+// incremented before the authorization is processed, so the authorization uses the next one.
+func Authorization(chainID *big.Int, contract common.Address, nonce uint64, sentByAuthority bool) (types.SetCodeAuthorization, error) {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return types.SetCodeAuthorization{}, fmt.Errorf("invalid chain ID %v", chainID)
+	}
+	id, overflow := uint256.FromBig(chainID)
+	if overflow {
+		return types.SetCodeAuthorization{}, fmt.Errorf("chain ID %s overflows 256 bits", chainID)
+	}
+	if sentByAuthority {
+		nonce++
+	}
+	return types.SetCodeAuthorization{
+		ChainID: *id,
+		Address: contract,
+		Nonce:   nonce,
+	}, nil
+}
+
+// TxParams are the parameters of a SetCode transaction calling a delegated EOA
+type TxParams struct {
+	ChainID *big.Int
+	// Nonce is the nonce of the sender, which is the EOA itself unless the transaction is sponsored
+	Nonce     uint64
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+	// Gas is the gas limit, DefaultGasLimit is used if it is not set
+	Gas uint64
+	// Authority is the delegated EOA, the transaction calls it
+	Authority common.Address
+	Data      []byte
+	Value     *uint256.Int
+}
+
+// Validate checks that the chain ID and the fees are set and fit in 256 bits
+func (p TxParams) Validate() error {
+	for _, field := range []struct {
+		name  string
+		value *big.Int
+	}{
+		{"chain ID", p.ChainID},
+		{"gas tip cap", p.GasTipCap},
+		{"gas fee cap", p.GasFeeCap},
+	} {
+		switch {
+		case field.value == nil:
+			return fmt.Errorf("%s is not set", field.name)
+		case field.value.Sign() < 0:
+			return fmt.Errorf("%s %s is negative", field.name, field.value)
+		case field.value.BitLen() > 256:
+			return fmt.Errorf("%s %s overflows 256 bits", field.name, field.value)
+		}
+	}
+	if p.ChainID.Sign() == 0 {
+		return fmt.Errorf("chain ID is zero")
+	}
+	if p.GasTipCap.Cmp(p.GasFeeCap) > 0 {
+		return fmt.Errorf("gas tip cap %s exceeds the gas fee cap %s", p.GasTipCap, p.GasFeeCap)
+	}
+	return nil
+}
+
+// NewSetCodeTx builds the unsigned SetCode transaction calling the authority with the authorization.
+// It returns an error if the parameters are invalid.
+func NewSetCodeTx(params TxParams, authorization types.SetCodeAuthorization) (*types.Transaction, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	gas := params.Gas
+	if gas == 0 {
+		gas = DefaultGasLimit
+	}
+	value := params.Value
+	if value == nil {
+		value = uint256.NewInt(0)
+	}
+
+	return types.NewTx(&types.SetCodeTx{
+		ChainID:   uint256.MustFromBig(params.ChainID),
+		Nonce:     params.Nonce,
+		GasTipCap: uint256.MustFromBig(params.GasTipCap),
+		GasFeeCap: uint256.MustFromBig(params.GasFeeCap),
+		Gas:       gas,
+		To:        params.Authority,
+		Value:     value,
+		Data:      params.Data,
+		AuthList:  []types.SetCodeAuthorization{authorization},
+	}), nil
+}
+
+// UnsignedTransaction is a SetCode transaction making the requests of a batch, ready to be signed
+type UnsignedTransaction struct {
+	Tx *types.Transaction
+	// Authorization is the unsigned authorization included in Tx, it must be signed by the authority
+	// before the transaction is signed by the sender
+	Authorization types.SetCodeAuthorization
+	Call          *Call
+	Authority     common.Address
+}
+
+// Builder builds the SetCode transactions delegating EOAs to the batch contract and calling it
+type Builder struct {
+	ABI      abi.ABI
+	Contract common.Address
+}
+
+// NewBuilder returns a builder for the batch contract deployed at the address
+func NewBuilder(contract common.Address) (*Builder, error) {
+	contractABI, err := ABI()
+	if err != nil {
+		return nil, err
+	}
+	return &Builder{ABI: contractABI, Contract: contract}, nil
+}
+
+// Build validates and packs the batch and returns the unsigned transaction sent by the EOA itself.
+// params.Authority, params.Nonce and the fees must be set, params.Data and params.Value are set
+// from the batch.
+func (b *Builder) Build(batch Batch, feePerRequest *big.Int, params TxParams) (*UnsignedTransaction, error) {
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid transaction parameters: %w", err)
+	}
+	authorization, err := Authorization(params.ChainID, b.Contract, params.Nonce, true)
+	if err != nil {
+		return nil, err
+	}
+	return b.build(batch, feePerRequest, params, authorization)
+}
+
+// build packs the batch into the transaction carrying the authorization
+func (b *Builder) build(batch Batch, feePerRequest *big.Int, params TxParams, authorization types.SetCodeAuthorization) (*UnsignedTransaction, error) {
+	call, err := BuildCall(b.ABI, batch, feePerRequest)
+	if err != nil {
+		return nil, err
+	}
+	value, overflow := uint256.FromBig(call.Value)
+	if overflow {
+		return nil, fmt.Errorf("value %s overflows", call.Value)
+	}
+
+	params.Data = call.Data
+	params.Value = value
+	tx, err := NewSetCodeTx(params, authorization)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction parameters: %w", err)
+	}
+	return &UnsignedTransaction{
+		Tx:            tx,
+		Authorization: authorization,
+		Call:          call,
+		Authority:     params.Authority,
+	}, nil
+}
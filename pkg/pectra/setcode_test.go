@@ -0,0 +1,93 @@
+package pectra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var testContract = common.HexToAddress("0xe264B0F3e491Ab5aEd2C0A32956cb9e68707F457")
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		name            string
+		chainID         *big.Int
+		nonce           uint64
+		sentByAuthority bool
+		wantNonce       uint64
+		wantErr         bool
+	}{
+		// The nonce of the EOA sending the transaction is incremented before the authorization is processed
+		{name: "self-sent", chainID: big.NewInt(560048), nonce: 5, sentByAuthority: true, wantNonce: 6},
+		{name: "sponsored", chainID: big.NewInt(560048), nonce: 5, sentByAuthority: false, wantNonce: 5},
+		{name: "self-sent first transaction", chainID: big.NewInt(1), nonce: 0, sentByAuthority: true, wantNonce: 1},
+		{name: "nil chain ID", chainID: nil, wantErr: true},
+		{name: "zero chain ID", chainID: big.NewInt(0), wantErr: true},
+		{name: "negative chain ID", chainID: big.NewInt(-1), wantErr: true},
+		{name: "chain ID overflow", chainID: new(big.Int).Lsh(big.NewInt(1), 256), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authorization, err := Authorization(test.chainID, testContract, test.nonce, test.sentByAuthority)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if authorization.Nonce != test.wantNonce {
+				t.Errorf("nonce = %d, want %d", authorization.Nonce, test.wantNonce)
+			}
+			if authorization.Address != testContract {
+				t.Errorf("address = %s, want %s", authorization.Address.Hex(), testContract.Hex())
+			}
+			if authorization.ChainID.ToBig().Cmp(test.chainID) != 0 {
+				t.Errorf("chain ID = %s, want %s", authorization.ChainID.ToBig(), test.chainID)
+			}
+		})
+	}
+}
+
+func TestTxParamsValidate(t *testing.T) {
+	valid := func() TxParams {
+		return TxParams{ChainID: big.NewInt(560048), GasTipCap: big.NewInt(10), GasFeeCap: big.NewInt(1000)}
+	}
+	tests := []struct {
+		name    string
+		modify  func(*TxParams)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(p *TxParams) {}},
+		{name: "zero fees", modify: func(p *TxParams) { p.GasTipCap, p.GasFeeCap = big.NewInt(0), big.NewInt(0) }},
+		{name: "zero tip", modify: func(p *TxParams) { p.GasTipCap = big.NewInt(0) }},
+		{name: "nil chain ID", modify: func(p *TxParams) { p.ChainID = nil }, wantErr: true},
+		{name: "zero chain ID", modify: func(p *TxParams) { p.ChainID = big.NewInt(0) }, wantErr: true},
+		{name: "nil tip", modify: func(p *TxParams) { p.GasTipCap = nil }, wantErr: true},
+		{name: "nil fee cap", modify: func(p *TxParams) { p.GasFeeCap = nil }, wantErr: true},
+		{name: "negative fee cap", modify: func(p *TxParams) { p.GasFeeCap = big.NewInt(-1) }, wantErr: true},
+		{name: "fee cap overflow", modify: func(p *TxParams) { p.GasFeeCap = new(big.Int).Lsh(big.NewInt(1), 256) }, wantErr: true},
+		{name: "tip above fee cap", modify: func(p *TxParams) { p.GasTipCap = big.NewInt(1001) }, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := valid()
+			test.modify(&params)
+			err := params.Validate()
+			if test.wantErr && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Fatal(err)
+			}
+			// NewSetCodeTx must refuse what Validate refuses instead of panicking
+			if _, err := NewSetCodeTx(params, types.SetCodeAuthorization{}); test.wantErr != (err != nil) {
+				t.Errorf("NewSetCodeTx error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package pectra
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// hexRegex matches a hexadecimal string
+var hexRegex = regexp.MustCompile("^[0-9a-fA-F]+$")
+
+// PubkeyError is an invalid or duplicate public key of a list, with its position in the list
+// counted from 1. Position is 0 for a key of a map, such as elExit.validators, which has no
+// position: the key itself identifies the entry.
+type PubkeyError struct {
+	Position int
+	Pubkey   string
+	Reason   string
+}
+
+// Error implements error
+func (e *PubkeyError) Error() string {
+	if e.Position == 0 {
+		return fmt.Sprintf("public key %s: %s", e.Pubkey, e.Reason)
+	}
+	return fmt.Sprintf("public key #%d %s: %s", e.Position, e.Pubkey, e.Reason)
+}
+
+// Duplicate is a public key listed again, the same validator as an earlier entry once
+// normalized
+type Duplicate struct {
+	Position      int
+	FirstPosition int
+	Pubkey        string
+}
+
+// NormalizePubkey returns the public key in lowercase with a "0x" prefix, so the same validator
+// written with another case or without prefix compares equal
+func NormalizePubkey(pubkey string) string {
+	if strings.HasPrefix(pubkey, "0x") || strings.HasPrefix(pubkey, "0X") {
+		pubkey = pubkey[2:]
+	}
+	return "0x" + strings.ToLower(pubkey)
+}
+
+// ValidatePubkey checks that the public key is a 48-byte hex string, optionally prefixed with
+// "0x", holding a valid compressed BLS12-381 G1 point
+func ValidatePubkey(pubkey string) error {
+	processedPubkey := strings.TrimPrefix(NormalizePubkey(pubkey), "0x")
+
+	// Check length (48 bytes = 96 hex characters)
+	if len(processedPubkey) != 2*PubkeyLength {
+		return fmt.Errorf("invalid length: expected %d hex characters, got %d", 2*PubkeyLength, len(processedPubkey))
+	}
+	// Check for valid hexadecimal characters
+	if !hexRegex.MatchString(processedPubkey) {
+		return fmt.Errorf("contains non-hexadecimal characters")
+	}
+	if !validG1Point(common.FromHex(processedPubkey)) {
+		return fmt.Errorf("not a valid compressed BLS12-381 G1 point")
+	}
+	return nil
+}
+
+// ValidatePubkeys checks if the provided validator public keys are valid, reporting every
+// invalid key with its position in the list as a *PubkeyError
+func ValidatePubkeys(pubkeys []string) error {
+	var errs []error
+	for i, pubkey := range pubkeys {
+		if err := ValidatePubkey(pubkey); err != nil {
+			errs = append(errs, &PubkeyError{Position: i + 1, Pubkey: pubkey, Reason: err.Error()})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidatePubkeyKeys checks the public keys of a map, such as elExit.validators, reporting every
+// invalid key by itself as a *PubkeyError without position, in the order of pubkeys
+func ValidatePubkeyKeys(pubkeys []string) error {
+	var errs []error
+	for _, pubkey := range pubkeys {
+		if err := ValidatePubkey(pubkey); err != nil {
+			errs = append(errs, &PubkeyError{Pubkey: pubkey, Reason: err.Error()})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DuplicatePubkeys returns the public keys listing again a validator of an earlier position, once
+// case and prefix are normalized
+func DuplicatePubkeys(pubkeys []string) []Duplicate {
+	first := make(map[string]int)
+	var duplicates []Duplicate
+	for i, pubkey := range pubkeys {
+		normalized := NormalizePubkey(pubkey)
+		if position, ok := first[normalized]; ok {
+			duplicates = append(duplicates, Duplicate{Position: i + 1, FirstPosition: position, Pubkey: pubkey})
+			continue
+		}
+		first[normalized] = i + 1
+	}
+	return duplicates
+}
+
+// SamePubkey reports whether the public keys are the same validator, ignoring case and prefix
+func SamePubkey(a, b string) bool {
+	return NormalizePubkey(a) == NormalizePubkey(b)
+}
+
+// RemoveDuplicatePubkeys takes a slice of validator public keys and returns a new slice
+// containing only unique validators. Keys are compared normalized, the first occurrence is kept
+// as written.
+func RemoveDuplicatePubkeys(pubkeys []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+
+	for _, pubkey := range pubkeys {
+		normalized := NormalizePubkey(pubkey)
+		if _, ok := seen[normalized]; !ok {
+			seen[normalized] = true
+			result = append(result, pubkey)
+		}
+	}
+	return result
+}
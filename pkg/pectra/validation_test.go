@@ -0,0 +1,100 @@
+package pectra
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// These tests build without tags, so they check the blst build with cgo and the gnark-crypto
+// build without it against the same keys
+
+const (
+	validPubkey = "b5a2635ef8d420a0c5d23341c638dd11a500aefa8f7d9fc1f726edbf8163f4e0b727f47faa57b91af50c13e863f13142"
+	otherPubkey = "b5f27dae0d6623d953252405056ce3a56ddf575de95c46cb212d396ffe4ccf0f905c138a897e8bde2e7e146705f88306"
+	// x = 1 has no y on the curve, x^3 + 4 is not a square
+	offCurvePubkey = "80" + "0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000001"
+	// x = 4 is on the curve but outside the prime order subgroup
+	outsideSubgroupPubkey = "80" + "0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000004"
+	infinityPubkey        = "c0" + "0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"
+)
+
+func TestValidatePubkey(t *testing.T) {
+	tests := []struct {
+		name    string
+		pubkey  string
+		wantErr string
+	}{
+		{name: "valid", pubkey: validPubkey},
+		{name: "valid with prefix", pubkey: "0x" + validPubkey},
+		{name: "valid in uppercase", pubkey: "0X" + strings.ToUpper(validPubkey)},
+		{name: "off curve", pubkey: offCurvePubkey, wantErr: "not a valid compressed BLS12-381 G1 point"},
+		{name: "outside the subgroup", pubkey: outsideSubgroupPubkey, wantErr: "not a valid compressed BLS12-381 G1 point"},
+		{name: "point at infinity", pubkey: infinityPubkey, wantErr: "not a valid compressed BLS12-381 G1 point"},
+		{name: "uncompressed flag", pubkey: "3" + validPubkey[1:], wantErr: "not a valid compressed BLS12-381 G1 point"},
+		{name: "too short", pubkey: validPubkey[:94], wantErr: "invalid length"},
+		{name: "too long", pubkey: validPubkey + "00", wantErr: "invalid length"},
+		{name: "empty", pubkey: "", wantErr: "invalid length"},
+		{name: "non-hexadecimal", pubkey: "zz" + validPubkey[2:], wantErr: "non-hexadecimal"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidatePubkey(test.pubkey)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePubkeysPositions(t *testing.T) {
+	err := ValidatePubkeys([]string{validPubkey, infinityPubkey, otherPubkey, validPubkey[:10]})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"public key #2 ", "public key #4 "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not report %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "#1 ") || strings.Contains(err.Error(), "#3 ") {
+		t.Errorf("error %q reports a valid key", err)
+	}
+}
+
+func TestDuplicatePubkeys(t *testing.T) {
+	pubkeys := []string{
+		validPubkey,
+		"0x" + validPubkey,
+		otherPubkey,
+		strings.ToUpper(validPubkey),
+		"0X" + strings.ToUpper(otherPubkey),
+	}
+
+	want := []Duplicate{
+		{Position: 2, FirstPosition: 1, Pubkey: pubkeys[1]},
+		{Position: 4, FirstPosition: 1, Pubkey: pubkeys[3]},
+		{Position: 5, FirstPosition: 3, Pubkey: pubkeys[4]},
+	}
+	if got := DuplicatePubkeys(pubkeys); !reflect.DeepEqual(got, want) {
+		t.Errorf("DuplicatePubkeys = %+v, want %+v", got, want)
+	}
+
+	// The first occurrence is kept as written
+	if got := RemoveDuplicatePubkeys(pubkeys); !reflect.DeepEqual(got, []string{validPubkey, otherPubkey}) {
+		t.Errorf("RemoveDuplicatePubkeys = %v", got)
+	}
+
+	if !SamePubkey("0x"+validPubkey, strings.ToUpper(validPubkey)) {
+		t.Error("keys differing only by case and prefix are not the same validator")
+	}
+	if SamePubkey(validPubkey, otherPubkey) {
+		t.Error("different keys are the same validator")
+	}
+}
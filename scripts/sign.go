@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/Luganodes/Pectra-CLI/internal/config"
+	"github.com/Luganodes/Pectra-CLI/internal/transaction"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -32,14 +37,21 @@ func main() {
 
 	// Parse the JSON
 	var txData struct {
-		UnsignedTransaction string `json:"unsignedTransaction"`
-		ChainId             string `json:"chainId"`
+		UnsignedTransaction   string          `json:"unsignedTransaction"`
+		UnsignedAuthorization json.RawMessage `json:"unsignedAuthorization"`
+		ChainId               string          `json:"chainId"`
 	}
 
 	if err := json.Unmarshal(data, &txData); err != nil {
 		log.Fatalf("Failed to parse JSON: %v", err)
 	}
 
+	// In sponsor mode only the authorization is signed here, the sponsor sends the transaction
+	if len(txData.UnsignedAuthorization) > 0 {
+		signAuthorization(privateKey, data, outputFileName(inputFile, "signed_authorization.json"))
+		return
+	}
+
 	hexTx := txData.UnsignedTransaction
 
 	// Remove 0x prefix if present
@@ -60,12 +72,15 @@ func main() {
 		log.Fatalf("Failed to decode transaction: %v", err)
 	}
 
-	signedAuthorization, err := types.SignSetCode(privateKey, tx.SetCodeAuthorizations()[0])
-	if err != nil {
-		log.Fatalf("failed to sign the authorization: %v", err)
-	}
+	// Transactions assembled from an authorization signed earlier only need the transaction signature
+	if authorization := tx.SetCodeAuthorizations()[0]; authorization.R.IsZero() && authorization.S.IsZero() {
+		signedAuthorization, err := types.SignSetCode(privateKey, authorization)
+		if err != nil {
+			log.Fatalf("failed to sign the authorization: %v", err)
+		}
 
-	tx.SetCodeAuthorizations()[0] = signedAuthorization
+		tx.SetCodeAuthorizations()[0] = signedAuthorization
+	}
 
 	tx, err = types.SignTx(tx, types.LatestSignerForChainID(tx.ChainId()), privateKey)
 	if err != nil {
@@ -87,10 +102,50 @@ func main() {
 		log.Fatalf("Failed to marshal to JSON: %v", err)
 	}
 
-	outputFile := "signed_txn.json"
+	outputFile := outputFileName(inputFile, "signed_txn.json")
 	if err := os.WriteFile(outputFile, jsonData, 0644); err != nil {
 		log.Fatalf("Failed to write to %s: %v", outputFile, err)
 	}
 
 	fmt.Printf("Signed transaction written to %s\n", outputFile)
 }
+
+// outputFileName derives the name of the signed file from the unsigned one, e.g.
+// unsigned_restore_txn.json becomes signed_restore_txn.json
+func outputFileName(inputFile, fallback string) string {
+	if base := filepath.Base(inputFile); strings.HasPrefix(base, "unsigned_") {
+		return filepath.Join(filepath.Dir(inputFile), strings.TrimPrefix(base, "un"))
+	}
+	return fallback
+}
+
+// signAuthorization signs the EIP-7702 authorization of an authorization request file
+func signAuthorization(privateKey *ecdsa.PrivateKey, data []byte, outputFile string) {
+	var request transaction.AuthorizationRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		log.Fatalf("Failed to parse authorization request: %v", err)
+	}
+
+	if address := crypto.PubkeyToAddress(privateKey.PublicKey); address != request.Authority {
+		log.Fatalf("Private key belongs to %s, but the authorization is for %s", address.Hex(), request.Authority.Hex())
+	}
+
+	signedAuthorization, err := types.SignSetCode(privateKey, *request.UnsignedAuthorization)
+	if err != nil {
+		log.Fatalf("failed to sign the authorization: %v", err)
+	}
+
+	request.UnsignedAuthorization = nil
+	request.SignedAuthorization = &signedAuthorization
+
+	jsonData, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal to JSON: %v", err)
+	}
+
+	if err := os.WriteFile(outputFile, jsonData, 0644); err != nil {
+		log.Fatalf("Failed to write to %s: %v", outputFile, err)
+	}
+
+	fmt.Printf("Signed authorization written to %s\n", outputFile)
+}